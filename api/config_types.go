@@ -1,23 +1,81 @@
 package api
 
-import "time"
+import (
+	"slices"
+	"time"
+)
+
+// ServerTransportHTTPTLSConfig represents the TLS configuration for the HTTP transport
+type ServerTransportHTTPTLSConfig struct {
+	Enabled        bool          `yaml:"enabled"`
+	CertFile       string        `yaml:"cert_file"`
+	KeyFile        string        `yaml:"key_file"`
+	MinVersion     string        `yaml:"min_version,omitempty"`
+	ClientCAFile   string        `yaml:"client_ca_file,omitempty"`
+	ClientAuth     string        `yaml:"client_auth,omitempty"`
+	ReloadInterval time.Duration `yaml:"reload_interval,omitempty"`
+}
 
 // ServerTransportHTTPConfig represents the HTTP transport configuration
 type ServerTransportHTTPConfig struct {
-	Host string `yaml:"host"`
+	Host string                       `yaml:"host"`
+	TLS  ServerTransportHTTPTLSConfig `yaml:"tls,omitempty"`
+
+	// TrustForwardedHeaders honours 'X-Forwarded-Proto' and 'X-Forwarded-Host' to know the URI requested by clients.
+	// Only enable it behind a proxy overwriting them, as clients reaching the server directly can forge them
+	TrustForwardedHeaders bool `yaml:"trust_forwarded_headers,omitempty"`
+}
+
+// ServerTransportSSEConfig represents the SSE transport configuration
+type ServerTransportSSEConfig struct {
+	BaseURL         string `yaml:"base_url,omitempty"`
+	SSEEndpoint     string `yaml:"sse_endpoint,omitempty"`
+	MessageEndpoint string `yaml:"message_endpoint,omitempty"`
 }
 
 // ServerTransportConfig represents the transport configuration
 type ServerTransportConfig struct {
 	Type string                    `yaml:"type"`
 	HTTP ServerTransportHTTPConfig `yaml:"http,omitempty"`
+	SSE  ServerTransportSSEConfig  `yaml:"sse,omitempty"`
+}
+
+// ServerHealthConfig represents the liveness and readiness endpoints configuration
+type ServerHealthConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	LivenessPath  string        `yaml:"liveness_path,omitempty"`
+	ReadinessPath string        `yaml:"readiness_path,omitempty"`
+	CheckTimeout  time.Duration `yaml:"check_timeout,omitempty"`
+}
+
+// ServerMetricsConfig represents the Prometheus metrics endpoint configuration
+type ServerMetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Host    string `yaml:"host,omitempty"`
+	Path    string `yaml:"path,omitempty"`
+}
+
+// ServerConfigReloadConfig represents the configuration for reloading the config file without restarting
+type ServerConfigReloadConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+// ServerSecretsConfig represents the configuration for resolving the secret references present in the config
+type ServerSecretsConfig struct {
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
 }
 
 // ServerConfig represents the server configuration section
 type ServerConfig struct {
-	Name      string                `yaml:"name"`
-	Version   string                `yaml:"version"`
-	Transport ServerTransportConfig `yaml:"transport,omitempty"`
+	Name            string                   `yaml:"name"`
+	Version         string                   `yaml:"version"`
+	Transport       ServerTransportConfig    `yaml:"transport,omitempty"`
+	ShutdownTimeout time.Duration            `yaml:"shutdown_timeout,omitempty"`
+	Health          ServerHealthConfig       `yaml:"health,omitempty"`
+	Metrics         ServerMetricsConfig      `yaml:"metrics,omitempty"`
+	ConfigReload    ServerConfigReloadConfig `yaml:"config_reload,omitempty"`
+	Secrets         ServerSecretsConfig      `yaml:"secrets,omitempty"`
 }
 
 // AccessLogsConfig represents the AccessLogs middleware configuration
@@ -28,9 +86,47 @@ type AccessLogsConfig struct {
 
 // JWTValidationLocalConfig represents the local JWT validation configuration
 type JWTValidationLocalConfig struct {
-	JWKSUri         string                        `yaml:"jwks_uri"`
-	CacheInterval   time.Duration                 `yaml:"cache_interval"`
-	AllowConditions []JWTValidationAllowCondition `yaml:"allow_conditions,omitempty"`
+	IssuerUri          string                        `yaml:"issuer_uri,omitempty"`
+	DiscoveryInterval  time.Duration                 `yaml:"discovery_interval,omitempty"`
+	JWKSUri            string                        `yaml:"jwks_uri"`
+	JWKSFile           string                        `yaml:"jwks_file,omitempty"`
+	JWKS               string                        `yaml:"jwks,omitempty"`
+	PublicKeys         []JWTValidationPublicKey      `yaml:"public_keys,omitempty"`
+	CacheInterval      time.Duration                 `yaml:"cache_interval"`
+	MinRefreshInterval time.Duration                 `yaml:"min_refresh_interval,omitempty"`
+	StartupTimeout     time.Duration                 `yaml:"startup_timeout,omitempty"`
+	Issuers            []string                      `yaml:"issuers,omitempty"`
+	Audiences          []string                      `yaml:"audiences,omitempty"`
+	Algorithms         []string                      `yaml:"algorithms,omitempty"`
+	AllowSymmetricKeys bool                          `yaml:"allow_symmetric_keys,omitempty"`
+	X5CCAFile          string                        `yaml:"x5c_ca_file,omitempty"`
+	Leeway             time.Duration                 `yaml:"leeway,omitempty"`
+	RequiredClaims     []string                      `yaml:"required_claims,omitempty"`
+	MaxTokenAge        time.Duration                 `yaml:"max_token_age,omitempty"`
+	AllowConditions    []JWTValidationAllowCondition `yaml:"allow_conditions,omitempty"`
+	TrustedIssuers     []JWTValidationTrustedIssuer  `yaml:"trusted_issuers,omitempty"`
+}
+
+// JWTValidationTrustedIssuer represents an issuer whose tokens are accepted by the local JWT validation,
+// with its own keys and policies. When no key source is configured, 'jwks_uri' is discovered from the issuer metadata
+type JWTValidationTrustedIssuer struct {
+	Issuer             string                        `yaml:"issuer"`
+	JWKSUri            string                        `yaml:"jwks_uri,omitempty"`
+	JWKSFile           string                        `yaml:"jwks_file,omitempty"`
+	JWKS               string                        `yaml:"jwks,omitempty"`
+	PublicKeys         []JWTValidationPublicKey      `yaml:"public_keys,omitempty"`
+	Audiences          []string                      `yaml:"audiences,omitempty"`
+	Algorithms         []string                      `yaml:"algorithms,omitempty"`
+	AllowSymmetricKeys bool                          `yaml:"allow_symmetric_keys,omitempty"`
+	X5CCAFile          string                        `yaml:"x5c_ca_file,omitempty"`
+	AllowConditions    []JWTValidationAllowCondition `yaml:"allow_conditions,omitempty"`
+}
+
+// JWTValidationPublicKey represents a PEM encoded public key, or certificate, trusted for the tokens carrying its 'kid'
+type JWTValidationPublicKey struct {
+	Kid       string `yaml:"kid"`
+	PEM       string `yaml:"pem"`
+	Algorithm string `yaml:"algorithm,omitempty"`
 }
 
 // JWTValidationAllowCondition represents a condition for allowing a request after the local JWT validation configuration
@@ -38,11 +134,78 @@ type JWTValidationAllowCondition struct {
 	Expression string `yaml:"expression"`
 }
 
+// JWTValidationIntrospectionConfig represents the validation of tokens through the introspection endpoint
+// of the authorization server, used for opaque tokens. Ref: https://datatracker.ietf.org/doc/html/rfc7662
+type JWTValidationIntrospectionConfig struct {
+	Endpoint         string                        `yaml:"endpoint"`
+	ClientID         string                        `yaml:"client_id"`
+	ClientSecret     string                        `yaml:"client_secret"`
+	AuthMethod       string                        `yaml:"auth_method,omitempty"`
+	Timeout          time.Duration                 `yaml:"timeout,omitempty"`
+	CacheTTL         time.Duration                 `yaml:"cache_ttl,omitempty"`
+	CacheMaxEntries  int                           `yaml:"cache_max_entries,omitempty"`
+	NegativeCacheTTL time.Duration                 `yaml:"negative_cache_ttl,omitempty"`
+	AllowConditions  []JWTValidationAllowCondition `yaml:"allow_conditions,omitempty"`
+}
+
+// JWTValidationAPIKeyConfig represents the authentication of machine clients with static API keys.
+// It is the only method with strategy 'api_key'. With other strategies, it is used when 'enabled'
+// and the request carries the header
+type JWTValidationAPIKeyConfig struct {
+	Enabled bool                  `yaml:"enabled,omitempty"`
+	Header  string                `yaml:"header,omitempty"`
+	Keys    []JWTValidationAPIKey `yaml:"keys,omitempty"`
+}
+
+// JWTValidationAPIKey represents an API key, stored as a salted hash, and the claims given to its callers
+type JWTValidationAPIKey struct {
+	Name      string    `yaml:"name"`
+	Hash      string    `yaml:"hash"`
+	Subject   string    `yaml:"subject,omitempty"`
+	Groups    []string  `yaml:"groups,omitempty"`
+	Scopes    []string  `yaml:"scopes,omitempty"`
+	ExpiresAt time.Time `yaml:"expires_at,omitempty"`
+}
+
+// JWTValidationRevocationConfig represents the denylist of revoked credentials, checked after validating them.
+// Entries are read from a watched file, and managed through the admin endpoint when enabled
+type JWTValidationRevocationConfig struct {
+	Enabled        bool                               `yaml:"enabled"`
+	File           string                             `yaml:"file,omitempty"`
+	ReloadInterval time.Duration                      `yaml:"reload_interval,omitempty"`
+	Admin          JWTValidationRevocationAdminConfig `yaml:"admin,omitempty"`
+}
+
+// JWTValidationRevocationAdminConfig represents the HTTP endpoint managing revocations at runtime.
+// Callers are authenticated as any other request, and they need every one of 'scopes' and some of 'groups'
+type JWTValidationRevocationAdminConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Path       string        `yaml:"path,omitempty"`
+	Scopes     []string      `yaml:"scopes,omitempty"`
+	Groups     []string      `yaml:"groups,omitempty"`
+	DefaultTTL time.Duration `yaml:"default_ttl,omitempty"`
+}
+
+// Policy represents a named CEL rule checked for authenticated requests or tool calls.
+// With effect 'allow', requests not matching the expression are denied. With effect 'deny', matching ones are.
+// In dry-run, the denials are only logged
+type Policy struct {
+	Name       string `yaml:"name"`
+	Expression string `yaml:"expression"`
+	Effect     string `yaml:"effect,omitempty"`
+	Message    string `yaml:"message,omitempty"`
+	DryRun     bool   `yaml:"dry_run,omitempty"`
+}
+
 // JWTValidationConfig represents the JWT validation configuration
 type JWTValidationConfig struct {
-	Strategy        string                   `yaml:"strategy"`
-	ForwardedHeader string                   `yaml:"forwarded_header,omitempty"`
-	Local           JWTValidationLocalConfig `yaml:"local,omitempty"`
+	Strategy        string                           `yaml:"strategy"`
+	ForwardedHeader string                           `yaml:"forwarded_header,omitempty"`
+	Local           JWTValidationLocalConfig         `yaml:"local,omitempty"`
+	Introspection   JWTValidationIntrospectionConfig `yaml:"introspection,omitempty"`
+	APIKey          JWTValidationAPIKeyConfig        `yaml:"api_key,omitempty"`
+	Revocation      JWTValidationRevocationConfig    `yaml:"revocation,omitempty"`
+	Policies        []Policy                         `yaml:"policies,omitempty"`
 }
 
 // JWTConfig represents the JWT middleware configuration
@@ -51,10 +214,38 @@ type JWTConfig struct {
 	Validation JWTValidationConfig `yaml:"validation,omitempty"`
 }
 
+// ToolMiddlewareConfig represents a middleware in the chain wrapping tool handlers.
+// It applies to all tools in 'include' (all of them when empty), except those in 'exclude'
+type ToolMiddlewareConfig struct {
+	Name    string   `yaml:"name"`
+	Include []string `yaml:"include,omitempty"`
+	Exclude []string `yaml:"exclude,omitempty"`
+}
+
+// ToolScopesConfig represents the scopes a caller needs, all of them, to see and call a tool
+type ToolScopesConfig struct {
+	Tool   string   `yaml:"tool"`
+	Scopes []string `yaml:"scopes"`
+}
+
+// ToolAuthorizationConfig represents the checks done before calling a tool: the scopes required by each tool,
+// and the policies over the claims of the caller, the tool name and its arguments
+type ToolAuthorizationConfig struct {
+	ToolScopes []ToolScopesConfig `yaml:"tool_scopes,omitempty"`
+	Policies   []Policy           `yaml:"policies,omitempty"`
+}
+
 // MiddlewareConfig represents the middleware configuration section
 type MiddlewareConfig struct {
-	AccessLogs AccessLogsConfig `yaml:"access_logs"`
-	JWT        JWTConfig        `yaml:"jwt,omitempty"`
+	AccessLogs        AccessLogsConfig        `yaml:"access_logs"`
+	JWT               JWTConfig               `yaml:"jwt,omitempty"`
+	Tools             []ToolMiddlewareConfig  `yaml:"tools,omitempty"`
+	ToolAuthorization ToolAuthorizationConfig `yaml:"tool_authorization,omitempty"`
+}
+
+// IsToolAuthorizationConfigured returns whether tools must be checked by the 'authorization' middleware before being called
+func (c *MiddlewareConfig) IsToolAuthorizationConfigured() bool {
+	return len(c.ToolAuthorization.ToolScopes) > 0 || len(c.ToolAuthorization.Policies) > 0
 }
 
 // OAuthAuthorizationServer represents the OAuth Authorization Server configuration
@@ -88,4 +279,22 @@ type Configuration struct {
 	Middleware               MiddlewareConfig             `yaml:"middleware,omitempty"`
 	OAuthAuthorizationServer OAuthAuthorizationServer     `yaml:"oauth_authorization_server,omitempty"`
 	OAuthProtectedResource   OAuthProtectedResourceConfig `yaml:"oauth_protected_resource,omitempty"`
+
+	// SecretReferences maps the YAML path of the fields resolved from a secret reference to that reference.
+	// It is filled when the config is read, so resolved values can be hidden when the config is marshalled
+	SecretReferences map[string]string `yaml:"-"`
+}
+
+// GetScopesSupported returns the scopes advertised by the protected resource: the configured ones,
+// followed by the ones required by the tools
+func (c *Configuration) GetScopesSupported() []string {
+	scopes := slices.Clone(c.OAuthProtectedResource.ScopesSupported)
+	for _, toolScopes := range c.Middleware.ToolAuthorization.ToolScopes {
+		for _, scope := range toolScopes.Scopes {
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes
 }
@@ -0,0 +1,559 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	//
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// signingAlgorithms are the JWS algorithms supported for validating tokens
+	signingAlgorithms = []string{
+		"RS256", "RS384", "RS512", "PS256", "PS384", "PS512",
+		"ES256", "ES384", "ES512", "EdDSA", "HS256", "HS384", "HS512",
+	}
+)
+
+// ValidationError represents a problem found in a config field, identified by its YAML path
+type ValidationError struct {
+	Path    string
+	Line    int
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s (line %d): %s", e.Path, e.Line, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+// ValidationErrors represents all the problems found in a configuration
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, validationError := range e {
+		messages = append(messages, "  - "+validationError.Error())
+	}
+	return "invalid configuration:\n" + strings.Join(messages, "\n")
+}
+
+// Validate checks enums, durations, URLs and the fields required by each enabled feature.
+// It returns ValidationErrors when some problem is found
+func (c *Configuration) Validate() error {
+	v := &validator{}
+
+	c.Server.validate(v, "server")
+	c.Middleware.validate(v, "middleware")
+	c.OAuthAuthorizationServer.validate(v, "oauth_authorization_server", c.Middleware.JWT.Validation.Local.IssuerUri)
+	c.OAuthProtectedResource.validate(v, "oauth_protected_resource")
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+func (c *ServerConfig) validate(v *validator, path string) {
+	v.oneOf(path+".transport.type", c.Transport.Type, "", "stdio", "http", "sse")
+	v.nonNegative(path+".shutdown_timeout", c.ShutdownTimeout)
+
+	if c.Transport.Type == "http" || c.Transport.Type == "sse" {
+		v.required(path+".transport.http.host", c.Transport.HTTP.Host)
+	}
+
+	if c.Transport.Type == "sse" {
+		v.url(path+".transport.sse.base_url", c.Transport.SSE.BaseURL)
+		v.urlPath(path+".transport.sse.sse_endpoint", c.Transport.SSE.SSEEndpoint)
+		v.urlPath(path+".transport.sse.message_endpoint", c.Transport.SSE.MessageEndpoint)
+	}
+
+	if c.Transport.HTTP.TLS.Enabled {
+		tlsPath := path + ".transport.http.tls"
+		v.required(tlsPath+".cert_file", c.Transport.HTTP.TLS.CertFile)
+		v.required(tlsPath+".key_file", c.Transport.HTTP.TLS.KeyFile)
+		v.oneOf(tlsPath+".min_version", c.Transport.HTTP.TLS.MinVersion, "", "1.2", "1.3")
+		v.oneOf(tlsPath+".client_auth", c.Transport.HTTP.TLS.ClientAuth, "", "require", "optional")
+		v.nonNegative(tlsPath+".reload_interval", c.Transport.HTTP.TLS.ReloadInterval)
+	}
+
+	if c.Health.Enabled {
+		v.urlPath(path+".health.liveness_path", c.Health.LivenessPath)
+		v.urlPath(path+".health.readiness_path", c.Health.ReadinessPath)
+		v.nonNegative(path+".health.check_timeout", c.Health.CheckTimeout)
+	}
+
+	if c.Metrics.Enabled {
+		v.urlPath(path+".metrics.path", c.Metrics.Path)
+	}
+
+	if c.ConfigReload.Enabled {
+		v.nonNegative(path+".config_reload.interval", c.ConfigReload.Interval)
+	}
+
+	v.nonNegative(path+".secrets.refresh_interval", c.Secrets.RefreshInterval)
+}
+
+func (c *MiddlewareConfig) validate(v *validator, path string) {
+	for i, header := range c.AccessLogs.ExcludedHeaders {
+		v.headerName(fmt.Sprintf("%s.access_logs.excluded_headers[%d]", path, i), header)
+	}
+
+	for i, header := range c.AccessLogs.RedactedHeaders {
+		v.headerName(fmt.Sprintf("%s.access_logs.redacted_headers[%d]", path, i), header)
+	}
+
+	// Revocations are checked by the JWT middleware, which does nothing when disabled
+	if !c.JWT.Enabled && c.JWT.Validation.Revocation.Enabled {
+		v.add(path+".jwt.validation.revocation.enabled", "'middleware.jwt.enabled' is required")
+	}
+
+	if c.JWT.Enabled {
+		validationPath := path + ".jwt.validation"
+		v.oneOf(validationPath+".strategy", c.JWT.Validation.Strategy, "local", "external", "introspection", "api_key")
+		v.headerName(validationPath+".forwarded_header", c.JWT.Validation.ForwardedHeader)
+
+		if c.JWT.Validation.Strategy == "local" {
+			localPath := validationPath + ".local"
+			// Keys are published in the JWKS, which can be discovered from the issuer
+			localConfig := c.JWT.Validation.Local
+			keySourcesConfigured := hasKeySources(localConfig.JWKSUri, localConfig.JWKSFile, localConfig.JWKS, localConfig.PublicKeys)
+			if localConfig.IssuerUri == "" && len(localConfig.TrustedIssuers) == 0 && !keySourcesConfigured {
+				v.required(localPath+".jwks_uri", localConfig.JWKSUri)
+			}
+			v.url(localPath+".jwks_uri", localConfig.JWKSUri)
+			v.url(localPath+".issuer_uri", localConfig.IssuerUri)
+			validateKeySources(v, localPath, localConfig.JWKS, localConfig.PublicKeys)
+			v.nonNegative(localPath+".discovery_interval", localConfig.DiscoveryInterval)
+
+			// Keys are only refreshed from remotes
+			if localConfig.JWKSUri != "" || localConfig.IssuerUri != "" {
+				v.positive(localPath+".cache_interval", localConfig.CacheInterval)
+			} else {
+				v.nonNegative(localPath+".cache_interval", localConfig.CacheInterval)
+			}
+			v.nonNegative(localPath+".min_refresh_interval", c.JWT.Validation.Local.MinRefreshInterval)
+			v.nonNegative(localPath+".startup_timeout", c.JWT.Validation.Local.StartupTimeout)
+			v.nonNegative(localPath+".leeway", c.JWT.Validation.Local.Leeway)
+			v.nonNegative(localPath+".max_token_age", c.JWT.Validation.Local.MaxTokenAge)
+
+			for i, issuer := range c.JWT.Validation.Local.Issuers {
+				v.required(fmt.Sprintf("%s.issuers[%d]", localPath, i), issuer)
+			}
+
+			for i, audience := range c.JWT.Validation.Local.Audiences {
+				v.required(fmt.Sprintf("%s.audiences[%d]", localPath, i), audience)
+			}
+
+			for i, algorithm := range c.JWT.Validation.Local.Algorithms {
+				v.oneOf(fmt.Sprintf("%s.algorithms[%d]", localPath, i), algorithm, signingAlgorithms...)
+			}
+
+			for i, claim := range c.JWT.Validation.Local.RequiredClaims {
+				v.required(fmt.Sprintf("%s.required_claims[%d]", localPath, i), claim)
+			}
+
+			for i, allowCondition := range c.JWT.Validation.Local.AllowConditions {
+				v.required(fmt.Sprintf("%s.allow_conditions[%d].expression", localPath, i), allowCondition.Expression)
+			}
+
+			issuers := map[string]bool{}
+			for i, trustedIssuer := range c.JWT.Validation.Local.TrustedIssuers {
+				issuerPath := fmt.Sprintf("%s.trusted_issuers[%d]", localPath, i)
+				trustedIssuer.validate(v, issuerPath)
+
+				if issuers[trustedIssuer.Issuer] {
+					v.add(issuerPath+".issuer", "issuer %q is duplicated", trustedIssuer.Issuer)
+				}
+				issuers[trustedIssuer.Issuer] = true
+			}
+		}
+
+		if c.JWT.Validation.Strategy == "introspection" {
+			c.JWT.Validation.Introspection.validate(v, validationPath+".introspection")
+		}
+
+		if c.JWT.Validation.Strategy == "api_key" || c.JWT.Validation.APIKey.Enabled {
+			c.JWT.Validation.APIKey.validate(v, validationPath+".api_key")
+		}
+
+		if c.JWT.Validation.Revocation.Enabled {
+			c.JWT.Validation.Revocation.validate(v, validationPath+".revocation")
+		}
+
+		validatePolicies(v, validationPath+".policies", c.JWT.Validation.Policies)
+	}
+
+	for i, toolMiddleware := range c.Tools {
+		v.required(fmt.Sprintf("%s.tools[%d].name", path, i), toolMiddleware.Name)
+	}
+
+	tools := map[string]bool{}
+	for i, toolScopes := range c.ToolAuthorization.ToolScopes {
+		toolScopesPath := fmt.Sprintf("%s.tool_authorization.tool_scopes[%d]", path, i)
+		v.required(toolScopesPath+".tool", toolScopes.Tool)
+
+		if len(toolScopes.Scopes) == 0 {
+			v.add(toolScopesPath+".scopes", "at least one scope is required")
+		}
+		for j, scope := range toolScopes.Scopes {
+			v.required(fmt.Sprintf("%s.scopes[%d]", toolScopesPath, j), scope)
+		}
+
+		if tools[toolScopes.Tool] {
+			v.add(toolScopesPath+".tool", "tool %q is duplicated", toolScopes.Tool)
+		}
+		tools[toolScopes.Tool] = true
+	}
+
+	validatePolicies(v, path+".tool_authorization.policies", c.ToolAuthorization.Policies)
+
+	// Tool authorization is only enforced by its middleware, so a chain leaving it out would silently disable it
+	if c.IsToolAuthorizationConfigured() && len(c.Tools) > 0 {
+		i := slices.IndexFunc(c.Tools, func(toolMiddleware ToolMiddlewareConfig) bool {
+			return toolMiddleware.Name == "authorization"
+		})
+		switch {
+		case i < 0:
+			v.add(path+".tools", "'authorization' is required when 'tool_authorization' is configured")
+		case len(c.Tools[i].Include) > 0 || len(c.Tools[i].Exclude) > 0:
+			v.add(fmt.Sprintf("%s.tools[%d]", path, i), "'authorization' must apply to every tool when 'tool_authorization' is configured")
+		}
+	}
+}
+
+// validatePolicies checks the fields of a list of policies, whose names must be unique
+func validatePolicies(v *validator, path string, policies []Policy) {
+	policyNames := map[string]bool{}
+	for i, policy := range policies {
+		policyPath := fmt.Sprintf("%s[%d]", path, i)
+		v.required(policyPath+".name", policy.Name)
+		v.required(policyPath+".expression", policy.Expression)
+		v.oneOf(policyPath+".effect", policy.Effect, "", "allow", "deny")
+
+		if policyNames[policy.Name] {
+			v.add(policyPath+".name", "policy %q is duplicated", policy.Name)
+		}
+		policyNames[policy.Name] = true
+	}
+}
+
+func (c *JWTValidationTrustedIssuer) validate(v *validator, path string) {
+	v.required(path+".issuer", c.Issuer)
+	v.url(path+".jwks_uri", c.JWKSUri)
+	validateKeySources(v, path, c.JWKS, c.PublicKeys)
+
+	// Issuer is used for discovering the keys when they are not configured
+	if !hasKeySources(c.JWKSUri, c.JWKSFile, c.JWKS, c.PublicKeys) {
+		v.url(path+".issuer", c.Issuer)
+	}
+
+	for i, audience := range c.Audiences {
+		v.required(fmt.Sprintf("%s.audiences[%d]", path, i), audience)
+	}
+
+	for i, algorithm := range c.Algorithms {
+		v.oneOf(fmt.Sprintf("%s.algorithms[%d]", path, i), algorithm, signingAlgorithms...)
+	}
+
+	for i, allowCondition := range c.AllowConditions {
+		v.required(fmt.Sprintf("%s.allow_conditions[%d].expression", path, i), allowCondition.Expression)
+	}
+}
+
+// hasKeySources returns whether some source of keys is configured for the local JWT validation
+func hasKeySources(jwksUri string, jwksFile string, jwks string, publicKeys []JWTValidationPublicKey) bool {
+	return jwksUri != "" || jwksFile != "" || jwks != "" || len(publicKeys) > 0
+}
+
+// validateKeySources checks the keys configured inline. Key IDs must be unique, as tokens choose their key by them
+func validateKeySources(v *validator, path string, jwks string, publicKeys []JWTValidationPublicKey) {
+	if jwks != "" && !json.Valid([]byte(jwks)) {
+		v.add(path+".jwks", "invalid JSON Web Key Set: it must be a JSON document")
+	}
+
+	kids := map[string]bool{}
+	for i, publicKey := range publicKeys {
+		publicKeyPath := fmt.Sprintf("%s.public_keys[%d]", path, i)
+		v.required(publicKeyPath+".kid", publicKey.Kid)
+		v.required(publicKeyPath+".pem", publicKey.PEM)
+
+		if publicKey.PEM != "" {
+			if block, _ := pem.Decode([]byte(publicKey.PEM)); block == nil {
+				v.add(publicKeyPath+".pem", "invalid PEM: no block found")
+			}
+		}
+
+		if publicKey.Algorithm != "" {
+			v.oneOf(publicKeyPath+".algorithm", publicKey.Algorithm, signingAlgorithms...)
+		}
+
+		if kids[publicKey.Kid] {
+			v.add(publicKeyPath+".kid", "kid %q is duplicated", publicKey.Kid)
+		}
+		kids[publicKey.Kid] = true
+	}
+}
+
+func (c *JWTValidationIntrospectionConfig) validate(v *validator, path string) {
+	v.required(path+".endpoint", c.Endpoint)
+	v.url(path+".endpoint", c.Endpoint)
+	v.required(path+".client_id", c.ClientID)
+	v.oneOf(path+".auth_method", c.AuthMethod, "", "client_secret_basic", "client_secret_post")
+	v.nonNegative(path+".timeout", c.Timeout)
+	v.nonNegative(path+".cache_ttl", c.CacheTTL)
+	v.nonNegative(path+".negative_cache_ttl", c.NegativeCacheTTL)
+
+	if c.CacheMaxEntries < 0 {
+		v.add(path+".cache_max_entries", "must not be negative, got %d", c.CacheMaxEntries)
+	}
+
+	for i, allowCondition := range c.AllowConditions {
+		v.required(fmt.Sprintf("%s.allow_conditions[%d].expression", path, i), allowCondition.Expression)
+	}
+}
+
+func (c *JWTValidationRevocationConfig) validate(v *validator, path string) {
+	v.nonNegative(path+".reload_interval", c.ReloadInterval)
+
+	if c.Admin.Enabled {
+		v.urlPath(path+".admin.path", c.Admin.Path)
+		v.nonNegative(path+".admin.default_ttl", c.Admin.DefaultTTL)
+
+		// Anyone able to authenticate could lift revocations otherwise
+		if len(c.Admin.Scopes) == 0 && len(c.Admin.Groups) == 0 {
+			v.add(path+".admin", "at least one of 'scopes' or 'groups' is required")
+		}
+		for i, scope := range c.Admin.Scopes {
+			v.required(fmt.Sprintf("%s.admin.scopes[%d]", path, i), scope)
+		}
+		for i, group := range c.Admin.Groups {
+			v.required(fmt.Sprintf("%s.admin.groups[%d]", path, i), group)
+		}
+	}
+}
+
+func (c *JWTValidationAPIKeyConfig) validate(v *validator, path string) {
+	// Header defaults to 'X-API-Key'
+	if c.Header != "" {
+		v.headerName(path+".header", c.Header)
+	}
+
+	names := map[string]bool{}
+	for i, key := range c.Keys {
+		keyPath := fmt.Sprintf("%s.keys[%d]", path, i)
+		v.required(keyPath+".name", key.Name)
+		v.required(keyPath+".hash", key.Hash)
+
+		// Keys are presented as '<name>.<secret>', so the name can not contain the separator
+		if strings.Contains(key.Name, ".") {
+			v.add(keyPath+".name", "must not contain '.'")
+		}
+
+		// Only salted hashes are accepted, so keys are never written in clear
+		if key.Hash != "" {
+			if err := validateAPIKeyHash(key.Hash); err != nil {
+				v.add(keyPath+".hash", "%s", err.Error())
+			}
+		}
+
+		if names[key.Name] {
+			v.add(keyPath+".name", "key %q is duplicated", key.Name)
+		}
+		names[key.Name] = true
+
+		for j, group := range key.Groups {
+			v.required(fmt.Sprintf("%s.groups[%d]", keyPath, j), group)
+		}
+
+		for j, scope := range key.Scopes {
+			v.required(fmt.Sprintf("%s.scopes[%d]", keyPath, j), scope)
+		}
+	}
+}
+
+// validateAPIKeyHash checks a hash is in one of the formats API keys are verified with: argon2id (PHC), bcrypt or sha256
+func validateAPIKeyHash(hash string) error {
+	parts := strings.Split(hash, "$")
+
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		var version int
+		var memory, iterations uint32
+		var threads uint8
+		if len(parts) != 6 {
+			return fmt.Errorf("expected format: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>")
+		}
+		if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+			return fmt.Errorf("unsupported argon2 version: %s", parts[2])
+		}
+		if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+			return fmt.Errorf("error parsing argon2 parameters: %s", err.Error())
+		}
+		for _, part := range parts[4:] {
+			if decoded, err := base64.RawStdEncoding.DecodeString(part); err != nil || len(decoded) == 0 {
+				return fmt.Errorf("salt and hash must be base64 encoded, without padding")
+			}
+		}
+
+	case strings.HasPrefix(hash, "$2"):
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("invalid bcrypt hash: %s", err.Error())
+		}
+
+	case strings.HasPrefix(hash, "$sha256$"):
+		if len(parts) != 4 || parts[2] == "" {
+			return fmt.Errorf("expected format: $sha256$<salt>$<hex hash>")
+		}
+		if decoded, err := hex.DecodeString(parts[3]); err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("hash must be a hex encoded sha256")
+		}
+
+	default:
+		return fmt.Errorf("must be an argon2id, bcrypt or sha256 salted hash")
+	}
+
+	return nil
+}
+
+func (c *OAuthAuthorizationServer) validate(v *validator, path string, jwtIssuerUri string) {
+	if !c.Enabled {
+		return
+	}
+
+	// Issuer defaults to the one trusted by the JWT validation
+	if jwtIssuerUri == "" {
+		v.required(path+".issuer_uri", c.IssuerUri)
+	}
+	v.url(path+".issuer_uri", c.IssuerUri)
+}
+
+func (c *OAuthProtectedResourceConfig) validate(v *validator, path string) {
+	if !c.Enabled {
+		return
+	}
+
+	v.required(path+".resource", c.Resource)
+	v.url(path+".jwks_uri", c.JWKSUri)
+	v.url(path+".resource_documentation", c.ResourceDocumentation)
+	v.url(path+".resource_policy_uri", c.ResourcePolicyUri)
+	v.url(path+".resource_tos_uri", c.ResourceTosUri)
+
+	for i, authServer := range c.AuthServers {
+		v.url(fmt.Sprintf("%s.auth_servers[%d]", path, i), authServer)
+	}
+}
+
+// validator collects the problems found while checking the configuration
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) add(path string, format string, args ...any) {
+	v.errs = append(v.errs, ValidationError{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// required checks the value is not empty
+func (v *validator) required(path string, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.add(path, "field is required")
+	}
+}
+
+// oneOf checks the value is one of the allowed ones
+func (v *validator) oneOf(path string, value string, allowed ...string) {
+	for _, allowedValue := range allowed {
+		if value == allowedValue {
+			return
+		}
+	}
+
+	var printable []string
+	for _, allowedValue := range allowed {
+		if allowedValue != "" {
+			printable = append(printable, allowedValue)
+		}
+	}
+	v.add(path, "unsupported value %q, expected one of: %s", value, strings.Join(printable, ", "))
+}
+
+// url checks the value, when present, is an absolute HTTP(S) URL
+func (v *validator) url(path string, value string) {
+	if value == "" {
+		return
+	}
+
+	parsedUrl, err := url.Parse(value)
+	if err != nil {
+		v.add(path, "invalid URL: %s", err.Error())
+		return
+	}
+
+	if (parsedUrl.Scheme != "http" && parsedUrl.Scheme != "https") || parsedUrl.Host == "" {
+		v.add(path, "invalid URL %q: it must be absolute and use http or https scheme", value)
+	}
+}
+
+// urlPath checks the value, when present, is an absolute URL path
+func (v *validator) urlPath(path string, value string) {
+	if value != "" && !strings.HasPrefix(value, "/") {
+		v.add(path, "invalid path %q: it must start with '/'", value)
+	}
+}
+
+// headerName checks the value is a valid HTTP header name
+func (v *validator) headerName(path string, value string) {
+	if value == "" {
+		v.add(path, "field is required")
+		return
+	}
+
+	for _, char := range value {
+		if !isHeaderNameChar(char) {
+			v.add(path, "invalid header name %q", value)
+			return
+		}
+	}
+}
+
+// nonNegative checks the duration is zero or greater
+func (v *validator) nonNegative(path string, value time.Duration) {
+	if value < 0 {
+		v.add(path, "duration must not be negative, got %s", value)
+	}
+}
+
+// positive checks the duration is greater than zero
+func (v *validator) positive(path string, value time.Duration) {
+	if value <= 0 {
+		v.add(path, "duration must be greater than zero, got %s", value)
+	}
+}
+
+// isHeaderNameChar returns true when the char is allowed in an HTTP header name
+// Ref: https://datatracker.ietf.org/doc/html/rfc9110#section-5.6.2
+func isHeaderNameChar(char rune) bool {
+	switch {
+	case char >= 'a' && char <= 'z', char >= 'A' && char <= 'Z', char >= '0' && char <= '9':
+		return true
+	case strings.ContainsRune("!#$%&'*+-.^_`|~", char):
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestConfiguration returns a valid config served over HTTP, validating tokens locally
+func newTestConfiguration() *Configuration {
+	config := &Configuration{}
+	config.Server.Transport.Type = "http"
+	config.Server.Transport.HTTP.Host = ":8080"
+	config.Middleware.JWT.Enabled = true
+	config.Middleware.JWT.Validation.Strategy = "local"
+	config.Middleware.JWT.Validation.ForwardedHeader = "X-Validated-Jwt"
+	config.Middleware.JWT.Validation.Local.JWKSUri = "https://issuer.example.com/certs"
+	config.Middleware.JWT.Validation.Local.CacheInterval = 10 * time.Second
+	return config
+}
+
+func TestConfigurationValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		configure func(config *Configuration)
+		wantErrs  []string
+	}{
+		{
+			name: "valid config",
+		},
+		{
+			name: "empty config is valid for stdio",
+			configure: func(config *Configuration) {
+				*config = Configuration{}
+			},
+		},
+		{
+			name: "unsupported transport",
+			configure: func(config *Configuration) {
+				config.Server.Transport.Type = "grpc"
+			},
+			wantErrs: []string{`server.transport.type: unsupported value "grpc", expected one of: stdio, http, sse`},
+		},
+		{
+			name: "negative durations",
+			configure: func(config *Configuration) {
+				config.Server.ShutdownTimeout = -time.Second
+				config.Middleware.JWT.Validation.Local.CacheInterval = 0
+			},
+			wantErrs: []string{
+				"server.shutdown_timeout: duration must not be negative, got -1s",
+				"middleware.jwt.validation.local.cache_interval: duration must be greater than zero, got 0s",
+			},
+		},
+		{
+			name: "TLS requires its files and known values",
+			configure: func(config *Configuration) {
+				config.Server.Transport.HTTP.TLS.Enabled = true
+				config.Server.Transport.HTTP.TLS.MinVersion = "1.1"
+				config.Server.Transport.HTTP.TLS.ClientAuth = "always"
+			},
+			wantErrs: []string{
+				"server.transport.http.tls.cert_file: field is required",
+				"server.transport.http.tls.key_file: field is required",
+				`server.transport.http.tls.min_version: unsupported value "1.1", expected one of: 1.2, 1.3`,
+				`server.transport.http.tls.client_auth: unsupported value "always", expected one of: require, optional`,
+			},
+		},
+		{
+			name: "SSE endpoints must be absolute URL and paths",
+			configure: func(config *Configuration) {
+				config.Server.Transport.Type = "sse"
+				config.Server.Transport.SSE.BaseURL = "mcp.example.com"
+				config.Server.Transport.SSE.SSEEndpoint = "sse"
+			},
+			wantErrs: []string{
+				`server.transport.sse.base_url: invalid URL "mcp.example.com": it must be absolute and use http or https scheme`,
+				`server.transport.sse.sse_endpoint: invalid path "sse": it must start with '/'`,
+			},
+		},
+		{
+			name: "strategy typo",
+			configure: func(config *Configuration) {
+				config.Middleware.JWT.Validation.Strategy = "lcoal"
+			},
+			wantErrs: []string{`middleware.jwt.validation.strategy: unsupported value "lcoal", expected one of: local, external, introspection, api_key`},
+		},
+		{
+			name: "strategy is not checked when JWT validation is disabled",
+			configure: func(config *Configuration) {
+				config.Middleware.JWT.Enabled = false
+				config.Middleware.JWT.Validation.Strategy = "lcoal"
+			},
+		},
+		{
+			name: "invalid header names",
+			configure: func(config *Configuration) {
+				config.Middleware.JWT.Validation.ForwardedHeader = ""
+				config.Middleware.AccessLogs.RedactedHeaders = []string{"Authorization", "X Token"}
+			},
+			wantErrs: []string{
+				`middleware.access_logs.redacted_headers[1]: invalid header name "X Token"`,
+				"middleware.jwt.validation.forwarded_header: field is required",
+			},
+		},
+		{
+			name: "local strategy requires a JWKS URI",
+			configure: func(config *Configuration) {
+				config.Middleware.JWT.Validation.Local.JWKSUri = ""
+				config.Middleware.JWT.Validation.Local.AllowConditions = []JWTValidationAllowCondition{{Expression: " "}}
+			},
+			wantErrs: []string{
+				"middleware.jwt.validation.local.jwks_uri: field is required",
+				"middleware.jwt.validation.local.allow_conditions[0].expression: field is required",
+			},
+		},
+		{
+			name: "URLs must be absolute HTTP(S) ones",
+			configure: func(config *Configuration) {
+				config.Middleware.JWT.Validation.Local.JWKSUri = "file:///etc/jwks.json"
+				config.OAuthAuthorizationServer.Enabled = true
+				config.OAuthAuthorizationServer.IssuerUri = "://issuer"
+			},
+			wantErrs: []string{
+				`middleware.jwt.validation.local.jwks_uri: invalid URL "file:///etc/jwks.json": it must be absolute and use http or https scheme`,
+				`oauth_authorization_server.issuer_uri: invalid URL: parse "://issuer": missing protocol scheme`,
+			},
+		},
+		{
+			name: "protected resource requires its identifier",
+			configure: func(config *Configuration) {
+				config.OAuthProtectedResource.Enabled = true
+				config.OAuthProtectedResource.AuthServers = []string{"https://issuer.example.com", "issuer"}
+			},
+			wantErrs: []string{
+				"oauth_protected_resource.resource: field is required",
+				`oauth_protected_resource.auth_servers[1]: invalid URL "issuer": it must be absolute and use http or https scheme`,
+			},
+		},
+		{
+			name: "tool middlewares require a name",
+			configure: func(config *Configuration) {
+				config.Middleware.Tools = []ToolMiddlewareConfig{{Name: "metrics"}, {}}
+			},
+			wantErrs: []string{"middleware.tools[1].name: field is required"},
+		},
+		{
+			name: "revocation admin requires scopes or groups",
+			configure: func(config *Configuration) {
+				config.Middleware.JWT.Validation.Revocation.Enabled = true
+				config.Middleware.JWT.Validation.Revocation.Admin.Enabled = true
+			},
+			wantErrs: []string{"middleware.jwt.validation.revocation.admin: at least one of 'scopes' or 'groups' is required"},
+		},
+		{
+			name: "revocation admin scopes and groups must not be empty",
+			configure: func(config *Configuration) {
+				config.Middleware.JWT.Validation.Revocation.Enabled = true
+				config.Middleware.JWT.Validation.Revocation.Admin.Enabled = true
+				config.Middleware.JWT.Validation.Revocation.Admin.Scopes = []string{"revocations:admin", ""}
+				config.Middleware.JWT.Validation.Revocation.Admin.Groups = []string{" "}
+			},
+			wantErrs: []string{
+				"middleware.jwt.validation.revocation.admin.scopes[1]: field is required",
+				"middleware.jwt.validation.revocation.admin.groups[0]: field is required",
+			},
+		},
+		{
+			name: "revocations require JWT validation",
+			configure: func(config *Configuration) {
+				config.Middleware.JWT.Enabled = false
+				config.Middleware.JWT.Validation.Revocation.Enabled = true
+			},
+			wantErrs: []string{"middleware.jwt.validation.revocation.enabled: 'middleware.jwt.enabled' is required"},
+		},
+		{
+			name: "API key hashes are validated on load",
+			configure: func(config *Configuration) {
+				config.Middleware.JWT.Validation.APIKey.Enabled = true
+				config.Middleware.JWT.Validation.APIKey.Keys = []JWTValidationAPIKey{
+					{Name: "sha.bot", Hash: "$sha256$salt$not-hex"},
+					{Name: "argon-bot", Hash: "$argon2id$v=19$m=65536,t=3,p=4$c2FsdA"},
+					{Name: "bcrypt-bot", Hash: "$2a$10$short"},
+					{Name: "plain-bot", Hash: "s3cr3t"},
+				}
+			},
+			wantErrs: []string{
+				"middleware.jwt.validation.api_key.keys[0].name: must not contain '.'",
+				"middleware.jwt.validation.api_key.keys[0].hash: hash must be a hex encoded sha256",
+				"middleware.jwt.validation.api_key.keys[1].hash: expected format: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>",
+				"middleware.jwt.validation.api_key.keys[2].hash: invalid bcrypt hash: crypto/bcrypt: hashedSecret too short to be a bcrypted password",
+				"middleware.jwt.validation.api_key.keys[3].hash: must be an argon2id, bcrypt or sha256 salted hash",
+			},
+		},
+		{
+			name: "tool authorization requires its middleware in the chain",
+			configure: func(config *Configuration) {
+				config.Middleware.Tools = []ToolMiddlewareConfig{{Name: "metrics"}}
+				config.Middleware.ToolAuthorization.ToolScopes = []ToolScopesConfig{{Tool: "delete", Scopes: []string{"mcp:admin"}}}
+			},
+			wantErrs: []string{"middleware.tools: 'authorization' is required when 'tool_authorization' is configured"},
+		},
+		{
+			name: "tool authorization must apply to every tool",
+			configure: func(config *Configuration) {
+				config.Middleware.Tools = []ToolMiddlewareConfig{{Name: "metrics"}, {Name: "authorization", Exclude: []string{"delete"}}}
+				config.Middleware.ToolAuthorization.ToolScopes = []ToolScopesConfig{{Tool: "delete", Scopes: []string{"mcp:admin"}}}
+			},
+			wantErrs: []string{"middleware.tools[1]: 'authorization' must apply to every tool when 'tool_authorization' is configured"},
+		},
+		{
+			name: "tool authorization applies with the default chain",
+			configure: func(config *Configuration) {
+				config.Middleware.ToolAuthorization.ToolScopes = []ToolScopesConfig{{Tool: "delete", Scopes: []string{"mcp:admin"}}}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newTestConfiguration()
+			if tt.configure != nil {
+				tt.configure(config)
+			}
+
+			err := config.Validate()
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("Validate() error = %s, want none", err.Error())
+				}
+				return
+			}
+
+			var validationErrs ValidationErrors
+			if !errors.As(err, &validationErrs) {
+				t.Fatalf("Validate() error = %v, want validation errors", err)
+			}
+
+			var gotErrs []string
+			for _, validationErr := range validationErrs {
+				gotErrs = append(gotErrs, validationErr.Error())
+			}
+			if strings.Join(gotErrs, "\n") != strings.Join(tt.wantErrs, "\n") {
+				t.Errorf("Validate() errors =\n%s\nwant\n%s", strings.Join(gotErrs, "\n"), strings.Join(tt.wantErrs, "\n"))
+			}
+		})
+	}
+}
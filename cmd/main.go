@@ -1,18 +1,28 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
 	"time"
 
+	"mcp-go/internal/certificates"
 	"mcp-go/internal/globals"
 	"mcp-go/internal/handlers"
+	"mcp-go/internal/metrics"
 	"mcp-go/internal/middlewares"
 	"mcp-go/internal/tools"
 
 	"github.com/mark3labs/mcp-go/server"
 )
 
+const (
+	// defaultShutdownTimeout is the time given to drain in-flight work when no timeout is configured
+	defaultShutdownTimeout = 25 * time.Second
+)
+
 func main() {
 	// 0. Process the configuration
 	appCtx, err := globals.NewApplicationContext()
@@ -20,6 +30,16 @@ func main() {
 		log.Fatalf("failed creating application context: %v", err.Error())
 	}
 
+	// Components pick up config changes through their reload hooks
+	if appCtx.Config().Server.ConfigReload.Enabled {
+		go appCtx.WatchConfig()
+	}
+
+	// Reloads start it too when they bring the first secret references
+	if len(appCtx.Config().SecretReferences) > 0 {
+		appCtx.StartSecretsWatcher()
+	}
+
 	// 1. Initialize middlewares that need it
 	accessLogsMw := middlewares.NewAccessLogsMiddleware(middlewares.AccessLogsMiddlewareDependencies{
 		AppCtx: appCtx,
@@ -29,14 +49,40 @@ func main() {
 		AppCtx: appCtx,
 	})
 	if err != nil {
-		appCtx.Logger.Info("failed starting JWT validation middleware", "error", err.Error())
+		// Serving without the middleware would leave the protected endpoints open
+		appCtx.Logger.Error("failed starting JWT validation middleware", "error", err.Error())
+		os.Exit(1)
 	}
 
+	metricsMw := middlewares.NewMetricsMiddleware(middlewares.MetricsMiddlewareDependencies{
+		AppCtx: appCtx,
+	})
+
+	inFlightMw := middlewares.NewInFlightMiddleware(middlewares.InFlightMiddlewareDependencies{
+		AppCtx: appCtx,
+	})
+
+	toolMetricsMw := middlewares.NewToolMetricsMiddleware(middlewares.ToolMetricsMiddlewareDependencies{
+		AppCtx: appCtx,
+	})
+
+	toolAuthorizationMw, err := middlewares.NewToolAuthorizationMiddleware(middlewares.ToolAuthorizationMiddlewareDependencies{
+		AppCtx: appCtx,
+	})
+	if err != nil {
+		log.Fatalf("failed starting tool authorization middleware: %v", err.Error())
+	}
+
+	noopMw := middlewares.NewNoopMiddleware(middlewares.NoopMiddlewareDependencies{})
+
 	// 2. Create a new MCP server
+	// In-flight tracking is applied to all tools at server level, as graceful shutdown depends on it
 	mcpServer := server.NewMCPServer(
-		appCtx.Config.Server.Name,
-		appCtx.Config.Server.Version,
+		appCtx.Config().Server.Name,
+		appCtx.Config().Server.Version,
 		server.WithToolCapabilities(true),
+		server.WithToolHandlerMiddleware(inFlightMw.Middleware),
+		server.WithToolFilter(toolAuthorizationMw.FilterTools),
 	)
 
 	// 3. Initialize handlers for later usage
@@ -46,12 +92,20 @@ func main() {
 
 	// 4. Add some useful magic in the form of tools to your MCP server
 	// This is the most useful part
-	tm := tools.NewToolsManager(tools.ToolsManagerDependencies{
+	// Middlewares are applied to every tool, unless 'middleware.tools' selects them
+	tm, err := tools.NewToolsManager(tools.ToolsManagerDependencies{
 		AppCtx: appCtx,
 
-		McpServer:   mcpServer,
-		Middlewares: []middlewares.ToolMiddleware{},
+		McpServer: mcpServer,
+		Middlewares: []middlewares.ToolMiddleware{
+			toolMetricsMw,
+			toolAuthorizationMw,
+			noopMw,
+		},
 	})
+	if err != nil {
+		log.Fatalf("failed creating tools manager: %v", err.Error())
+	}
 	tm.AddTools()
 
 	// TODO: Include custom user-created logic like adding a ResourcesManager when needed
@@ -64,38 +118,218 @@ func main() {
 	// rm.AddResources()
 
 	// 5. Wrap MCP server in a transport (stdio, HTTP, SSE)
-	switch appCtx.Config.Server.Transport.Type {
-	case "http":
-		httpServer := server.NewStreamableHTTPServer(mcpServer,
-			server.WithHeartbeatInterval(30*time.Second),
-			server.WithStateLess(false))
+	// Transports are served in background, so the process can react to termination signals
+	serveErrors := make(chan error, 1)
 
-		// Register it under a path, then add custom endpoints.
+	metricsPath := appCtx.Config().Server.Metrics.Path
+	if metricsPath == "" {
+		metricsPath = "/metrics"
+	}
+
+	// Metrics are preferably exposed on a separate address, so they are not reachable from the public one
+	if appCtx.Config().Server.Metrics.Enabled && appCtx.Config().Server.Metrics.Host != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle(metricsPath, metrics.Handler())
+
+		metricsServer := &http.Server{
+			Addr:    appCtx.Config().Server.Metrics.Host,
+			Handler: metricsMux,
+		}
+
+		appCtx.RegisterStopHook(globals.StopPhaseServers, "metrics server", func(ctx context.Context) error {
+			return shutdownHTTPServer(ctx, metricsServer, metricsServer.Shutdown)
+		})
+
+		appCtx.Logger.Info("starting metrics server", "host", appCtx.Config().Server.Metrics.Host)
+		go func() {
+			err := metricsServer.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serveErrors <- err
+			}
+		}()
+	}
+
+	switch appCtx.Config().Server.Transport.Type {
+	case "http", "sse":
+		// Register the transport under its path(s), then add custom endpoints.
 		// Custom endpoints are needed as the library is not feature-complete according to MCP spec requirements (2025-06-16)
 		// Ref: https://modelcontextprotocol.io/specification/2025-06-18/basic/authorization#overview
 		mux := http.NewServeMux()
-		mux.Handle("/mcp", accessLogsMw.Middleware(jwtValidationMw.Middleware(httpServer)))
 
-		if appCtx.Config.OAuthAuthorizationServer.Enabled {
-			mux.Handle("/.well-known/oauth-authorization-server", accessLogsMw.Middleware(http.HandlerFunc(hm.HandleOauthAuthorizationServer)))
+		// Middlewares applied to every public endpoint, and to the ones requiring authentication
+		publicChain := func(next http.Handler) http.Handler {
+			return metricsMw.Middleware(accessLogsMw.Middleware(next))
+		}
+		protectedChain := func(next http.Handler) http.Handler {
+			return publicChain(jwtValidationMw.Middleware(next))
+		}
+
+		httpServer := &http.Server{
+			Addr:    appCtx.Config().Server.Transport.HTTP.Host,
+			Handler: mux,
+		}
+
+		if appCtx.Config().Server.Transport.Type == "sse" {
+			sseEndpoint := appCtx.Config().Server.Transport.SSE.SSEEndpoint
+			if sseEndpoint == "" {
+				sseEndpoint = "/sse"
+			}
+
+			messageEndpoint := appCtx.Config().Server.Transport.SSE.MessageEndpoint
+			if messageEndpoint == "" {
+				messageEndpoint = "/message"
+			}
+
+			sseServer := server.NewSSEServer(mcpServer,
+				server.WithHTTPServer(httpServer),
+				server.WithBaseURL(appCtx.Config().Server.Transport.SSE.BaseURL),
+				server.WithSSEEndpoint(sseEndpoint),
+				server.WithMessageEndpoint(messageEndpoint),
+				server.WithKeepAliveInterval(30*time.Second),
+				server.WithSSEContextFunc(jwtValidationMw.HTTPContextFunc))
+
+			mux.Handle(sseServer.CompleteSsePath(), protectedChain(sseServer.SSEHandler()))
+			mux.Handle(sseServer.CompleteMessagePath(), protectedChain(sseServer.MessageHandler()))
+
+			// SSE streams never become idle, so sessions are closed before shutting down the HTTP server
+			appCtx.RegisterStopHook(globals.StopPhaseServers, "sse server", func(ctx context.Context) error {
+				return shutdownHTTPServer(ctx, httpServer, sseServer.Shutdown)
+			})
+		} else {
+			streamableServer := server.NewStreamableHTTPServer(mcpServer,
+				server.WithHeartbeatInterval(30*time.Second),
+				server.WithStateLess(false),
+				server.WithHTTPContextFunc(jwtValidationMw.HTTPContextFunc))
+
+			mux.Handle("/mcp", protectedChain(streamableServer))
+
+			appCtx.RegisterStopHook(globals.StopPhaseServers, "http server", func(ctx context.Context) error {
+				return shutdownHTTPServer(ctx, httpServer, httpServer.Shutdown)
+			})
+		}
+
+		if appCtx.Config().OAuthAuthorizationServer.Enabled {
+			mux.Handle("/.well-known/oauth-authorization-server", publicChain(http.HandlerFunc(hm.HandleOauthAuthorizationServer)))
 		}
 
-		if appCtx.Config.OAuthProtectedResource.Enabled {
-			mux.Handle("/.well-known/oauth-protected-resource", accessLogsMw.Middleware(http.HandlerFunc(hm.HandleOauthProtectedResources)))
+		if appCtx.Config().OAuthProtectedResource.Enabled {
+			mux.Handle("/.well-known/oauth-protected-resource", publicChain(http.HandlerFunc(hm.HandleOauthProtectedResources)))
 		}
 
-		// Start StreamableHTTP server
-		appCtx.Logger.Info("starting StreamableHTTP server", "host", appCtx.Config.Server.Transport.HTTP.Host)
-		err := http.ListenAndServe(appCtx.Config.Server.Transport.HTTP.Host, mux)
-		if err != nil {
-			log.Fatal(err)
+		// Revocations are managed by authenticated admins, so the endpoint is protected as the MCP one
+		// It is never mounted without the middleware enabled, as callers would not be authenticated
+		revocationConfig := appCtx.Config().Middleware.JWT.Validation.Revocation
+		if appCtx.Config().Middleware.JWT.Enabled && revocationConfig.Enabled && revocationConfig.Admin.Enabled {
+			revocationsPath := revocationConfig.Admin.Path
+			if revocationsPath == "" {
+				revocationsPath = "/admin/revocations"
+			}
+
+			mux.Handle(revocationsPath, protectedChain(http.HandlerFunc(jwtValidationMw.HandleRevocations)))
+		}
+
+		// Probes are not wrapped by middlewares, so they don't flood access logs or need credentials
+		if appCtx.Config().Server.Health.Enabled {
+			livenessPath := appCtx.Config().Server.Health.LivenessPath
+			if livenessPath == "" {
+				livenessPath = "/healthz"
+			}
+
+			readinessPath := appCtx.Config().Server.Health.ReadinessPath
+			if readinessPath == "" {
+				readinessPath = "/readyz"
+			}
+
+			mux.Handle(livenessPath, http.HandlerFunc(hm.HandleHealthz))
+			mux.Handle(readinessPath, http.HandlerFunc(hm.HandleReadyz))
 		}
 
+		if appCtx.Config().Server.Metrics.Enabled && appCtx.Config().Server.Metrics.Host == "" {
+			mux.Handle(metricsPath, metrics.Handler())
+		}
+
+		// Serve TLS natively when there is no proxy in front terminating it
+		if appCtx.Config().Server.Transport.HTTP.TLS.Enabled {
+			cm, err := certificates.NewCertificatesManager(certificates.CertificatesManagerDependencies{
+				AppCtx: appCtx,
+			})
+			if err != nil {
+				log.Fatalf("failed loading TLS certificates: %v", err.Error())
+			}
+
+			httpServer.TLSConfig, err = cm.TLSConfig()
+			if err != nil {
+				log.Fatalf("failed creating TLS config: %v", err.Error())
+			}
+		}
+
+		// Start HTTP server (StreamableHTTP or SSE)
+		appCtx.Logger.Info("starting HTTP server",
+			"transport", appCtx.Config().Server.Transport.Type,
+			"host", appCtx.Config().Server.Transport.HTTP.Host,
+			"tls", appCtx.Config().Server.Transport.HTTP.TLS.Enabled)
+
+		go func() {
+			var err error
+			if appCtx.Config().Server.Transport.HTTP.TLS.Enabled {
+				err = httpServer.ListenAndServeTLS("", "")
+			} else {
+				err = httpServer.ListenAndServe()
+			}
+
+			if errors.Is(err, http.ErrServerClosed) {
+				err = nil
+			}
+			serveErrors <- err
+		}()
+
 	default:
-		// Start stdio server
+		// Start stdio server. It stops when stdin is closed or the application context is cancelled
 		appCtx.Logger.Info("starting stdio server")
-		if err := server.ServeStdio(mcpServer); err != nil {
-			log.Fatal(err)
+		go func() {
+			stdioServer := server.NewStdioServer(mcpServer)
+			stdioServer.SetContextFunc(middlewares.StdioContextFunc)
+			serveErrors <- stdioServer.Listen(appCtx.Context, os.Stdin, os.Stdout)
+		}()
+	}
+
+	// 6. Wait for a termination signal (or a dead transport), then stop everything in order
+	var transportErr error
+	select {
+	case <-appCtx.Context.Done():
+		appCtx.Logger.Info("termination signal received, shutting down")
+	case transportErr = <-serveErrors:
+		if errors.Is(transportErr, context.Canceled) {
+			transportErr = nil
 		}
 	}
+
+	shutdownTimeout := appCtx.Config().Server.ShutdownTimeout
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := appCtx.Shutdown(shutdownCtx); err != nil {
+		appCtx.Logger.Error("shutdown finished with errors", "error", err.Error())
+	} else {
+		appCtx.Logger.Info("shutdown finished")
+	}
+
+	if transportErr != nil {
+		log.Fatalf("transport stopped unexpectedly: %v", transportErr.Error())
+	}
+}
+
+// shutdownHTTPServer gracefully stops an HTTP server using the given function, draining in-flight requests.
+// When the drain timeout is reached, remaining connections are closed abruptly
+func shutdownHTTPServer(ctx context.Context, httpServer *http.Server, shutdown func(context.Context) error) error {
+	err := shutdown(ctx)
+	if err != nil {
+		closeErr := httpServer.Close()
+		return errors.Join(err, closeErr)
+	}
+	return nil
 }
@@ -0,0 +1,266 @@
+package certificates
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	//
+	"mcp-go/api"
+	"mcp-go/internal/globals"
+)
+
+const (
+	// defaultReloadInterval is the time between checks for changes in the certificate files
+	defaultReloadInterval = 10 * time.Second
+)
+
+type CertificatesManagerDependencies struct {
+	AppCtx *globals.ApplicationContext
+}
+
+type CertificatesManager struct {
+	dependencies CertificatesManagerDependencies
+
+	// Carried stuff
+	files *certificateFiles
+	mutex sync.RWMutex
+}
+
+// certificateFiles represents the content loaded from the certificate files, with the settings pointing to them.
+// Settings are captured when the files are loaded, so a config reload can not swap them unchecked
+type certificateFiles struct {
+	config      api.ServerTransportHTTPTLSConfig
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	modTimes    map[string]time.Time
+}
+
+func NewCertificatesManager(deps CertificatesManagerDependencies) (*CertificatesManager, error) {
+
+	cm := &CertificatesManager{
+		dependencies: deps,
+	}
+
+	// Load the files eagerly to fail-fast on wrong paths or broken certificates
+	files, err := loadFiles(deps.AppCtx.Config().Server.Transport.HTTP.TLS)
+	if err != nil {
+		return nil, err
+	}
+	cm.files = files
+
+	cm.dependencies.AppCtx.RegisterConfigReloadHook("certificates manager", cm.reloadConfig)
+
+	go cm.watchFiles()
+
+	return cm, nil
+}
+
+// TLSConfig returns a TLS configuration whose certificates and client CAs
+// are always the latest ones loaded from disk
+func (cm *CertificatesManager) TLSConfig() (*tls.Config, error) {
+	tlsConfig := cm.getFiles().config
+
+	minVersion, err := getTLSVersion(tlsConfig.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	clientAuth, err := getClientAuthType(tlsConfig.ClientCAFile, tlsConfig.ClientAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	baseConfig := &tls.Config{
+		MinVersion: minVersion,
+		ClientAuth: clientAuth,
+	}
+
+	// Certificates are resolved per connection, so changes on disk are picked up without restarting
+	baseConfig.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		files := cm.getFiles()
+
+		connConfig := baseConfig.Clone()
+		connConfig.GetConfigForClient = nil
+		connConfig.Certificates = []tls.Certificate{*files.certificate}
+		connConfig.ClientCAs = files.clientCAs
+		return connConfig, nil
+	}
+
+	return baseConfig, nil
+}
+
+// getFiles returns the certificate files currently in use
+func (cm *CertificatesManager) getFiles() *certificateFiles {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+	return cm.files
+}
+
+// reloadConfig prepares the certificate files of a new config. Files are loaded when their paths change,
+// rejecting the config when they are broken. Settings of the listener are fixed when the server starts,
+// so changing them is rejected too
+func (cm *CertificatesManager) reloadConfig(newConfig *api.Configuration) (func(), error) {
+	newTLSConfig := newConfig.Server.Transport.HTTP.TLS
+	currentFiles := cm.getFiles()
+	currentTLSConfig := currentFiles.config
+
+	if newTLSConfig.Enabled != currentTLSConfig.Enabled ||
+		newTLSConfig.MinVersion != currentTLSConfig.MinVersion ||
+		newTLSConfig.ClientAuth != currentTLSConfig.ClientAuth ||
+		(newTLSConfig.ClientCAFile == "") != (currentTLSConfig.ClientCAFile == "") {
+		return nil, fmt.Errorf("changing TLS 'enabled', 'min_version', 'client_auth' or whether 'client_ca_file' is set requires a restart")
+	}
+
+	newFiles := *currentFiles
+	newFiles.config = newTLSConfig
+
+	if newTLSConfig.CertFile != currentTLSConfig.CertFile || newTLSConfig.KeyFile != currentTLSConfig.KeyFile ||
+		newTLSConfig.ClientCAFile != currentTLSConfig.ClientCAFile {
+		loadedFiles, err := loadFiles(newTLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		newFiles = *loadedFiles
+	}
+
+	return func() {
+		cm.mutex.Lock()
+		cm.files = &newFiles
+		cm.mutex.Unlock()
+	}, nil
+}
+
+// watchFiles checks the certificate files from time to time,
+// and reload them when they change on disk
+func (cm *CertificatesManager) watchFiles() {
+
+	for {
+		reloadInterval := cm.getFiles().config.ReloadInterval
+		if reloadInterval <= 0 {
+			reloadInterval = defaultReloadInterval
+		}
+
+		select {
+		case <-cm.dependencies.AppCtx.Context.Done():
+			return
+		case <-time.After(reloadInterval):
+		}
+
+		currentFiles := cm.getFiles()
+		if !currentFiles.changed() {
+			continue
+		}
+
+		files, err := loadFiles(currentFiles.config)
+		if err != nil {
+			cm.dependencies.AppCtx.Logger.Error("failed reloading TLS certificates, keeping previous ones", "error", err.Error())
+			continue
+		}
+
+		// A config reload may have replaced the files meanwhile. They are newer, so they are kept
+		cm.mutex.Lock()
+		if cm.files == currentFiles {
+			cm.files = files
+		}
+		cm.mutex.Unlock()
+
+		cm.dependencies.AppCtx.Logger.Info("TLS certificates reloaded from disk")
+	}
+}
+
+// changed returns true when some of the watched files has a different modification time
+func (f *certificateFiles) changed() bool {
+	for _, filePath := range getWatchedFiles(f.config) {
+		fileInfo, err := os.Stat(filePath)
+		if err != nil {
+			continue
+		}
+
+		if !fileInfo.ModTime().Equal(f.modTimes[filePath]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadFiles reads the certificate, the key and the client CA bundle from disk
+func loadFiles(tlsConfig api.ServerTransportHTTPTLSConfig) (*certificateFiles, error) {
+
+	// Store modification times before reading, so changes written meanwhile are caught in next check
+	modTimes := map[string]time.Time{}
+	for _, filePath := range getWatchedFiles(tlsConfig) {
+		fileInfo, err := os.Stat(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("error reading file info: %s", err.Error())
+		}
+		modTimes[filePath] = fileInfo.ModTime()
+	}
+
+	certificate, err := tls.LoadX509KeyPair(tlsConfig.CertFile, tlsConfig.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading certificate key pair: %s", err.Error())
+	}
+
+	var clientCAs *x509.CertPool
+	if tlsConfig.ClientCAFile != "" {
+		clientCABytes, err := os.ReadFile(tlsConfig.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading client CA bundle: %s", err.Error())
+		}
+
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(clientCABytes) {
+			return nil, fmt.Errorf("client CA bundle does not contain valid PEM certificates")
+		}
+	}
+
+	return &certificateFiles{
+		config:      tlsConfig,
+		certificate: &certificate,
+		clientCAs:   clientCAs,
+		modTimes:    modTimes,
+	}, nil
+}
+
+// getWatchedFiles returns the list of files whose changes trigger a reload
+func getWatchedFiles(tlsConfig api.ServerTransportHTTPTLSConfig) []string {
+	files := []string{tlsConfig.CertFile, tlsConfig.KeyFile}
+	if tlsConfig.ClientCAFile != "" {
+		files = append(files, tlsConfig.ClientCAFile)
+	}
+	return files
+}
+
+// getTLSVersion returns suitable TLS version according to the configured string
+func getTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS min version: %s", version)
+	}
+}
+
+// getClientAuthType returns suitable client authentication policy according to the configuration.
+// Client certificates are only requested when a CA bundle is configured to verify them
+func getClientAuthType(clientCAFile string, clientAuth string) (tls.ClientAuthType, error) {
+	if clientCAFile == "" {
+		return tls.NoClientCert, nil
+	}
+
+	switch clientAuth {
+	case "", "require":
+		return tls.RequireAndVerifyClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("unsupported TLS client auth: %s", clientAuth)
+	}
+}
@@ -1,37 +1,349 @@
 package config
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"mcp-go/api"
 	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
-// Marshal TODO
+var (
+	// yamlErrorLinePrefix matches the position prefix added by the YAML library to its errors
+	yamlErrorLinePrefix = regexp.MustCompile(`^line \d+: `)
+
+	unmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+	timeType        = reflect.TypeOf(time.Time{})
+)
+
+// Marshal encodes the config as YAML.
+// Fields resolved from secret references show the reference instead of the resolved value
 func Marshal(config api.Configuration) (bytes []byte, err error) {
-	bytes, err = yaml.Marshal(config)
+	if len(config.SecretReferences) == 0 {
+		bytes, err = yaml.Marshal(config)
+		return bytes, err
+	}
+
+	var root yaml.Node
+	err = root.Encode(config)
+	if err != nil {
+		return bytes, err
+	}
+
+	hideSecretValues(&root, "", config.SecretReferences)
+
+	bytes, err = yaml.Marshal(&root)
 	return bytes, err
 }
 
-// Unmarshal TODO
+// Unmarshal parses the config, rejecting unknown keys and values with a wrong type
 func Unmarshal(bytes []byte) (config api.Configuration, err error) {
-	err = yaml.Unmarshal(bytes, &config)
+	config, _, err = decode(bytes, false)
 	return config, err
 }
 
-// ReadFile TODO
-func ReadFile(filepath string) (config api.Configuration, err error) {
+// ReadFile reads, parses and validates the config file.
+// References to environment variables are expanded, overrides from the environment are applied on top,
+// and secret references are resolved. Problems are reported with the YAML path and the line where they are found
+func ReadFile(filepath string, logger *slog.Logger) (config api.Configuration, err error) {
 	var fileBytes []byte
 	fileBytes, err = os.ReadFile(filepath)
 	if err != nil {
 		return config, err
 	}
 
-	// Expand environment variables present in the config
-	// This will cause expansion in the following way: field: "$FIELD" -> field: "value_of_field"
-	fileExpandedEnv := os.ExpandEnv(string(fileBytes))
+	config, lines, err := decode(fileBytes, true)
+	if err != nil {
+		return config, err
+	}
+
+	err = applyEnvOverrides(&config, os.Environ(), logger)
+	if err != nil {
+		return config, err
+	}
 
-	config, err = Unmarshal([]byte(fileExpandedEnv))
+	var secretErrs api.ValidationErrors
+	config.SecretReferences = map[string]string{}
+	resolveSecrets(context.Background(), DefaultSecretsResolver, reflect.ValueOf(&config).Elem(), "", config.SecretReferences, &secretErrs)
+	if len(secretErrs) > 0 {
+		for i := range secretErrs {
+			secretErrs[i].Line = lines.lineFor(secretErrs[i].Path)
+		}
+		return config, secretErrs
+	}
 
-	return config, err
+	referencesInUse := make([]string, 0, len(config.SecretReferences))
+	for _, reference := range config.SecretReferences {
+		referencesInUse = append(referencesInUse, reference)
+	}
+	DefaultSecretsResolver.Retain(referencesInUse)
+
+	// Resolved secrets may be part of the values shown in validation errors
+	err = config.Validate()
+	if err != nil {
+		var validationErrs api.ValidationErrors
+		if errors.As(err, &validationErrs) {
+			for i := range validationErrs {
+				validationErrs[i].Line = lines.lineFor(validationErrs[i].Path)
+			}
+		}
+		return config, redactSecrets(err, DefaultSecretsResolver, config.SecretReferences)
+	}
+
+	return config, nil
+}
+
+// hideSecretValues replaces the values of the fields resolved from secret references by the references
+func hideSecretValues(node *yaml.Node, path string, references map[string]string) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, childNode := range node.Content {
+			hideSecretValues(childNode, path, references)
+		}
+
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			hideSecretValues(node.Content[i+1], joinPath(path, node.Content[i].Value), references)
+		}
+
+	case yaml.SequenceNode:
+		for i, itemNode := range node.Content {
+			hideSecretValues(itemNode, fmt.Sprintf("%s[%d]", path, i), references)
+		}
+
+	case yaml.ScalarNode:
+		if reference, ok := references[path]; ok {
+			node.Value = reference
+			node.Tag = "!!str"
+			node.Style = 0
+		}
+	}
+}
+
+// linesIndex stores the line where each YAML path is defined in the config
+type linesIndex map[string]int
+
+// lineFor returns the line of a YAML path. For missing paths, the line of the closest parent is returned
+func (l linesIndex) lineFor(path string) int {
+	for path != "" {
+		if line, ok := l[path]; ok {
+			return line
+		}
+
+		cut := strings.LastIndexAny(path, ".[")
+		if cut < 0 {
+			break
+		}
+		path = path[:cut]
+	}
+	return 0
+}
+
+// decode parses the config walking the YAML tree, so every problem can be reported with its position.
+// When requested, references to environment variables are expanded in the values, never in keys or comments
+func decode(bytes []byte, expand bool) (config api.Configuration, lines linesIndex, err error) {
+	lines = linesIndex{}
+
+	var root yaml.Node
+	err = yaml.Unmarshal(bytes, &root)
+	if err != nil {
+		return config, lines, err
+	}
+
+	// Empty documents produce an empty config
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 {
+		return config, lines, nil
+	}
+
+	if expand {
+		var expandErrs []error
+		expandNodeEnv(&root, "", &expandErrs)
+		if len(expandErrs) > 0 {
+			return config, lines, errors.Join(expandErrs...)
+		}
+	}
+
+	var validationErrs api.ValidationErrors
+	checkNode(root.Content[0], reflect.TypeOf(config), "", lines, &validationErrs)
+	if len(validationErrs) > 0 {
+		return config, lines, validationErrs
+	}
+
+	err = root.Content[0].Decode(&config)
+	return config, lines, err
+}
+
+// checkNode compares a YAML node with the type it will be decoded into.
+// Unknown keys and values not matching their type are collected as validation errors
+func checkNode(node *yaml.Node, t reflect.Type, path string, lines linesIndex, errs *api.ValidationErrors) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	// Null values are allowed anywhere, leaving the field empty
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!null" {
+		return
+	}
+
+	// Types with custom decoding know better how their content looks like.
+	// Timestamps are decoded from scalars by the YAML library too
+	if reflect.PointerTo(t).Implements(unmarshalerType) || t == timeType {
+		checkLeaf(node, t, path, errs)
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			addNodeError(errs, node, path, "expected a mapping")
+			return
+		}
+
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode, valueNode := node.Content[i], node.Content[i+1]
+
+			// Merge keys bring the content of other mappings into this one
+			if keyNode.Value == "<<" {
+				checkMergeNode(valueNode, t, path, lines, errs)
+				continue
+			}
+
+			childPath := joinPath(path, keyNode.Value)
+			fieldType, ok := fields[keyNode.Value]
+			if !ok {
+				addNodeError(errs, keyNode, childPath, "unknown field")
+				continue
+			}
+
+			lines[childPath] = keyNode.Line
+			checkNode(valueNode, fieldType, childPath, lines, errs)
+		}
+
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			addNodeError(errs, node, path, "expected a mapping")
+			return
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode, valueNode := node.Content[i], node.Content[i+1]
+			childPath := joinPath(path, keyNode.Value)
+
+			lines[childPath] = keyNode.Line
+			checkNode(valueNode, t.Elem(), childPath, lines, errs)
+		}
+
+	case reflect.Slice, reflect.Array:
+		if node.Kind != yaml.SequenceNode {
+			addNodeError(errs, node, path, "expected a list")
+			return
+		}
+
+		for i, itemNode := range node.Content {
+			itemPath := fmt.Sprintf("%s[%d]", path, i)
+
+			lines[itemPath] = itemNode.Line
+			checkNode(itemNode, t.Elem(), itemPath, lines, errs)
+		}
+
+	default:
+		checkLeaf(node, t, path, errs)
+	}
+}
+
+// checkMergeNode checks the mappings merged into another one through a merge key
+func checkMergeNode(node *yaml.Node, t reflect.Type, path string, lines linesIndex, errs *api.ValidationErrors) {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	if node.Kind == yaml.SequenceNode {
+		for _, itemNode := range node.Content {
+			checkNode(itemNode, t, path, lines, errs)
+		}
+		return
+	}
+
+	checkNode(node, t, path, lines, errs)
+}
+
+// checkLeaf decodes a single value into its type to detect mismatches, like invalid durations
+func checkLeaf(node *yaml.Node, t reflect.Type, path string, errs *api.ValidationErrors) {
+	err := node.Decode(reflect.New(t).Interface())
+	if err == nil {
+		return
+	}
+
+	message := err.Error()
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+		message = typeErr.Errors[0]
+	}
+
+	addNodeError(errs, node, path, yamlErrorLinePrefix.ReplaceAllString(message, ""))
+}
+
+// yamlFields returns the types of the fields of a struct, indexed by their YAML key
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+
+		// Inlined structs share the keys of their parent
+		if strings.Contains(options, "inline") {
+			for inlineName, inlineType := range yamlFields(field.Type) {
+				fields[inlineName] = inlineType
+			}
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field.Type
+	}
+
+	return fields
+}
+
+func addNodeError(errs *api.ValidationErrors, node *yaml.Node, path string, message string) {
+	if path == "" {
+		path = "<root>"
+	}
+
+	*errs = append(*errs, api.ValidationError{
+		Path:    path,
+		Line:    node.Line,
+		Message: message,
+	})
+}
+
+func joinPath(parent string, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
 }
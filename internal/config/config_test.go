@@ -0,0 +1,213 @@
+package config
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	//
+	"mcp-go/api"
+)
+
+// testLogger discards the warnings logged while reading config files
+var testLogger = slog.New(slog.DiscardHandler)
+
+// writeTestConfigFile writes the config into a file of a temporary directory, returning its path
+func writeTestConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	filePath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(filePath, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed writing config file: %s", err.Error())
+	}
+	return filePath
+}
+
+func TestReadFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		wantErrs []string
+	}{
+		{
+			name: "valid config",
+			content: `
+server:
+  shutdown_timeout: 25s
+  transport:
+    type: http
+    http:
+      host: ":8080"
+middleware:
+  jwt:
+    enabled: true
+    validation:
+      strategy: local
+      forwarded_header: X-Validated-Jwt
+      local:
+        jwks_uri: https://issuer.example.com/certs
+        cache_interval: 10s
+`,
+		},
+		{
+			name:    "empty config",
+			content: ``,
+		},
+		{
+			name: "unknown key is reported with its line",
+			content: `
+server:
+  transport:
+    type: http
+    htpp:
+      host: ":8080"
+`,
+			wantErrs: []string{
+				"server.transport.htpp (line 5): unknown field",
+			},
+		},
+		{
+			name: "unknown key at the root",
+			content: `
+serverr:
+  name: mcp
+`,
+			wantErrs: []string{
+				"serverr (line 2): unknown field",
+			},
+		},
+		{
+			name: "invalid duration is reported with its line",
+			content: `
+server:
+  name: mcp
+  shutdown_timeout: soon
+`,
+			wantErrs: []string{
+				`server.shutdown_timeout (line 4): cannot unmarshal !!str ` + "`soon`" + ` into time.Duration`,
+			},
+		},
+		{
+			name: "scalar given for a list",
+			content: `
+middleware:
+  access_logs:
+    excluded_headers: X-Excluded
+`,
+			wantErrs: []string{
+				"middleware.access_logs.excluded_headers (line 4): expected a list",
+			},
+		},
+		{
+			name: "list items are reported with their index",
+			content: `
+middleware:
+  access_logs:
+    redacted_headers:
+      - Authorization
+      - unknown: true
+`,
+			wantErrs: []string{
+				"middleware.access_logs.redacted_headers[1] (line 6): cannot unmarshal !!map into string",
+			},
+		},
+		{
+			name: "unknown keys brought by merge keys are reported",
+			content: `
+defaults: &defaults
+  typ: http
+server:
+  transport:
+    <<: *defaults
+`,
+			wantErrs: []string{
+				"defaults (line 2): unknown field",
+				"server.transport.typ (line 3): unknown field",
+			},
+		},
+		{
+			name: "validation errors carry the line of the field",
+			content: `
+server:
+  transport:
+    type: http
+    http:
+      host: ":8080"
+middleware:
+  jwt:
+    enabled: true
+    validation:
+      strategy: lcoal
+      forwarded_header: "X Validated"
+`,
+			wantErrs: []string{
+				`middleware.jwt.validation.strategy (line 11): unsupported value "lcoal", expected one of: local, external, introspection, api_key`,
+				`middleware.jwt.validation.forwarded_header (line 12): invalid header name "X Validated"`,
+			},
+		},
+		{
+			name: "missing fields carry the line of their closest parent",
+			content: `
+server:
+  transport:
+    type: http
+middleware:
+  jwt:
+    enabled: true
+    validation:
+      strategy: local
+      forwarded_header: X-Validated-Jwt
+      local:
+        cache_interval: 10s
+`,
+			wantErrs: []string{
+				"server.transport.http.host (line 3): field is required",
+				"middleware.jwt.validation.local.jwks_uri (line 11): field is required",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadFile(writeTestConfigFile(t, tt.content), testLogger)
+
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("ReadFile() error = %s, want none", err.Error())
+				}
+				return
+			}
+
+			var validationErrs api.ValidationErrors
+			if !errors.As(err, &validationErrs) {
+				t.Fatalf("ReadFile() error = %v, want validation errors", err)
+			}
+
+			var gotErrs []string
+			for _, validationErr := range validationErrs {
+				gotErrs = append(gotErrs, validationErr.Error())
+			}
+			if strings.Join(gotErrs, "\n") != strings.Join(tt.wantErrs, "\n") {
+				t.Errorf("ReadFile() errors =\n%s\nwant\n%s", strings.Join(gotErrs, "\n"), strings.Join(tt.wantErrs, "\n"))
+			}
+		})
+	}
+}
+
+func TestReadFileExamples(t *testing.T) {
+	examples, err := filepath.Glob("../../docs/*.yaml")
+	if err != nil || len(examples) == 0 {
+		t.Fatalf("no example configs found: %v", err)
+	}
+
+	for _, example := range examples {
+		t.Run(filepath.Base(example), func(t *testing.T) {
+			if _, err := ReadFile(example, testLogger); err != nil {
+				t.Errorf("ReadFile() error = %s, want none", err.Error())
+			}
+		})
+	}
+}
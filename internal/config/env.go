@@ -0,0 +1,318 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// EnvOverridePrefix is the prefix of the environment variables overriding config fields.
+	// The rest of the name is the YAML path in uppercase, joined by '_'. List items are selected by index:
+	// MCPGO_MIDDLEWARE_JWT_VALIDATION_STRATEGY=local
+	// MCPGO_OAUTH_PROTECTED_RESOURCE_SCOPES_SUPPORTED=openid,profile
+	// MCPGO_MIDDLEWARE_JWT_VALIDATION_LOCAL_ALLOW_CONDITIONS_0_EXPRESSION=has(payload.email)
+	EnvOverridePrefix = "MCPGO_"
+)
+
+var (
+	// errNoMatchingField is returned when an override does not match any config field
+	errNoMatchingField = errors.New("no config field matches it")
+)
+
+// expandEnv replaces the references to environment variables present in a value:
+// '${VAR}' is replaced by the value of VAR, failing when it is not set,
+// '${VAR:-default}' is replaced by the value of VAR, or 'default' when it is not set or empty,
+// '$$' is replaced by a literal '$'. Any other '$' is kept untouched, so hashes like '$argon2id$...' are safe,
+// except '$VAR' when VAR is set: it fails instead of silently keeping a reference expanded by older versions
+func expandEnv(value string) (string, error) {
+	if !strings.Contains(value, "$") {
+		return value, nil
+	}
+
+	var result strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 >= len(value) {
+			result.WriteByte(value[i])
+			continue
+		}
+
+		switch value[i+1] {
+		case '$':
+			result.WriteByte('$')
+			i++
+
+		case '{':
+			end := strings.IndexByte(value[i+2:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unclosed variable reference in '%s'", value)
+			}
+
+			reference := value[i+2 : i+2+end]
+			expanded, err := expandReference(reference)
+			if err != nil {
+				return "", err
+			}
+
+			result.WriteString(expanded)
+			i += 2 + end
+
+		default:
+			if name := getVariableName(value[i+1:]); name != "" {
+				if _, isSet := os.LookupEnv(name); isSet {
+					return "", fmt.Errorf("'$%s' is not expanded, use '${%s}', or '$$%s' for a literal value", name, name, name)
+				}
+			}
+			result.WriteByte('$')
+		}
+	}
+
+	return result.String(), nil
+}
+
+// getVariableName returns the name of the variable at the beginning of a value, following shell naming rules
+func getVariableName(value string) string {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		isLetter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !isLetter && (i == 0 || c < '0' || c > '9') {
+			return value[:i]
+		}
+	}
+	return value
+}
+
+// expandReference returns the value of a reference like 'VAR' or 'VAR:-default'
+func expandReference(reference string) (string, error) {
+	name, defaultValue, hasDefault := strings.Cut(reference, ":-")
+	if name == "" {
+		return "", fmt.Errorf("empty variable name in '${%s}'", reference)
+	}
+
+	value, isSet := os.LookupEnv(name)
+	if hasDefault {
+		if value == "" {
+			return defaultValue, nil
+		}
+		return value, nil
+	}
+
+	if !isSet {
+		return "", fmt.Errorf("environment variable '%s' is required but not set", name)
+	}
+	return value, nil
+}
+
+// expandNodeEnv expands the environment variables present in every scalar of a YAML tree
+func expandNodeEnv(node *yaml.Node, path string, errs *[]error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, childNode := range node.Content {
+			expandNodeEnv(childNode, path, errs)
+		}
+
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			expandNodeEnv(node.Content[i+1], joinPath(path, node.Content[i].Value), errs)
+		}
+
+	case yaml.SequenceNode:
+		for i, itemNode := range node.Content {
+			expandNodeEnv(itemNode, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+
+	case yaml.ScalarNode:
+		expanded, err := expandEnv(node.Value)
+		if err != nil {
+			*errs = append(*errs, fmt.Errorf("%s (line %d): %s", path, node.Line, err.Error()))
+			return
+		}
+
+		if expanded == node.Value {
+			return
+		}
+		node.Value = expanded
+
+		// Plain values are resolved again, so 'enabled: ${FLAG}' becomes a boolean, not a string
+		if node.Style&(yaml.TaggedStyle|yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			node.Tag = ""
+		}
+	}
+}
+
+// applyEnvOverrides sets the config fields pointed by the environment variables starting with EnvOverridePrefix.
+// Map fields are not supported, as their keys can not be told apart from the path in the variable name.
+// Variables not matching any field are skipped with a warning, as unrelated ones may share the prefix
+func applyEnvOverrides(config any, environ []string, logger *slog.Logger) error {
+	sort.Strings(environ)
+
+	var errs []error
+	for _, envVar := range environ {
+		name, value, _ := strings.Cut(envVar, "=")
+		if !strings.HasPrefix(name, EnvOverridePrefix) {
+			continue
+		}
+
+		parts := strings.Split(strings.TrimPrefix(name, EnvOverridePrefix), "_")
+		err := setOverride(reflect.ValueOf(config).Elem(), parts, value)
+		if errors.Is(err, errNoMatchingField) {
+			logger.Warn("environment override skipped, as no config field matches it", "variable", name)
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("environment override '%s': %s", name, err.Error()))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// setOverride looks for the field pointed by the name parts, and sets the value on it.
+// As YAML keys may contain '_', every field whose key matches the beginning of the parts is tried.
+// Nothing is modified unless the whole path matches, so the fields tried before the right one are untouched
+func setOverride(v reflect.Value, parts []string, value string) error {
+	if len(parts) == 0 {
+		return setOverrideValue(v, value)
+	}
+
+	if v.Kind() == reflect.Pointer {
+		if !v.IsNil() {
+			return setOverride(v.Elem(), parts, value)
+		}
+
+		target := reflect.New(v.Type().Elem())
+		err := setOverride(target.Elem(), parts, value)
+		if err != nil {
+			return err
+		}
+		v.Set(target)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		fields := yamlFields(v.Type())
+
+		// Longer keys are tried first, so 'jwks_uri' wins over a hypothetical 'jwks'
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool { return len(names[i]) > len(names[j]) })
+
+		for _, name := range names {
+			nameParts := strings.Split(strings.ToUpper(name), "_")
+			if len(nameParts) > len(parts) || !equalParts(nameParts, parts[:len(nameParts)]) {
+				continue
+			}
+
+			err := setOverride(fieldByYAMLName(v, name), parts[len(nameParts):], value)
+			if errors.Is(err, errNoMatchingField) {
+				continue
+			}
+			return err
+		}
+		return errNoMatchingField
+
+	case reflect.Slice:
+		index, err := strconv.Atoi(parts[0])
+		if err != nil || index < 0 {
+			return errNoMatchingField
+		}
+
+		// Missing items are created, so lists can be defined entirely from the environment.
+		// The item is set on a copy of the list, which replaces it only when the rest of the path matches
+		items := reflect.MakeSlice(v.Type(), max(v.Len(), index+1), max(v.Len(), index+1))
+		reflect.Copy(items, v)
+
+		err = setOverride(items.Index(index), parts[1:], value)
+		if err != nil {
+			return err
+		}
+		v.Set(items)
+		return nil
+
+	case reflect.Map:
+		return fmt.Errorf("map fields can not be overridden, define them in the config file instead")
+
+	default:
+		return errNoMatchingField
+	}
+}
+
+// setOverrideValue parses a value from the environment into a config field.
+// Lists of scalars are defined as comma-separated values
+func setOverrideValue(v reflect.Value, value string) error {
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+		return nil
+
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Struct:
+		items := reflect.MakeSlice(v.Type(), 0, 0)
+		for _, item := range strings.Split(value, ",") {
+			itemValue := reflect.New(v.Type().Elem()).Elem()
+			err := setOverrideValue(itemValue, strings.TrimSpace(item))
+			if err != nil {
+				return err
+			}
+			items = reflect.Append(items, itemValue)
+		}
+		v.Set(items)
+		return nil
+
+	case (v.Kind() == reflect.Struct && v.Type() != timeType) || v.Kind() == reflect.Slice:
+		return fmt.Errorf("a whole section can not be overridden, override its fields instead")
+	}
+
+	node := &yaml.Node{Kind: yaml.ScalarNode, Value: value}
+	target := reflect.New(v.Type())
+	if err := node.Decode(target.Interface()); err != nil {
+		return fmt.Errorf("invalid value '%s' for %s", value, v.Type().String())
+	}
+
+	v.Set(target.Elem())
+	return nil
+}
+
+// fieldByYAMLName returns the field of a struct value tagged with the given YAML key
+func fieldByYAMLName(v reflect.Value, name string) reflect.Value {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tagName, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if strings.Contains(options, "inline") {
+			if _, ok := yamlFields(field.Type)[name]; ok {
+				return fieldByYAMLName(v.Field(i), name)
+			}
+			continue
+		}
+
+		if tagName == "" {
+			tagName = strings.ToLower(field.Name)
+		}
+		if tagName == name {
+			return v.Field(i)
+		}
+	}
+	return reflect.Value{}
+}
+
+func equalParts(a []string, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,349 @@
+package config
+
+import (
+	"bytes"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	//
+	"mcp-go/api"
+)
+
+// testOverrideConfig represents a config with keys sharing their first words, optional sections, lists of sections and maps
+type testOverrideConfig struct {
+	JWKS    string `yaml:"jwks"`
+	JWKSUri string `yaml:"jwks_uri"`
+	Nested  struct {
+		Timeout time.Duration `yaml:"timeout"`
+		Enabled bool          `yaml:"enabled"`
+		Tags    []string      `yaml:"tags"`
+	} `yaml:"nested"`
+	Optional *struct {
+		Name string `yaml:"name"`
+	} `yaml:"optional"`
+	Items []struct {
+		Name  string `yaml:"name"`
+		Count int    `yaml:"count"`
+	} `yaml:"items"`
+	Headers map[string]string `yaml:"headers"`
+}
+
+func TestExpandEnv(t *testing.T) {
+	t.Setenv("TEST_MCPGO_HOST", "mcp.example.com")
+	t.Setenv("TEST_MCPGO_EMPTY", "")
+
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr string
+	}{
+		{
+			name:  "value without references",
+			value: "mcp.example.com",
+			want:  "mcp.example.com",
+		},
+		{
+			name:  "set variable",
+			value: "https://${TEST_MCPGO_HOST}/mcp",
+			want:  "https://mcp.example.com/mcp",
+		},
+		{
+			name:  "default of a missing variable",
+			value: "${TEST_MCPGO_MISSING:-localhost}",
+			want:  "localhost",
+		},
+		{
+			name:  "default of an empty variable",
+			value: "${TEST_MCPGO_EMPTY:-localhost}",
+			want:  "localhost",
+		},
+		{
+			name:  "default of a set variable",
+			value: "${TEST_MCPGO_HOST:-localhost}",
+			want:  "mcp.example.com",
+		},
+		{
+			name:  "escaped dollar",
+			value: "pa$$word",
+			want:  "pa$word",
+		},
+		{
+			name:  "dollar kept when not a reference",
+			value: "size(payload.$roles) > 0 && $",
+			want:  "size(payload.$roles) > 0 && $",
+		},
+		{
+			name:  "bare reference to a missing variable is kept",
+			value: "$argon2id$v=19$TEST_MCPGO_MISSING",
+			want:  "$argon2id$v=19$TEST_MCPGO_MISSING",
+		},
+		{
+			name:    "bare reference to a set variable",
+			value:   "https://$TEST_MCPGO_HOST/mcp",
+			wantErr: "'$TEST_MCPGO_HOST' is not expanded, use '${TEST_MCPGO_HOST}', or '$$TEST_MCPGO_HOST' for a literal value",
+		},
+		{
+			name:  "escaped bare reference to a set variable",
+			value: "$$TEST_MCPGO_HOST",
+			want:  "$TEST_MCPGO_HOST",
+		},
+		{
+			name:    "missing variable",
+			value:   "${TEST_MCPGO_MISSING}",
+			wantErr: "environment variable 'TEST_MCPGO_MISSING' is required but not set",
+		},
+		{
+			name:    "unclosed reference",
+			value:   "${TEST_MCPGO_HOST",
+			wantErr: "unclosed variable reference in '${TEST_MCPGO_HOST'",
+		},
+		{
+			name:    "empty name",
+			value:   "${:-localhost}",
+			wantErr: "empty variable name in '${:-localhost}'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := expandEnv(test.value)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("expected error '%s', got: %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if got != test.want {
+				t.Errorf("expected '%s', got '%s'", test.want, got)
+			}
+		})
+	}
+}
+
+func TestApplyEnvOverrides(t *testing.T) {
+	tests := []struct {
+		name        string
+		initial     func(config *testOverrideConfig)
+		environ     []string
+		check       func(t *testing.T, config testOverrideConfig)
+		wantErr     string
+		wantSkipped []string
+	}{
+		{
+			name:    "longer key wins over a shorter prefix",
+			environ: []string{"MCPGO_JWKS_URI=https://issuer.example.com/certs"},
+			check: func(t *testing.T, config testOverrideConfig) {
+				if config.JWKSUri != "https://issuer.example.com/certs" || config.JWKS != "" {
+					t.Errorf("expected only jwks_uri to be set, got jwks '%s' and jwks_uri '%s'", config.JWKS, config.JWKSUri)
+				}
+			},
+		},
+		{
+			name:    "shorter key",
+			environ: []string{"MCPGO_JWKS={}"},
+			check: func(t *testing.T, config testOverrideConfig) {
+				if config.JWKS != "{}" || config.JWKSUri != "" {
+					t.Errorf("expected only jwks to be set, got jwks '%s' and jwks_uri '%s'", config.JWKS, config.JWKSUri)
+				}
+			},
+		},
+		{
+			name: "nested fields",
+			environ: []string{
+				"MCPGO_NESTED_TIMEOUT=15s",
+				"MCPGO_NESTED_ENABLED=true",
+				"MCPGO_NESTED_TAGS=a, b,c",
+			},
+			check: func(t *testing.T, config testOverrideConfig) {
+				if config.Nested.Timeout != 15*time.Second || !config.Nested.Enabled {
+					t.Errorf("unexpected nested section: %+v", config.Nested)
+				}
+				if !reflect.DeepEqual(config.Nested.Tags, []string{"a", "b", "c"}) {
+					t.Errorf("expected tags [a b c], got %v", config.Nested.Tags)
+				}
+			},
+		},
+		{
+			name:    "missing sections are created",
+			environ: []string{"MCPGO_OPTIONAL_NAME=optional"},
+			check: func(t *testing.T, config testOverrideConfig) {
+				if config.Optional == nil || config.Optional.Name != "optional" {
+					t.Errorf("unexpected optional section: %+v", config.Optional)
+				}
+			},
+		},
+		{
+			name: "list items are created by index",
+			environ: []string{
+				"MCPGO_ITEMS_1_NAME=second",
+				"MCPGO_ITEMS_1_COUNT=2",
+			},
+			check: func(t *testing.T, config testOverrideConfig) {
+				if len(config.Items) != 2 || config.Items[1].Name != "second" || config.Items[1].Count != 2 {
+					t.Errorf("unexpected items: %+v", config.Items)
+				}
+			},
+		},
+		{
+			name: "existing list items are kept",
+			initial: func(config *testOverrideConfig) {
+				config.Items = append(config.Items, struct {
+					Name  string `yaml:"name"`
+					Count int    `yaml:"count"`
+				}{Name: "first", Count: 1})
+			},
+			environ: []string{
+				"MCPGO_ITEMS_0_COUNT=10",
+				"MCPGO_ITEMS_1_NAME=second",
+			},
+			check: func(t *testing.T, config testOverrideConfig) {
+				if len(config.Items) != 2 || config.Items[0].Name != "first" || config.Items[0].Count != 10 || config.Items[1].Name != "second" {
+					t.Errorf("unexpected items: %+v", config.Items)
+				}
+			},
+		},
+		{
+			name:    "variables without the prefix are ignored",
+			environ: []string{"JWKS_URI=https://issuer.example.com/certs"},
+			check: func(t *testing.T, config testOverrideConfig) {
+				if config.JWKSUri != "" {
+					t.Errorf("expected jwks_uri to be empty, got '%s'", config.JWKSUri)
+				}
+			},
+		},
+		{
+			name: "unknown field is skipped",
+			environ: []string{
+				"MCPGO_NESTED_TIMEOUTS=15s",
+				"MCPGO_JWKS_URI=https://issuer.example.com/certs",
+			},
+			check: func(t *testing.T, config testOverrideConfig) {
+				if config.Nested.Timeout != 0 || config.JWKSUri != "https://issuer.example.com/certs" {
+					t.Errorf("expected only jwks_uri to be set, got timeout %s and jwks_uri '%s'", config.Nested.Timeout, config.JWKSUri)
+				}
+			},
+			wantSkipped: []string{"MCPGO_NESTED_TIMEOUTS"},
+		},
+		{
+			name: "unknown fields do not create sections nor list items",
+			environ: []string{
+				"MCPGO_OPTIONAL_UNKNOWN=optional",
+				"MCPGO_ITEMS_2_UNKNOWN=third",
+				"MCPGO_ITEMS_-1_NAME=last",
+			},
+			check: func(t *testing.T, config testOverrideConfig) {
+				if config.Optional != nil || config.Items != nil {
+					t.Errorf("expected no optional section nor items, got %+v and %+v", config.Optional, config.Items)
+				}
+			},
+			wantSkipped: []string{"MCPGO_ITEMS_-1_NAME", "MCPGO_ITEMS_2_UNKNOWN", "MCPGO_OPTIONAL_UNKNOWN"},
+		},
+		{
+			name:    "invalid value",
+			environ: []string{"MCPGO_NESTED_TIMEOUT=soon"},
+			wantErr: "environment override 'MCPGO_NESTED_TIMEOUT': invalid value 'soon' for time.Duration",
+		},
+		{
+			name:    "invalid list item",
+			environ: []string{"MCPGO_ITEMS_0_COUNT=many"},
+			check: func(t *testing.T, config testOverrideConfig) {
+				if config.Items != nil {
+					t.Errorf("expected no items, got %+v", config.Items)
+				}
+			},
+			wantErr: "environment override 'MCPGO_ITEMS_0_COUNT': invalid value 'many' for int",
+		},
+		{
+			name:    "whole section",
+			environ: []string{"MCPGO_NESTED=enabled"},
+			wantErr: "environment override 'MCPGO_NESTED': a whole section can not be overridden, override its fields instead",
+		},
+		{
+			name:    "map field",
+			environ: []string{"MCPGO_HEADERS_AUTHORIZATION=Bearer token"},
+			wantErr: "environment override 'MCPGO_HEADERS_AUTHORIZATION': map fields can not be overridden, define them in the config file instead",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var config testOverrideConfig
+			if test.initial != nil {
+				test.initial(&config)
+			}
+
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&logs, nil))
+
+			err := applyEnvOverrides(&config, test.environ, logger)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("expected error '%s', got: %v", test.wantErr, err)
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if test.check != nil {
+				test.check(t, config)
+			}
+
+			var skipped []string
+			for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+				if _, variable, found := strings.Cut(line, "variable="); found {
+					skipped = append(skipped, variable)
+				}
+			}
+			if !reflect.DeepEqual(skipped, test.wantSkipped) {
+				t.Errorf("expected skipped variables %v, got %v", test.wantSkipped, skipped)
+			}
+		})
+	}
+}
+
+func TestReadFileEnv(t *testing.T) {
+	t.Setenv("TEST_MCPGO_JWKS_URI", "https://issuer.example.com/certs")
+	t.Setenv("MCPGO_SERVER_TRANSPORT_HTTP_HOST", ":9090")
+	t.Setenv("MCPGO_MIDDLEWARE_JWT_VALIDATION_LOCAL_ALLOW_CONDITIONS_0_EXPRESSION", "has(payload.email)")
+
+	filePath := writeTestConfigFile(t, `
+server:
+  transport:
+    type: http
+    http:
+      host: ":8080"
+middleware:
+  jwt:
+    enabled: ${TEST_MCPGO_JWT_ENABLED:-true}
+    validation:
+      strategy: local
+      forwarded_header: X-Validated-Jwt
+      local:
+        jwks_uri: "${TEST_MCPGO_JWKS_URI}"
+        cache_interval: 10s
+`)
+
+	config, err := ReadFile(filePath, testLogger)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	want := api.JWTValidationLocalConfig{
+		JWKSUri:         "https://issuer.example.com/certs",
+		CacheInterval:   10 * time.Second,
+		AllowConditions: []api.JWTValidationAllowCondition{{Expression: "has(payload.email)"}},
+	}
+	if !config.Middleware.JWT.Enabled || !reflect.DeepEqual(config.Middleware.JWT.Validation.Local, want) {
+		t.Errorf("unexpected JWT config: %+v", config.Middleware.JWT)
+	}
+	if config.Server.Transport.HTTP.Host != ":9090" {
+		t.Errorf("expected host ':9090' from the environment, got '%s'", config.Server.Transport.HTTP.Host)
+	}
+}
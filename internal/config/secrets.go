@@ -0,0 +1,305 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mcp-go/api"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SecretReferencePrefix is the prefix of the string values resolved by a secret provider.
+	// References look like 'secret://<provider>/<reference>', for example:
+	// secret://file/var/run/secrets/db-password
+	// secret://env/DATABASE_PASSWORD
+	// secret://exec/vault kv get -field=password secret/database
+	SecretReferencePrefix = "secret://"
+
+	// defaultSecretExecTimeout is the time given to commands resolving secrets
+	defaultSecretExecTimeout = 10 * time.Second
+)
+
+var (
+	// DefaultSecretsResolver resolves the secret references present in the config files
+	DefaultSecretsResolver = NewSecretsResolver(FileSecretProvider{}, EnvSecretProvider{}, ExecSecretProvider{})
+)
+
+// SecretProvider resolves the secret references of one kind
+type SecretProvider interface {
+	// Name returns the kind of references resolved by the provider, as written after the prefix
+	Name() string
+
+	// Resolve returns the value of the secret pointed by the reference
+	Resolve(ctx context.Context, reference string) (string, error)
+}
+
+// RegisterSecretProvider makes a provider available for the secret references in the config files
+func RegisterSecretProvider(provider SecretProvider) {
+	DefaultSecretsResolver.RegisterProvider(provider)
+}
+
+// SecretsResolver resolves secret references through their providers, caching the values
+type SecretsResolver struct {
+	providers map[string]SecretProvider
+
+	cache      map[string]string
+	cacheMutex sync.Mutex
+}
+
+func NewSecretsResolver(providers ...SecretProvider) *SecretsResolver {
+	resolver := &SecretsResolver{
+		providers: map[string]SecretProvider{},
+		cache:     map[string]string{},
+	}
+
+	for _, provider := range providers {
+		resolver.RegisterProvider(provider)
+	}
+	return resolver
+}
+
+// RegisterProvider adds a provider, replacing any previous one with the same name
+func (r *SecretsResolver) RegisterProvider(provider SecretProvider) {
+	r.cacheMutex.Lock()
+	defer r.cacheMutex.Unlock()
+
+	r.providers[provider.Name()] = provider
+}
+
+// Resolve returns the value of a secret reference. Values are cached until the next refresh
+func (r *SecretsResolver) Resolve(ctx context.Context, reference string) (string, error) {
+	r.cacheMutex.Lock()
+	defer r.cacheMutex.Unlock()
+
+	if value, ok := r.cache[reference]; ok {
+		return value, nil
+	}
+
+	value, err := r.fetch(ctx, reference)
+	if err != nil {
+		return "", err
+	}
+
+	r.cache[reference] = value
+	return value, nil
+}
+
+// Refresh resolves again every cached reference, reporting whether some value changed.
+// Values failing to be resolved keep the cached one
+func (r *SecretsResolver) Refresh(ctx context.Context) (changed bool, err error) {
+	r.cacheMutex.Lock()
+	defer r.cacheMutex.Unlock()
+
+	var errs []error
+	for reference, cachedValue := range r.cache {
+		value, err := r.fetch(ctx, reference)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if value != cachedValue {
+			r.cache[reference] = value
+			changed = true
+		}
+	}
+
+	return changed, errors.Join(errs...)
+}
+
+// Retain drops from the cache the references not present in the list, so they are not refreshed anymore
+func (r *SecretsResolver) Retain(references []string) {
+	r.cacheMutex.Lock()
+	defer r.cacheMutex.Unlock()
+
+	retained := make(map[string]string, len(references))
+	for _, reference := range references {
+		if value, ok := r.cache[reference]; ok {
+			retained[reference] = value
+		}
+	}
+	r.cache = retained
+}
+
+// fetch resolves a reference through its provider, without looking at the cache
+func (r *SecretsResolver) fetch(ctx context.Context, reference string) (string, error) {
+	providerName, providerReference, found := strings.Cut(strings.TrimPrefix(reference, SecretReferencePrefix), "/")
+	if !found || providerReference == "" {
+		return "", fmt.Errorf("malformed secret reference '%s', expected '%s<provider>/<reference>'", reference, SecretReferencePrefix)
+	}
+
+	provider, ok := r.providers[providerName]
+	if !ok {
+		return "", fmt.Errorf("unknown secret provider '%s' in '%s'", providerName, reference)
+	}
+
+	value, err := provider.Resolve(ctx, providerReference)
+	if err != nil {
+		return "", fmt.Errorf("failed resolving secret '%s': %s", reference, err.Error())
+	}
+	return value, nil
+}
+
+// FileSecretProvider reads secrets from files, like the ones mounted by Kubernetes.
+// Paths are always absolute, and the trailing newline is removed
+type FileSecretProvider struct{}
+
+func (p FileSecretProvider) Name() string {
+	return "file"
+}
+
+func (p FileSecretProvider) Resolve(ctx context.Context, reference string) (string, error) {
+	fileBytes, err := os.ReadFile("/" + reference)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(fileBytes), "\r\n"), nil
+}
+
+// EnvSecretProvider reads secrets from environment variables
+type EnvSecretProvider struct{}
+
+func (p EnvSecretProvider) Name() string {
+	return "env"
+}
+
+func (p EnvSecretProvider) Resolve(ctx context.Context, reference string) (string, error) {
+	value, ok := os.LookupEnv(reference)
+	if !ok {
+		return "", fmt.Errorf("environment variable '%s' is not set", reference)
+	}
+	return value, nil
+}
+
+// ExecSecretProvider reads secrets from the output of a command, like a vault CLI.
+// The command is not executed in a shell, so its arguments are split by spaces
+type ExecSecretProvider struct {
+	Timeout time.Duration
+}
+
+func (p ExecSecretProvider) Name() string {
+	return "exec"
+}
+
+func (p ExecSecretProvider) Resolve(ctx context.Context, reference string) (string, error) {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = defaultSecretExecTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	args := strings.Fields(reference)
+	if len(args) == 0 {
+		return "", fmt.Errorf("empty command")
+	}
+
+	// Output of failed commands is not included in the error, as it may contain the secret
+	var stdout bytes.Buffer
+	command := exec.CommandContext(ctx, args[0], args[1:]...)
+	command.Stdout = &stdout
+
+	if err := command.Run(); err != nil {
+		return "", fmt.Errorf("command '%s' failed: %s", args[0], err.Error())
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+// resolveSecrets replaces the secret references present in the string fields of the config by their values.
+// The YAML path of every resolved field is stored in 'references', pointing to its reference
+func resolveSecrets(ctx context.Context, resolver *SecretsResolver, v reflect.Value, path string, references map[string]string, errs *api.ValidationErrors) {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if !v.IsNil() {
+			resolveSecrets(ctx, resolver, v.Elem(), path, references, errs)
+		}
+
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			name, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			switch {
+			case name == "-":
+				continue
+			case strings.Contains(options, "inline"):
+				resolveSecrets(ctx, resolver, v.Field(i), path, references, errs)
+				continue
+			case name == "":
+				name = strings.ToLower(field.Name)
+			}
+
+			resolveSecrets(ctx, resolver, v.Field(i), joinPath(path, name), references, errs)
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			resolveSecrets(ctx, resolver, v.Index(i), fmt.Sprintf("%s[%d]", path, i), references, errs)
+		}
+
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			return
+		}
+
+		for _, key := range v.MapKeys() {
+			reference := v.MapIndex(key).String()
+			if !strings.HasPrefix(reference, SecretReferencePrefix) {
+				continue
+			}
+
+			childPath := joinPath(path, fmt.Sprint(key.Interface()))
+			value, err := resolver.Resolve(ctx, reference)
+			if err != nil {
+				*errs = append(*errs, api.ValidationError{Path: childPath, Message: err.Error()})
+				continue
+			}
+
+			v.SetMapIndex(key, reflect.ValueOf(value).Convert(v.Type().Elem()))
+			references[childPath] = reference
+		}
+
+	case reflect.String:
+		reference := v.String()
+		if !strings.HasPrefix(reference, SecretReferencePrefix) {
+			return
+		}
+
+		value, err := resolver.Resolve(ctx, reference)
+		if err != nil {
+			*errs = append(*errs, api.ValidationError{Path: path, Message: err.Error()})
+			return
+		}
+
+		v.SetString(value)
+		references[path] = reference
+	}
+}
+
+// redactSecrets replaces the resolved secret values present in an error by their references
+func redactSecrets(err error, resolver *SecretsResolver, references map[string]string) error {
+	if err == nil || len(references) == 0 {
+		return err
+	}
+
+	message := err.Error()
+	for _, reference := range references {
+		value, resolveErr := resolver.Resolve(context.Background(), reference)
+		if resolveErr != nil || value == "" {
+			continue
+		}
+		message = strings.ReplaceAll(message, value, reference)
+	}
+	return errors.New(message)
+}
@@ -0,0 +1,327 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	//
+	"mcp-go/api"
+)
+
+// testSecretProvider represents a provider returning the values of a map, counting the lookups
+type testSecretProvider struct {
+	values  map[string]string
+	lookups int
+}
+
+func (p *testSecretProvider) Name() string {
+	return "test"
+}
+
+func (p *testSecretProvider) Resolve(ctx context.Context, reference string) (string, error) {
+	p.lookups++
+
+	value, ok := p.values[reference]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return value, nil
+}
+
+func TestSecretsResolver(t *testing.T) {
+	provider := &testSecretProvider{values: map[string]string{"db-password": "s3cr3t"}}
+	resolver := NewSecretsResolver(provider)
+
+	tests := []struct {
+		name      string
+		reference string
+		want      string
+		wantErr   string
+	}{
+		{
+			name:      "known secret",
+			reference: "secret://test/db-password",
+			want:      "s3cr3t",
+		},
+		{
+			name:      "unknown secret",
+			reference: "secret://test/api-token",
+			wantErr:   "failed resolving secret 'secret://test/api-token': not found",
+		},
+		{
+			name:      "unknown provider",
+			reference: "secret://vault/db-password",
+			wantErr:   "unknown secret provider 'vault' in 'secret://vault/db-password'",
+		},
+		{
+			name:      "missing reference",
+			reference: "secret://test/",
+			wantErr:   "malformed secret reference 'secret://test/', expected 'secret://<provider>/<reference>'",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := resolver.Resolve(context.Background(), test.reference)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("expected error '%s', got: %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if got != test.want {
+				t.Errorf("expected '%s', got '%s'", test.want, got)
+			}
+		})
+	}
+}
+
+func TestSecretsResolverRefresh(t *testing.T) {
+	provider := &testSecretProvider{values: map[string]string{"db-password": "s3cr3t", "api-token": "t0k3n"}}
+	resolver := NewSecretsResolver(provider)
+
+	for _, reference := range []string{"secret://test/db-password", "secret://test/api-token"} {
+		if _, err := resolver.Resolve(context.Background(), reference); err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+	}
+
+	// Cached values are not looked up again
+	if _, err := resolver.Resolve(context.Background(), "secret://test/db-password"); err != nil || provider.lookups != 2 {
+		t.Fatalf("expected the cached value to be used, got %d lookups and error %v", provider.lookups, err)
+	}
+
+	changed, err := resolver.Refresh(context.Background())
+	if err != nil || changed {
+		t.Fatalf("expected no change, got changed %t and error %v", changed, err)
+	}
+
+	provider.values["db-password"] = "n3w-s3cr3t"
+	changed, err = resolver.Refresh(context.Background())
+	if err != nil || !changed {
+		t.Fatalf("expected a change, got changed %t and error %v", changed, err)
+	}
+	if value, _ := resolver.Resolve(context.Background(), "secret://test/db-password"); value != "n3w-s3cr3t" {
+		t.Errorf("expected the refreshed value, got '%s'", value)
+	}
+
+	// Failing lookups keep the cached value
+	delete(provider.values, "db-password")
+	if _, err = resolver.Refresh(context.Background()); err == nil {
+		t.Fatalf("expected an error refreshing a removed secret")
+	}
+	if value, _ := resolver.Resolve(context.Background(), "secret://test/db-password"); value != "n3w-s3cr3t" {
+		t.Errorf("expected the cached value to be kept, got '%s'", value)
+	}
+
+	// Dropped references are not refreshed anymore
+	resolver.Retain([]string{"secret://test/api-token"})
+	lookups := provider.lookups
+	if _, err = resolver.Refresh(context.Background()); err != nil || provider.lookups != lookups+1 {
+		t.Errorf("expected only the retained reference to be refreshed, got %d lookups and error %v", provider.lookups-lookups, err)
+	}
+}
+
+func TestSecretProviders(t *testing.T) {
+	secretPath := filepath.Join(t.TempDir(), "db-password")
+	if err := os.WriteFile(secretPath, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatalf("failed writing secret file: %s", err.Error())
+	}
+	t.Setenv("TEST_MCPGO_DB_PASSWORD", "s3cr3t")
+
+	tests := []struct {
+		name      string
+		provider  SecretProvider
+		reference string
+		want      string
+		wantErr   string
+	}{
+		{
+			name:      "file without trailing newline",
+			provider:  FileSecretProvider{},
+			reference: strings.TrimPrefix(secretPath, "/"),
+			want:      "s3cr3t",
+		},
+		{
+			name:      "missing file",
+			provider:  FileSecretProvider{},
+			reference: strings.TrimPrefix(secretPath, "/") + ".missing",
+			wantErr:   "open " + secretPath + ".missing: no such file or directory",
+		},
+		{
+			name:      "environment variable",
+			provider:  EnvSecretProvider{},
+			reference: "TEST_MCPGO_DB_PASSWORD",
+			want:      "s3cr3t",
+		},
+		{
+			name:      "missing environment variable",
+			provider:  EnvSecretProvider{},
+			reference: "TEST_MCPGO_MISSING",
+			wantErr:   "environment variable 'TEST_MCPGO_MISSING' is not set",
+		},
+		{
+			name:      "command output",
+			provider:  ExecSecretProvider{},
+			reference: "echo s3cr3t",
+			want:      "s3cr3t",
+		},
+		{
+			name:      "failed command",
+			provider:  ExecSecretProvider{},
+			reference: "false",
+			wantErr:   "command 'false' failed: exit status 1",
+		},
+		{
+			name:      "slow command",
+			provider:  ExecSecretProvider{Timeout: 10 * time.Millisecond},
+			reference: "sleep 5",
+			wantErr:   "command 'sleep' failed: signal: killed",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.provider.Resolve(context.Background(), test.reference)
+			if test.wantErr != "" {
+				if err == nil || err.Error() != test.wantErr {
+					t.Fatalf("expected error '%s', got: %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if got != test.want {
+				t.Errorf("expected '%s', got '%s'", test.want, got)
+			}
+		})
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	resolver := NewSecretsResolver(&testSecretProvider{values: map[string]string{
+		"db-password": "s3cr3t",
+		"api-token":   "t0k3n",
+	}})
+
+	type testSecretsConfig struct {
+		Database struct {
+			Password string `yaml:"password"`
+			Host     string `yaml:"host"`
+		} `yaml:"database"`
+		Tokens  []string          `yaml:"tokens"`
+		Headers map[string]string `yaml:"headers"`
+		Ignored string            `yaml:"-"`
+	}
+
+	var config testSecretsConfig
+	config.Database.Password = "secret://test/db-password"
+	config.Database.Host = "db.example.com"
+	config.Tokens = []string{"plain", "secret://test/api-token"}
+	config.Headers = map[string]string{"Authorization": "secret://test/api-token"}
+	config.Ignored = "secret://test/db-password"
+
+	references := map[string]string{}
+	var errs api.ValidationErrors
+	resolveSecrets(context.Background(), resolver, reflect.ValueOf(&config).Elem(), "", references, &errs)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %s", errs.Error())
+	}
+
+	if config.Database.Password != "s3cr3t" || config.Database.Host != "db.example.com" {
+		t.Errorf("unexpected database section: %+v", config.Database)
+	}
+	if !reflect.DeepEqual(config.Tokens, []string{"plain", "t0k3n"}) {
+		t.Errorf("unexpected tokens: %v", config.Tokens)
+	}
+	if config.Headers["Authorization"] != "t0k3n" {
+		t.Errorf("unexpected headers: %v", config.Headers)
+	}
+	if config.Ignored != "secret://test/db-password" {
+		t.Errorf("expected fields not in the config to be kept, got '%s'", config.Ignored)
+	}
+
+	wantReferences := map[string]string{
+		"database.password":     "secret://test/db-password",
+		"tokens[1]":             "secret://test/api-token",
+		"headers.Authorization": "secret://test/api-token",
+	}
+	if !reflect.DeepEqual(references, wantReferences) {
+		t.Errorf("expected references %v, got %v", wantReferences, references)
+	}
+}
+
+func TestReadFileSecrets(t *testing.T) {
+	t.Setenv("TEST_MCPGO_SERVER_NAME", "s3cr3t-name")
+	t.Setenv("TEST_MCPGO_TRANSPORT", "s3cr3t-transport")
+
+	t.Run("resolved values are hidden when marshalled", func(t *testing.T) {
+		filePath := writeTestConfigFile(t, `
+server:
+  name: secret://env/TEST_MCPGO_SERVER_NAME
+  version: "0.1.0"
+  transport:
+    type: stdio
+`)
+
+		config, err := ReadFile(filePath, testLogger)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if config.Server.Name != "s3cr3t-name" {
+			t.Fatalf("expected the resolved name, got '%s'", config.Server.Name)
+		}
+
+		bytes, err := Marshal(config)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err.Error())
+		}
+		if strings.Contains(string(bytes), "s3cr3t") {
+			t.Errorf("expected the secret not to be marshalled, got:\n%s", bytes)
+		}
+		if !strings.Contains(string(bytes), "name: secret://env/TEST_MCPGO_SERVER_NAME") {
+			t.Errorf("expected the reference to be marshalled, got:\n%s", bytes)
+		}
+	})
+
+	t.Run("resolved values are hidden in validation errors", func(t *testing.T) {
+		filePath := writeTestConfigFile(t, `
+server:
+  transport:
+    type: secret://env/TEST_MCPGO_TRANSPORT
+`)
+
+		_, err := ReadFile(filePath, testLogger)
+		if err == nil {
+			t.Fatalf("expected a validation error")
+		}
+		if strings.Contains(err.Error(), "s3cr3t") || !strings.Contains(err.Error(), "secret://env/TEST_MCPGO_TRANSPORT") {
+			t.Errorf("expected the reference instead of the secret in the error, got: %s", err.Error())
+		}
+	})
+
+	t.Run("unresolved references are reported with their line", func(t *testing.T) {
+		filePath := writeTestConfigFile(t, `
+server:
+  transport:
+    type: stdio
+  name: secret://env/TEST_MCPGO_MISSING
+`)
+
+		_, err := ReadFile(filePath, testLogger)
+		want := "server.name (line 5): failed resolving secret 'secret://env/TEST_MCPGO_MISSING': environment variable 'TEST_MCPGO_MISSING' is not set"
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error '%s', got: %v", want, err)
+		}
+	})
+}
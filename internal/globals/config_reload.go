@@ -0,0 +1,175 @@
+package globals
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"time"
+
+	//
+	"mcp-go/api"
+	"mcp-go/internal/config"
+)
+
+const (
+	// defaultConfigReloadInterval is the time between checks for changes in the config file
+	defaultConfigReloadInterval = 10 * time.Second
+
+	// defaultSecretsRefreshInterval is the time between refreshes of the secrets referenced in the config
+	defaultSecretsRefreshInterval = 5 * time.Minute
+)
+
+// ConfigReloadFunc prepares a component for a new configuration, returning an error when it can not be used.
+// The returned function applies the prepared changes, and it is only called when every component accepted the config
+type ConfigReloadFunc func(newConfig *api.Configuration) (apply func(), err error)
+
+type configReloadHook struct {
+	name string
+	fn   ConfigReloadFunc
+}
+
+// RegisterConfigReloadHook adds a function to be executed every time the config is reloaded
+func (a *ApplicationContext) RegisterConfigReloadHook(name string, fn ConfigReloadFunc) {
+	a.configReloadMutex.Lock()
+	defer a.configReloadMutex.Unlock()
+
+	a.configReloadHooks = append(a.configReloadHooks, configReloadHook{
+		name: name,
+		fn:   fn,
+	})
+}
+
+// ReloadConfig reads the config file again and swaps it atomically.
+// When the file or some component rejects it, the previous config stays active
+func (a *ApplicationContext) ReloadConfig() error {
+	a.configReloadMutex.Lock()
+	defer a.configReloadMutex.Unlock()
+
+	newConfig, err := config.ReadFile(a.configPath, a.Logger)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %s", err.Error())
+	}
+
+	// Give all the components the chance to reject the config before touching anything
+	var applyFuncs []func()
+	for _, hook := range a.configReloadHooks {
+		apply, err := hook.fn(&newConfig)
+		if err != nil {
+			return fmt.Errorf("config rejected by '%s': %s", hook.name, err.Error())
+		}
+
+		if apply != nil {
+			applyFuncs = append(applyFuncs, apply)
+		}
+	}
+
+	a.config.Store(&newConfig)
+	for _, apply := range applyFuncs {
+		apply()
+	}
+
+	// References may be added to a config that had none on startup
+	if len(newConfig.SecretReferences) > 0 {
+		a.StartSecretsWatcher()
+	}
+
+	return nil
+}
+
+// WatchConfig checks the config file from time to time, and reloads it when its content changes.
+// Content is compared instead of modification times, as Kubernetes replaces mounted files through symlinks
+func (a *ApplicationContext) WatchConfig() {
+
+	reloadInterval := a.Config().Server.ConfigReload.Interval
+	if reloadInterval <= 0 {
+		reloadInterval = defaultConfigReloadInterval
+	}
+
+	lastHash, err := hashFile(a.configPath)
+	if err != nil {
+		a.Logger.Error("failed reading config file for watching", "error", err.Error())
+	}
+
+	a.Logger.Info("config watcher running", "path", a.configPath)
+
+	for {
+		select {
+		case <-a.Context.Done():
+			return
+		case <-time.After(reloadInterval):
+		}
+
+		currentHash, err := hashFile(a.configPath)
+		if err != nil {
+			a.Logger.Error("failed reading config file for watching", "error", err.Error())
+			continue
+		}
+
+		if bytes.Equal(currentHash, lastHash) {
+			continue
+		}
+		lastHash = currentHash
+
+		if err := a.ReloadConfig(); err != nil {
+			a.Logger.Error("failed reloading config, keeping previous one", "error", err.Error())
+			continue
+		}
+		a.Logger.Info("config reloaded", "path", a.configPath)
+	}
+}
+
+// StartSecretsWatcher watches the secrets referenced in the config in background. It is started only once,
+// either on startup or by the first reload bringing secret references
+func (a *ApplicationContext) StartSecretsWatcher() {
+	a.secretsWatcherOnce.Do(func() {
+		go a.watchSecrets()
+	})
+}
+
+// watchSecrets resolves the secret references present in the config from time to time.
+// When some secret changes, the config is reloaded so components pick up the new value
+func (a *ApplicationContext) watchSecrets() {
+
+	a.Logger.Info("secrets watcher running", "references", len(a.Config().SecretReferences))
+
+	for {
+		// The interval is read every time, as it may change on reloads
+		refreshInterval := a.Config().Server.Secrets.RefreshInterval
+		if refreshInterval <= 0 {
+			refreshInterval = defaultSecretsRefreshInterval
+		}
+
+		select {
+		case <-a.Context.Done():
+			return
+		case <-time.After(refreshInterval):
+		}
+
+		changed, err := config.DefaultSecretsResolver.Refresh(a.Context)
+		if err != nil {
+			a.Logger.Error("failed refreshing some secrets, keeping previous values", "error", err.Error())
+		}
+
+		if !changed {
+			continue
+		}
+
+		if err := a.ReloadConfig(); err != nil {
+			a.Logger.Error("failed reloading config after secrets changed, keeping previous one", "error", err.Error())
+			continue
+		}
+		a.Logger.Info("config reloaded after secrets changed")
+	}
+}
+
+// hashFile returns the SHA-256 hash of the content of a file
+func hashFile(filePath string) ([]byte, error) {
+	fileBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	fileHash := sha256.Sum256(fileBytes)
+	return fileHash[:], nil
+}
@@ -0,0 +1,79 @@
+package globals
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	//
+	"mcp-go/internal/config"
+)
+
+// writeTestConfigFile writes the config into the given path, failing the test on errors
+func writeTestConfigFile(t *testing.T, filePath string, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(filePath, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed writing config file: %s", err.Error())
+	}
+}
+
+func TestReloadConfigStartsSecretsWatcher(t *testing.T) {
+	t.Setenv("TEST_MCPGO_SERVER_NAME", "first-name")
+
+	logger := slog.New(slog.DiscardHandler)
+	filePath := filepath.Join(t.TempDir(), "config.yaml")
+
+	// Startup config has no secret references, so the watcher is not started with it
+	writeTestConfigFile(t, filePath, `
+server:
+  name: mcp-go
+  version: "0.1.0"
+  transport:
+    type: stdio
+  secrets:
+    refresh_interval: 10ms
+`)
+
+	initialConfig, err := config.ReadFile(filePath, logger)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	appCtx := NewApplicationContextFromConfig(ctx, logger, &initialConfig)
+	appCtx.configPath = filePath
+
+	writeTestConfigFile(t, filePath, `
+server:
+  name: secret://env/TEST_MCPGO_SERVER_NAME
+  version: "0.1.0"
+  transport:
+    type: stdio
+  secrets:
+    refresh_interval: 10ms
+`)
+
+	if err := appCtx.ReloadConfig(); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if name := appCtx.Config().Server.Name; name != "first-name" {
+		t.Fatalf("expected the resolved name, got '%s'", name)
+	}
+
+	// Secrets brought by the reload are refreshed, reloading the config when they change
+	t.Setenv("TEST_MCPGO_SERVER_NAME", "second-name")
+
+	deadline := time.Now().Add(2 * time.Second)
+	for appCtx.Config().Server.Name != "second-name" {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the refreshed name, got '%s'", appCtx.Config().Server.Name)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -7,31 +7,77 @@ import (
 	"mcp-go/api"
 	"mcp-go/internal/config"
 	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 )
 
 type ApplicationContext struct {
 	Context context.Context
 	Logger  *slog.Logger
-	Config  *api.Configuration
+
+	// Config stuff
+	config            atomic.Pointer[api.Configuration]
+	configPath        string
+	configReloadHooks []configReloadHook
+	configReloadMutex sync.Mutex
+
+	secretsWatcherOnce sync.Once
+
+	// Lifecycle stuff
+	cancel         context.CancelFunc
+	stopHooks      []stopHook
+	stopHooksMutex sync.Mutex
+
+	// Health stuff
+	readinessChecks      map[string]ReadinessCheckFunc
+	readinessChecksMutex sync.Mutex
 }
 
 func NewApplicationContext() (*ApplicationContext, error) {
 
+	// The context is cancelled when a termination signal arrives, or when shutdown starts
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+
 	appCtx := &ApplicationContext{
-		Context: context.Background(),
+		Context: ctx,
 		Logger:  slog.New(slog.NewJSONHandler(os.Stderr, nil)),
+		cancel:  cancel,
 	}
 
 	// Parse and store the config
 	var configFlag = flag.String("config", "config.yaml", "path to the config file")
 	flag.Parse()
 
-	configContent, err := config.ReadFile(*configFlag)
+	configContent, err := config.ReadFile(*configFlag, appCtx.Logger)
 	if err != nil {
 		return appCtx, err
 	}
-	appCtx.Config = &configContent
+	appCtx.configPath = *configFlag
+	appCtx.config.Store(&configContent)
 
 	//
 	return appCtx, nil
 }
+
+// NewApplicationContextFromConfig returns an application context holding the given config,
+// without reading flags nor files. It is used to run components outside the server, like in tests
+func NewApplicationContextFromConfig(ctx context.Context, logger *slog.Logger, config *api.Configuration) *ApplicationContext {
+	ctx, cancel := context.WithCancel(ctx)
+
+	appCtx := &ApplicationContext{
+		Context: ctx,
+		Logger:  logger,
+		cancel:  cancel,
+	}
+	appCtx.config.Store(config)
+
+	return appCtx
+}
+
+// Config returns the configuration currently active.
+// Callers should not keep the returned pointer for long, as it is replaced on every reload
+func (a *ApplicationContext) Config() *api.Configuration {
+	return a.config.Load()
+}
@@ -0,0 +1,74 @@
+package globals
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// StopPhase defines the moment of the shutdown when a stop hook is executed.
+// Hooks are executed phase by phase, and in registration order inside the same phase
+type StopPhase int
+
+const (
+	// StopPhaseServers is used to stop accepting new requests
+	StopPhaseServers StopPhase = iota
+
+	// StopPhaseDrain is used to wait for in-flight work to finish
+	StopPhaseDrain
+
+	// StopPhaseWorkers is used to stop background workers
+	StopPhaseWorkers
+
+	// StopPhaseResources is used to release pooled resources like database connections
+	StopPhaseResources
+)
+
+// StopHookFunc is a function executed during the shutdown of the application
+type StopHookFunc func(ctx context.Context) error
+
+type stopHook struct {
+	phase StopPhase
+	name  string
+	fn    StopHookFunc
+}
+
+// RegisterStopHook adds a function to be executed during the shutdown, in the given phase
+func (a *ApplicationContext) RegisterStopHook(phase StopPhase, name string, fn StopHookFunc) {
+	a.stopHooksMutex.Lock()
+	defer a.stopHooksMutex.Unlock()
+
+	a.stopHooks = append(a.stopHooks, stopHook{
+		phase: phase,
+		name:  name,
+		fn:    fn,
+	})
+}
+
+// Shutdown cancels the application context, then executes all the registered stop hooks in order.
+// The given context bounds the time spent in the whole process
+func (a *ApplicationContext) Shutdown(ctx context.Context) error {
+	a.cancel()
+
+	a.stopHooksMutex.Lock()
+	hooks := make([]stopHook, len(a.stopHooks))
+	copy(hooks, a.stopHooks)
+	a.stopHooksMutex.Unlock()
+
+	sort.SliceStable(hooks, func(i, j int) bool {
+		return hooks[i].phase < hooks[j].phase
+	})
+
+	var errs []error
+	for _, hook := range hooks {
+		a.Logger.Info("executing stop hook", "name", hook.name)
+
+		if err := hook.fn(ctx); err != nil {
+			a.Logger.Error("stop hook failed", "name", hook.name, "error", err.Error())
+			errs = append(errs, fmt.Errorf("stop hook '%s' failed: %s", hook.name, err.Error()))
+		}
+	}
+
+	return errors.Join(errs...)
+}
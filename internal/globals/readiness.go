@@ -0,0 +1,37 @@
+package globals
+
+import (
+	"context"
+)
+
+// ReadinessCheckFunc is a function that returns an error when a dependency is not ready
+type ReadinessCheckFunc func(ctx context.Context) error
+
+// RegisterReadinessCheck adds a named check to be evaluated when the readiness is requested
+func (a *ApplicationContext) RegisterReadinessCheck(name string, fn ReadinessCheckFunc) {
+	a.readinessChecksMutex.Lock()
+	defer a.readinessChecksMutex.Unlock()
+
+	if a.readinessChecks == nil {
+		a.readinessChecks = map[string]ReadinessCheckFunc{}
+	}
+	a.readinessChecks[name] = fn
+}
+
+// CheckReadiness executes all the registered checks, returning the result of each one by name.
+// Passing checks are present with a nil error
+func (a *ApplicationContext) CheckReadiness(ctx context.Context) map[string]error {
+	a.readinessChecksMutex.Lock()
+	checks := make(map[string]ReadinessCheckFunc, len(a.readinessChecks))
+	for name, fn := range a.readinessChecks {
+		checks[name] = fn
+	}
+	a.readinessChecksMutex.Unlock()
+
+	results := make(map[string]error, len(checks))
+	for name, fn := range checks {
+		results[name] = fn(ctx)
+	}
+
+	return results
+}
@@ -1,6 +1,11 @@
 package handlers
 
-import "mcp-go/internal/globals"
+import (
+	"slices"
+
+	"mcp-go/api"
+	"mcp-go/internal/globals"
+)
 
 type HandlersManagerDependencies struct {
 	AppCtx *globals.ApplicationContext
@@ -11,7 +16,47 @@ type HandlersManager struct {
 }
 
 func NewHandlersManager(deps HandlersManagerDependencies) *HandlersManager {
-	return &HandlersManager{
+	hm := &HandlersManager{
 		dependencies: deps,
 	}
+
+	// The issuer must be reachable to serve its metadata when it is proxied,
+	// and to discover its keys when tokens are validated locally against it
+	config := hm.dependencies.AppCtx.Config()
+	discoversIssuer := config.Middleware.JWT.Enabled && config.Middleware.JWT.Validation.Strategy == "local" &&
+		config.Middleware.JWT.Validation.Local.IssuerUri != ""
+
+	if config.OAuthAuthorizationServer.Enabled || discoversIssuer {
+		hm.dependencies.AppCtx.RegisterReadinessCheck("oauth_issuer", hm.checkIssuerReachable)
+	}
+
+	return hm
+}
+
+// getIssuerUri returns the issuer of the authorization server.
+// It defaults to the issuer trusted by the JWT validation, so it is not repeated in the config
+func getIssuerUri(config *api.Configuration) string {
+	if config.OAuthAuthorizationServer.IssuerUri != "" {
+		return config.OAuthAuthorizationServer.IssuerUri
+	}
+	return config.Middleware.JWT.Validation.Local.IssuerUri
+}
+
+// getAuthorizationServers returns the authorization servers advertised by the protected resource:
+// the configured ones, followed by every issuer trusted by the JWT validation, without duplicates
+func getAuthorizationServers(config *api.Configuration) []string {
+	localConfig := config.Middleware.JWT.Validation.Local
+
+	issuerUris := slices.Concat(config.OAuthProtectedResource.AuthServers, []string{localConfig.IssuerUri}, localConfig.Issuers)
+	for _, trustedIssuer := range localConfig.TrustedIssuers {
+		issuerUris = append(issuerUris, trustedIssuer.Issuer)
+	}
+
+	var authServers []string
+	for _, issuerUri := range issuerUris {
+		if issuerUri != "" && !slices.Contains(authServers, issuerUri) {
+			authServers = append(authServers, issuerUri)
+		}
+	}
+	return authServers
 }
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	//
+	"mcp-go/api"
+	"mcp-go/internal/globals"
+)
+
+func TestGetAuthorizationServers(t *testing.T) {
+	tests := []struct {
+		name        string
+		authServers []string
+		localConfig api.JWTValidationLocalConfig
+		want        []string
+	}{
+		{
+			name:        "configured servers are kept first",
+			authServers: []string{"https://first.example.com"},
+			localConfig: api.JWTValidationLocalConfig{
+				Issuers: []string{"https://second.example.com"},
+			},
+			want: []string{"https://first.example.com", "https://second.example.com"},
+		},
+		{
+			name:        "every trusted issuer is merged without duplicates",
+			authServers: []string{"https://first.example.com"},
+			localConfig: api.JWTValidationLocalConfig{
+				IssuerUri: "https://first.example.com",
+				Issuers:   []string{"https://second.example.com"},
+				TrustedIssuers: []api.JWTValidationTrustedIssuer{
+					{Issuer: "https://third.example.com"},
+					{Issuer: "https://second.example.com"},
+				},
+			},
+			want: []string{"https://first.example.com", "https://second.example.com", "https://third.example.com"},
+		},
+		{
+			name: "issuers are advertised when no server is configured",
+			localConfig: api.JWTValidationLocalConfig{
+				TrustedIssuers: []api.JWTValidationTrustedIssuer{
+					{Issuer: "https://third.example.com"},
+				},
+			},
+			want: []string{"https://third.example.com"},
+		},
+		{
+			name: "nothing is advertised without servers nor issuers",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &api.Configuration{}
+			config.OAuthProtectedResource.AuthServers = tt.authServers
+			config.Middleware.JWT.Validation.Local = tt.localConfig
+
+			if got := getAuthorizationServers(config); !slices.Equal(got, tt.want) {
+				t.Errorf("authorization servers = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetIssuerUri(t *testing.T) {
+	config := &api.Configuration{}
+	config.Middleware.JWT.Validation.Local.IssuerUri = "https://issuer.example.com"
+
+	if got := getIssuerUri(config); got != "https://issuer.example.com" {
+		t.Errorf("issuer = %s, want the one trusted by the JWT validation", got)
+	}
+
+	config.OAuthAuthorizationServer.IssuerUri = "https://proxied.example.com"
+	if got := getIssuerUri(config); got != "https://proxied.example.com" {
+		t.Errorf("issuer = %s, want the configured one", got)
+	}
+}
+
+func TestHandleReadyzIssuer(t *testing.T) {
+	issuerServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(issuerServer.Close)
+
+	unreachableServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(unreachableServer.Close)
+
+	tests := []struct {
+		name       string
+		configure  func(config *api.Configuration)
+		wantStatus int
+		wantCheck  string
+	}{
+		{
+			name:       "no issuer is checked without one to proxy or discover",
+			configure:  func(config *api.Configuration) {},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "proxied issuer is checked",
+			configure: func(config *api.Configuration) {
+				config.OAuthAuthorizationServer.Enabled = true
+				config.OAuthAuthorizationServer.IssuerUri = issuerServer.URL
+			},
+			wantStatus: http.StatusOK,
+			wantCheck:  "ok",
+		},
+		{
+			name: "unreachable proxied issuer fails",
+			configure: func(config *api.Configuration) {
+				config.OAuthAuthorizationServer.Enabled = true
+				config.OAuthAuthorizationServer.IssuerUri = unreachableServer.URL
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantCheck:  "fail",
+		},
+		{
+			name: "issuer discovered by the JWT validation is checked",
+			configure: func(config *api.Configuration) {
+				config.Middleware.JWT.Enabled = true
+				config.Middleware.JWT.Validation.Strategy = "local"
+				config.Middleware.JWT.Validation.Local.IssuerUri = unreachableServer.URL
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantCheck:  "fail",
+		},
+		{
+			name: "issuer is not checked when tokens are not validated locally",
+			configure: func(config *api.Configuration) {
+				config.Middleware.JWT.Enabled = true
+				config.Middleware.JWT.Validation.Strategy = "external"
+				config.Middleware.JWT.Validation.Local.IssuerUri = unreachableServer.URL
+			},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &api.Configuration{}
+			tt.configure(config)
+
+			appCtx := globals.NewApplicationContextFromConfig(context.Background(), slog.New(slog.DiscardHandler), config)
+			hm := NewHandlersManager(HandlersManagerDependencies{AppCtx: appCtx})
+
+			recorder := httptest.NewRecorder()
+			hm.HandleReadyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+
+			var response HealthResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("failed decoding response: %s", err.Error())
+			}
+			if got := response.Checks["oauth_issuer"].Status; got != tt.wantCheck {
+				t.Errorf("oauth_issuer check = '%s', want '%s'", got, tt.wantCheck)
+			}
+		})
+	}
+}
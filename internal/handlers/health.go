@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	// defaultHealthCheckTimeout is the time given to all the readiness checks when no timeout is configured
+	defaultHealthCheckTimeout = 5 * time.Second
+)
+
+// HealthCheckResult represents the result of a single readiness check
+type HealthCheckResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HealthResponse represents the response returned by liveness and readiness endpoints
+type HealthResponse struct {
+	Status  string                       `json:"status"`
+	Checks  map[string]HealthCheckResult `json:"checks,omitempty"`
+	Failing []string                     `json:"failing,omitempty"`
+}
+
+// HandleHealthz process requests for the liveness endpoint.
+// The process is alive while it is able to answer
+func (h *HandlersManager) HandleHealthz(response http.ResponseWriter, request *http.Request) {
+	h.writeHealthResponse(response, http.StatusOK, &HealthResponse{
+		Status: "ok",
+	})
+}
+
+// HandleReadyz process requests for the readiness endpoint.
+// The process is ready when all the registered dependency checks pass
+func (h *HandlersManager) HandleReadyz(response http.ResponseWriter, request *http.Request) {
+
+	checkTimeout := h.dependencies.AppCtx.Config().Server.Health.CheckTimeout
+	if checkTimeout <= 0 {
+		checkTimeout = defaultHealthCheckTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(request.Context(), checkTimeout)
+	defer cancel()
+
+	results := h.dependencies.AppCtx.CheckReadiness(ctx)
+
+	// Stop receiving traffic as soon as the shutdown starts
+	if err := h.dependencies.AppCtx.Context.Err(); err != nil {
+		results["shutdown"] = fmt.Errorf("server is shutting down")
+	}
+
+	ResponseObject := &HealthResponse{
+		Status: "ok",
+		Checks: map[string]HealthCheckResult{},
+	}
+
+	for name, err := range results {
+		if err != nil {
+			ResponseObject.Checks[name] = HealthCheckResult{Status: "fail", Error: err.Error()}
+			ResponseObject.Failing = append(ResponseObject.Failing, name)
+			continue
+		}
+		ResponseObject.Checks[name] = HealthCheckResult{Status: "ok"}
+	}
+	sort.Strings(ResponseObject.Failing)
+
+	statusCode := http.StatusOK
+	if len(ResponseObject.Failing) > 0 {
+		ResponseObject.Status = "fail"
+		statusCode = http.StatusServiceUnavailable
+	}
+
+	h.writeHealthResponse(response, statusCode, ResponseObject)
+}
+
+// writeHealthResponse sends the health response to the client as JSON
+func (h *HandlersManager) writeHealthResponse(response http.ResponseWriter, statusCode int, responseObject *HealthResponse) {
+
+	// Transform into JSON
+	ResponseObjectBytes, err := json.Marshal(responseObject)
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error converting response into json", "error", err.Error())
+		http.Error(response, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.Header().Set("Cache-Control", "no-store")
+	response.WriteHeader(statusCode)
+
+	_, err = response.Write(ResponseObjectBytes)
+	if err != nil {
+		h.dependencies.AppCtx.Logger.Error("error sending response to client", "error", err.Error())
+		return
+	}
+}
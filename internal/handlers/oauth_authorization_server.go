@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"net/http"
 )
@@ -11,7 +13,7 @@ HandleOauthAuthorizationServer process requests for endpoint: /.well-known/oauth
 
 func (h *HandlersManager) HandleOauthAuthorizationServer(response http.ResponseWriter, request *http.Request) {
 
-	remoteUrl := h.dependencies.AppCtx.Config.OAuthAuthorizationServer.IssuerUri + "/.well-known/openid-configuration"
+	remoteUrl := getIssuerUri(h.dependencies.AppCtx.Config()) + "/.well-known/openid-configuration"
 	remoteResponse, err := http.Get(remoteUrl)
 	if err != nil {
 		h.dependencies.AppCtx.Logger.Error("error getting content from /.well-known/openid-configuration", "error", err.Error())
@@ -39,3 +41,31 @@ func (h *HandlersManager) HandleOauthAuthorizationServer(response http.ResponseW
 		return
 	}
 }
+
+// checkIssuerReachable verifies the OpenID configuration of the issuer can be retrieved
+func (h *HandlersManager) checkIssuerReachable(ctx context.Context) error {
+
+	// Reloads may remove the issuer, leaving nothing to reach
+	issuerUri := getIssuerUri(h.dependencies.AppCtx.Config())
+	if issuerUri == "" {
+		return nil
+	}
+
+	remoteUrl := issuerUri + "/.well-known/openid-configuration"
+	remoteRequest, err := http.NewRequestWithContext(ctx, http.MethodGet, remoteUrl, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %s", err.Error())
+	}
+
+	remoteResponse, err := http.DefaultClient.Do(remoteRequest)
+	if err != nil {
+		return fmt.Errorf("issuer not reachable: %s", err.Error())
+	}
+	defer remoteResponse.Body.Close()
+
+	if remoteResponse.StatusCode != http.StatusOK {
+		return fmt.Errorf("issuer answered with unexpected status: %d", remoteResponse.StatusCode)
+	}
+
+	return nil
+}
@@ -35,22 +35,26 @@ type OauthProtectedResourceResponse struct {
 // HandleOauthProtectedResources process requests for endpoint: /.well-known/oauth-protected-resource
 func (h *HandlersManager) HandleOauthProtectedResources(response http.ResponseWriter, request *http.Request) {
 
+	// Take the config once, as it can be replaced by a reload meanwhile
+	config := h.dependencies.AppCtx.Config()
+	protectedResourceConfig := config.OAuthProtectedResource
+
 	//
 	ResponseObject := &OauthProtectedResourceResponse{
-		Resource:                              h.dependencies.AppCtx.Config.OAuthProtectedResource.Resource,
-		AuthorizationServers:                  h.dependencies.AppCtx.Config.OAuthProtectedResource.AuthServers,
-		JwksUri:                               h.dependencies.AppCtx.Config.OAuthProtectedResource.JWKSUri,
-		ScopesSupported:                       h.dependencies.AppCtx.Config.OAuthProtectedResource.ScopesSupported,
-		BearerMethodsSupported:                h.dependencies.AppCtx.Config.OAuthProtectedResource.BearerMethodsSupported,
-		ResourceSigningAlgValuesSupported:     h.dependencies.AppCtx.Config.OAuthProtectedResource.ResourceSigningAlgValuesSupported,
-		ResourceName:                          h.dependencies.AppCtx.Config.OAuthProtectedResource.ResourceName,
-		ResourceDocumentation:                 h.dependencies.AppCtx.Config.OAuthProtectedResource.ResourceDocumentation,
-		ResourcePolicyUri:                     h.dependencies.AppCtx.Config.OAuthProtectedResource.ResourcePolicyUri,
-		ResourceTosUri:                        h.dependencies.AppCtx.Config.OAuthProtectedResource.ResourceTosUri,
-		TlsClientCertificateBoundAccessTokens: h.dependencies.AppCtx.Config.OAuthProtectedResource.TLSClientCertificateBoundAccessTokens,
-		AuthorizationDetailsTypesSupported:    h.dependencies.AppCtx.Config.OAuthProtectedResource.AuthorizationDetailsTypesSupported,
-		DpopSigningAlgValuesSupported:         h.dependencies.AppCtx.Config.OAuthProtectedResource.DPoPSigningAlgValuesSupported,
-		DpopBoundAccessTokensRequired:         h.dependencies.AppCtx.Config.OAuthProtectedResource.DPoPBoundAccessTokensRequired,
+		Resource:                              protectedResourceConfig.Resource,
+		AuthorizationServers:                  getAuthorizationServers(config),
+		JwksUri:                               protectedResourceConfig.JWKSUri,
+		ScopesSupported:                       config.GetScopesSupported(),
+		BearerMethodsSupported:                protectedResourceConfig.BearerMethodsSupported,
+		ResourceSigningAlgValuesSupported:     protectedResourceConfig.ResourceSigningAlgValuesSupported,
+		ResourceName:                          protectedResourceConfig.ResourceName,
+		ResourceDocumentation:                 protectedResourceConfig.ResourceDocumentation,
+		ResourcePolicyUri:                     protectedResourceConfig.ResourcePolicyUri,
+		ResourceTosUri:                        protectedResourceConfig.ResourceTosUri,
+		TlsClientCertificateBoundAccessTokens: protectedResourceConfig.TLSClientCertificateBoundAccessTokens,
+		AuthorizationDetailsTypesSupported:    protectedResourceConfig.AuthorizationDetailsTypesSupported,
+		DpopSigningAlgValuesSupported:         protectedResourceConfig.DPoPSigningAlgValuesSupported,
+		DpopBoundAccessTokensRequired:         protectedResourceConfig.DPoPBoundAccessTokensRequired,
 	}
 
 	// Transform into JSON
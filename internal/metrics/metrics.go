@@ -0,0 +1,142 @@
+package metrics
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	//
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "mcp_go"
+)
+
+var (
+	// Registry holds all the metrics exposed by the server.
+	// A dedicated registry is used to avoid exposing metrics registered globally by dependencies
+	Registry = prometheus.NewRegistry()
+
+	// HTTPRequestsTotal counts HTTP requests by route, method and status code
+	HTTPRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "Total number of HTTP requests by route, method and status code",
+	}, []string{"path", "method", "status"})
+
+	// HTTPRequestDuration measures HTTP request latency by route, method and status code
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of HTTP requests by route, method and status code",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"path", "method", "status"})
+
+	// JWTRejectionsTotal counts requests rejected by the JWT validation middleware by reason
+	JWTRejectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "jwt",
+		Name:      "rejections_total",
+		Help:      "Total number of requests rejected by the JWT validation middleware by reason",
+	}, []string{"reason"})
+
+	// JWKSRefreshesTotal counts JWKS refreshes by result (success, failure)
+	JWKSRefreshesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "jwks",
+		Name:      "refreshes_total",
+		Help:      "Total number of JWKS refreshes by result",
+	}, []string{"result"})
+
+	// TokenIntrospectionsTotal counts token introspections by result (active, inactive, cached, failure)
+	TokenIntrospectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "introspection",
+		Name:      "lookups_total",
+		Help:      "Total number of token introspections by result",
+	}, []string{"result"})
+
+	// ToolCallsTotal counts MCP tool calls by tool name
+	ToolCallsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "tool",
+		Name:      "calls_total",
+		Help:      "Total number of MCP tool calls by tool name",
+	}, []string{"tool"})
+
+	// ToolCallErrorsTotal counts MCP tool calls that failed or returned an error result by tool name
+	ToolCallErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "tool",
+		Name:      "call_errors_total",
+		Help:      "Total number of MCP tool calls finished with error by tool name",
+	}, []string{"tool"})
+
+	// ToolCallDuration measures MCP tool call latency by tool name
+	ToolCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "tool",
+		Name:      "call_duration_seconds",
+		Help:      "Latency of MCP tool calls by tool name",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"tool"})
+
+	// jwksLastRefresh stores the unix time of the last successful JWKS refresh
+	jwksLastRefresh atomic.Int64
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestsTotal,
+		HTTPRequestDuration,
+		JWTRejectionsTotal,
+		JWKSRefreshesTotal,
+		TokenIntrospectionsTotal,
+		ToolCallsTotal,
+		ToolCallErrorsTotal,
+		ToolCallDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "jwks",
+			Name:      "cache_age_seconds",
+			Help:      "Seconds since the last successful JWKS refresh. It is -1 until the first one",
+		}, jwksCacheAge),
+	)
+}
+
+// Handler returns the HTTP handler exposing the metrics in Prometheus format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveJWKSRefresh records the result of a JWKS refresh
+func ObserveJWKSRefresh(err error) {
+	if err != nil {
+		JWKSRefreshesTotal.WithLabelValues("failure").Inc()
+		return
+	}
+
+	JWKSRefreshesTotal.WithLabelValues("success").Inc()
+	jwksLastRefresh.Store(time.Now().Unix())
+}
+
+// ObserveTokenIntrospection records the result of a token introspection
+func ObserveTokenIntrospection(result string) {
+	TokenIntrospectionsTotal.WithLabelValues(result).Inc()
+}
+
+// jwksCacheAge returns the seconds elapsed since the last successful JWKS refresh
+func jwksCacheAge() float64 {
+	lastRefresh := jwksLastRefresh.Load()
+	if lastRefresh == 0 {
+		return -1
+	}
+	return time.Since(time.Unix(lastRefresh, 0)).Seconds()
+}
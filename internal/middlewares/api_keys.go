@@ -0,0 +1,210 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	//
+	"mcp-go/api"
+
+	//
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// defaultAPIKeyHeader is the header carrying the API key when none is configured
+	defaultAPIKeyHeader = "X-API-Key"
+)
+
+var (
+	errInvalidAPIKey = errors.New("API key is not valid")
+	errExpiredAPIKey = errors.New("API key is expired")
+)
+
+// apiKeyAuthenticator checks the API keys presented by machine clients against their configured hashes.
+// Keys are presented as '<name>.<secret>', so only the hash of the named key is verified.
+// Slow hashes (argon2id, bcrypt) are verified once per key, remembering the hash each key matched
+type apiKeyAuthenticator struct {
+	verifiedMutex sync.RWMutex
+	verified      map[[sha256.Size]byte]string
+}
+
+func newAPIKeyAuthenticator() *apiKeyAuthenticator {
+	return &apiKeyAuthenticator{
+		verified: map[[sha256.Size]byte]string{},
+	}
+}
+
+// getAPIKeyHeader returns the header carrying the API key
+func getAPIKeyHeader(config api.JWTValidationAPIKeyConfig) string {
+	if config.Header != "" {
+		return config.Header
+	}
+	return defaultAPIKeyHeader
+}
+
+// authenticate returns the configured key matching the presented one.
+// Keys with a broken hash are skipped, so they never match
+func (a *apiKeyAuthenticator) authenticate(config api.JWTValidationAPIKeyConfig, presentedKey string) (*api.JWTValidationAPIKey, error) {
+	keyName, _, found := strings.Cut(presentedKey, ".")
+	if !found || keyName == "" {
+		return nil, fmt.Errorf("%w: expected format: <name>.<secret>", errInvalidAPIKey)
+	}
+
+	var matchingKey *api.JWTValidationAPIKey
+	for i, key := range config.Keys {
+		if key.Name == keyName {
+			matchingKey = &config.Keys[i]
+			break
+		}
+	}
+
+	if matchingKey == nil {
+		return nil, fmt.Errorf("%w: unknown key '%s'", errInvalidAPIKey, keyName)
+	}
+
+	// Keys removed from the config, or whose hash changed, are verified again
+	presentedKeyHash := sha256.Sum256([]byte(presentedKey))
+
+	a.verifiedMutex.RLock()
+	verifiedHash, verified := a.verified[presentedKeyHash]
+	a.verifiedMutex.RUnlock()
+
+	if !verified || verifiedHash != matchingKey.Hash {
+		matched, err := verifyAPIKeyHash(matchingKey.Hash, presentedKey)
+		if err != nil {
+			return nil, fmt.Errorf("%w: key '%s' is skipped, as its hash is invalid: %s", errInvalidAPIKey, keyName, err.Error())
+		}
+
+		if !matched {
+			return nil, fmt.Errorf("%w: secret of key '%s' does not match", errInvalidAPIKey, keyName)
+		}
+	}
+
+	a.verifiedMutex.Lock()
+	a.verified[presentedKeyHash] = matchingKey.Hash
+	a.verifiedMutex.Unlock()
+
+	if !matchingKey.ExpiresAt.IsZero() && time.Now().After(matchingKey.ExpiresAt) {
+		return nil, fmt.Errorf("%w: '%s'", errExpiredAPIKey, matchingKey.Name)
+	}
+
+	return matchingKey, nil
+}
+
+// getAPIKeyClaims returns the synthetic claims given to the callers of a key, shaped as the ones of a JWT,
+// so allowance conditions and tools handle them the same way
+func getAPIKeyClaims(key *api.JWTValidationAPIKey) map[string]any {
+	subject := key.Subject
+	if subject == "" {
+		subject = key.Name
+	}
+
+	groups := []any{}
+	for _, group := range key.Groups {
+		groups = append(groups, group)
+	}
+
+	claims := map[string]any{
+		"sub":     subject,
+		"name":    key.Name,
+		"groups":  groups,
+		"scope":   strings.Join(key.Scopes, " "),
+		"api_key": key.Name,
+	}
+
+	if !key.ExpiresAt.IsZero() {
+		claims["exp"] = float64(key.ExpiresAt.Unix())
+	}
+
+	return claims
+}
+
+// verifyAPIKeyHash compares a key with a salted hash in one of the supported formats:
+//   - argon2id, in PHC format: $argon2id$v=19$m=65536,t=3,p=4$<base64 salt>$<base64 hash>
+//   - bcrypt: $2a$10$...
+//   - sha256: $sha256$<salt>$<hex of sha256(salt + key)>
+func verifyAPIKeyHash(hash string, key string) (bool, error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return verifyArgon2idHash(hash, key)
+
+	case strings.HasPrefix(hash, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(key))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+
+	case strings.HasPrefix(hash, "$sha256$"):
+		parts := strings.Split(hash, "$")
+		if len(parts) != 4 || parts[2] == "" {
+			return false, fmt.Errorf("expected format: $sha256$<salt>$<hex hash>")
+		}
+
+		expectedHash, err := hex.DecodeString(parts[3])
+		if err != nil {
+			return false, fmt.Errorf("error decoding hash: %s", err.Error())
+		}
+
+		keyHash := sha256.Sum256([]byte(parts[2] + key))
+		return subtle.ConstantTimeCompare(keyHash[:], expectedHash) == 1, nil
+
+	default:
+		return false, fmt.Errorf("unsupported hash format")
+	}
+}
+
+// verifyArgon2idHash compares a key with an argon2id hash in PHC format
+// Ref: https://github.com/P-H-C/phc-string-format/blob/master/phc-sf-spec.md
+func verifyArgon2idHash(hash string, key string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, fmt.Errorf("expected format: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, fmt.Errorf("unsupported argon2 version: %s", parts[2])
+	}
+
+	var memory, iterations uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &threads); err != nil {
+		return false, fmt.Errorf("error parsing argon2 parameters: %s", err.Error())
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, fmt.Errorf("error decoding salt: %s", err.Error())
+	}
+
+	expectedHash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, fmt.Errorf("error decoding hash: %s", err.Error())
+	}
+
+	keyHash := argon2.IDKey([]byte(key), salt, iterations, memory, threads, uint32(len(expectedHash)))
+	return subtle.ConstantTimeCompare(keyHash, expectedHash) == 1, nil
+}
+
+// getSyntheticToken encodes claims as an unsigned JWT, so they can be forwarded to the tools
+// in the same header as the validated tokens
+func getSyntheticToken(claims map[string]any) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".", nil
+}
@@ -0,0 +1,178 @@
+package middlewares
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	//
+	"mcp-go/api"
+)
+
+func TestAPIKeyAuthenticatorAuthenticate(t *testing.T) {
+	config := newTestAPIKeyConfig(t)
+
+	tests := []struct {
+		name     string
+		key      string
+		wantName string
+		wantErr  error
+	}{
+		{name: "sha256 key is accepted", key: "sha-bot.s3cr3t", wantName: "sha-bot"},
+		{name: "argon2id key is accepted", key: "argon-bot.s3cr3t", wantName: "argon-bot"},
+		{name: "bcrypt key is accepted", key: "bcrypt-bot.s3cr3t", wantName: "bcrypt-bot"},
+		{name: "wrong secret is rejected", key: "sha-bot.wrong", wantErr: errInvalidAPIKey},
+		{name: "unknown key is rejected", key: "random-bot.s3cr3t", wantErr: errInvalidAPIKey},
+		{name: "expired key is rejected", key: "expired-bot.s3cr3t", wantErr: errExpiredAPIKey},
+		{name: "key without name is rejected", key: "s3cr3t", wantErr: errInvalidAPIKey},
+		{name: "secret of another key is rejected", key: "sha-bot.argon-s3cr3t", wantErr: errInvalidAPIKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator := newAPIKeyAuthenticator()
+
+			// Second call is answered from the verified keys, and must give the same result
+			for range 2 {
+				key, err := authenticator.authenticate(config, tt.key)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("authenticate error = %v, want %v", err, tt.wantErr)
+				}
+				if err == nil && key.Name != tt.wantName {
+					t.Errorf("authenticated key = %s, want %s", key.Name, tt.wantName)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuthenticatorBrokenHash(t *testing.T) {
+	config := api.JWTValidationAPIKeyConfig{
+		Enabled: true,
+		Keys: []api.JWTValidationAPIKey{
+			{Name: "broken-bot", Hash: "$sha256$salt$not-hex"},
+		},
+	}
+
+	// Config validation rejects such hashes, so they can only be met in configs built elsewhere: the key never matches
+	_, err := newAPIKeyAuthenticator().authenticate(config, "broken-bot.s3cr3t")
+	if !errors.Is(err, errInvalidAPIKey) || !strings.Contains(err.Error(), "key 'broken-bot' is skipped, as its hash is invalid") {
+		t.Fatalf("authenticate error = %v, want the key to be skipped", err)
+	}
+}
+
+func TestAPIKeyAuthenticatorHashChange(t *testing.T) {
+	config := newTestAPIKeyConfig(t)
+	authenticator := newAPIKeyAuthenticator()
+
+	if _, err := authenticator.authenticate(config, "sha-bot.s3cr3t"); err != nil {
+		t.Fatalf("authenticate error = %s, want none", err.Error())
+	}
+
+	// Rotated keys must not keep being accepted because they were verified before
+	config.Keys[0].Hash = newTestSHA256Hash("salt", "sha-bot.rotated")
+	if _, err := authenticator.authenticate(config, "sha-bot.s3cr3t"); !errors.Is(err, errInvalidAPIKey) {
+		t.Fatalf("authenticate error = %v, want %v", err, errInvalidAPIKey)
+	}
+}
+
+func TestJWTValidationMiddlewareAPIKeys(t *testing.T) {
+	_, jwk := newTestRSAKey(t, "first")
+	server := newTestJWKSServer(t, jwk)
+
+	tests := []struct {
+		name        string
+		strategy    string
+		key         string
+		wantStatus  int
+		wantSubject string
+		wantReason  string
+	}{
+		{
+			name:        "key is accepted with the subject of its claims",
+			strategy:    "local",
+			key:         "sha-bot.s3cr3t",
+			wantStatus:  http.StatusOK,
+			wantSubject: "sha-service",
+		},
+		{
+			name:        "subject defaults to the key name",
+			strategy:    "local",
+			key:         "argon-bot.s3cr3t",
+			wantStatus:  http.StatusOK,
+			wantSubject: "argon-bot",
+		},
+		{
+			name:       "wrong secret is rejected",
+			strategy:   "local",
+			key:        "sha-bot.wrong",
+			wantStatus: http.StatusUnauthorized,
+			wantReason: denialReasonInvalidAPIKey,
+		},
+		{
+			name:       "expired key is rejected",
+			strategy:   "local",
+			key:        "expired-bot.s3cr3t",
+			wantStatus: http.StatusUnauthorized,
+			wantReason: denialReasonExpired,
+		},
+		{
+			name:       "requests without key are rejected with strategy 'api_key'",
+			strategy:   "api_key",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "requests without key fall back to tokens with other strategies",
+			strategy:   "local",
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newTestJWTConfig(server.URL)
+			config.Middleware.JWT.Validation.Strategy = tt.strategy
+			config.Middleware.JWT.Validation.APIKey = newTestAPIKeyConfig(t)
+			mw, denialLog := newTestJWTValidationMiddleware(t, config)
+
+			req := newTestRequest("")
+			if tt.key != "" {
+				req.Header.Set(defaultAPIKeyHeader, tt.key)
+			}
+
+			recorder := serveTestRequest(mw, req)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+
+			if tt.wantStatus != http.StatusOK {
+				if got := denialLog.last(); got != tt.wantReason {
+					t.Errorf("denial reason = %s, want %s", got, tt.wantReason)
+				}
+				return
+			}
+
+			if got := recorder.Body.String(); got != tt.wantSubject {
+				t.Errorf("principal subject = %s, want %s", got, tt.wantSubject)
+			}
+
+			// Claims of the key are forwarded as the payload of a synthetic token
+			tokenParts := strings.Split(req.Header.Get("X-Validated-Jwt"), ".")
+			if len(tokenParts) != 3 {
+				t.Fatalf("forwarded header = %s, want the synthetic token", req.Header.Get("X-Validated-Jwt"))
+			}
+
+			var claims map[string]any
+			payload, _ := base64.RawURLEncoding.DecodeString(tokenParts[1])
+			if err := json.Unmarshal(payload, &claims); err != nil {
+				t.Fatalf("failed decoding synthetic token: %s", err.Error())
+			}
+			if claims["sub"] != tt.wantSubject {
+				t.Errorf("forwarded subject = %v, want %s", claims["sub"], tt.wantSubject)
+			}
+		})
+	}
+}
@@ -0,0 +1,319 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	//
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// dpopProofLifetime is the maximum time between the creation of a DPoP proof and its use.
+	// It is also the time its 'jti' is remembered to detect replays
+	dpopProofLifetime = 5 * time.Minute
+)
+
+var (
+	errInvalidDPoPProof = errors.New("invalid DPoP proof")
+
+	// defaultDPoPSigningAlgs are the algorithms accepted for DPoP proofs when none are advertised.
+	// Only asymmetric ones make sense, as the key travels with the proof
+	defaultDPoPSigningAlgs = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+)
+
+// dpopReplayCache remembers the 'jti' of the DPoP proofs already used, until they are too old to be accepted
+type dpopReplayCache struct {
+	mutex     sync.Mutex
+	seen      map[string]time.Time
+	lastPurge time.Time
+}
+
+func newDPoPReplayCache() *dpopReplayCache {
+	return &dpopReplayCache{
+		seen: map[string]time.Time{},
+	}
+}
+
+// remember records a proof, returning false when it was already used
+func (c *dpopReplayCache) remember(jti string, expiresAt time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if time.Since(c.lastPurge) > dpopProofLifetime {
+		c.lastPurge = time.Now()
+		for seenJti, seenExpiresAt := range c.seen {
+			if time.Now().After(seenExpiresAt) {
+				delete(c.seen, seenJti)
+			}
+		}
+	}
+
+	if seenExpiresAt, found := c.seen[jti]; found && time.Now().Before(seenExpiresAt) {
+		return false
+	}
+
+	c.seen[jti] = expiresAt
+	return true
+}
+
+// getAuthorizationToken splits the Authorization header into its scheme ('Bearer' or 'DPoP') and the token.
+// Headers without a known scheme are taken as bearer tokens
+func getAuthorizationToken(authHeader string) (scheme string, token string) {
+	scheme, token, found := strings.Cut(authHeader, " ")
+	switch {
+	case found && strings.EqualFold(scheme, "Bearer"):
+		return "Bearer", token
+	case found && strings.EqualFold(scheme, "DPoP"):
+		return "DPoP", token
+	default:
+		return "Bearer", authHeader
+	}
+}
+
+// checkDPoP verifies the sender of a valid token is its owner, when the token is bound to a DPoP key.
+// Bound tokens must come with the 'DPoP' scheme and a proof signed with that key.
+// When the resource requires it, tokens not bound to a key are rejected
+// Ref: https://datatracker.ietf.org/doc/html/rfc9449#section-7
+func (mw *JWTValidationMiddleware) checkDPoP(req *http.Request, scheme string, token string, tokenPayload map[string]any) error {
+	config := mw.dependencies.AppCtx.Config()
+	protectedResourceConfig := config.OAuthProtectedResource
+
+	var expectedThumbprint string
+	if confirmation, ok := tokenPayload["cnf"].(map[string]any); ok {
+		expectedThumbprint, _ = confirmation["jkt"].(string)
+	}
+
+	if expectedThumbprint == "" {
+		if scheme == "DPoP" || protectedResourceConfig.DPoPBoundAccessTokensRequired {
+			return fmt.Errorf("%w: token is not bound to a DPoP key", errInvalidDPoPProof)
+		}
+		return nil
+	}
+
+	if scheme != "DPoP" {
+		return fmt.Errorf("%w: DPoP bound token presented as a bearer token", errInvalidDPoPProof)
+	}
+
+	proofs := req.Header.Values("DPoP")
+	if len(proofs) != 1 {
+		return fmt.Errorf("%w: exactly one DPoP header is expected", errInvalidDPoPProof)
+	}
+
+	allowedAlgs := protectedResourceConfig.DPoPSigningAlgValuesSupported
+	if len(allowedAlgs) == 0 {
+		allowedAlgs = defaultDPoPSigningAlgs
+	}
+
+	targetUri := getRequestTargetUri(req, config.Server.Transport.HTTP.TrustForwardedHeaders)
+	thumbprint, jti, err := verifyDPoPProof(req, proofs[0], token, targetUri, allowedAlgs)
+	if err != nil {
+		return fmt.Errorf("%w: %s", errInvalidDPoPProof, err.Error())
+	}
+
+	if subtle.ConstantTimeCompare([]byte(thumbprint), []byte(expectedThumbprint)) != 1 {
+		return fmt.Errorf("%w: proof key does not match the token binding", errInvalidDPoPProof)
+	}
+
+	// Proofs are single use. They are remembered by key, as 'jti' is only unique for each one
+	if !mw.dpopReplays.remember(thumbprint+":"+jti, time.Now().Add(2*dpopProofLifetime)) {
+		return fmt.Errorf("%w: proof was already used", errInvalidDPoPProof)
+	}
+
+	return nil
+}
+
+// verifyDPoPProof checks the signature of a DPoP proof with the key it carries, and that it was created
+// for this request and this access token. It returns the thumbprint of the key and the 'jti' of the proof
+// Ref: https://datatracker.ietf.org/doc/html/rfc9449#section-4.3
+func verifyDPoPProof(req *http.Request, proof string, accessToken string, targetUri string, allowedAlgs []string) (string, string, error) {
+	header, err := parseJWTHeader(proof)
+	if err != nil {
+		return "", "", err
+	}
+
+	if typ, _ := header["typ"].(string); typ != "dpop+jwt" {
+		return "", "", fmt.Errorf("header 'typ' must be 'dpop+jwt'")
+	}
+
+	alg, _ := header["alg"].(string)
+	if !slices.Contains(allowedAlgs, alg) || strings.HasPrefix(alg, "HS") {
+		return "", "", fmt.Errorf("algorithm '%s' is not allowed", alg)
+	}
+
+	// The public key travels in the header. A private key there is a client mistake never to accept
+	jwkObject, ok := header["jwk"].(map[string]any)
+	if !ok {
+		return "", "", fmt.Errorf("header 'jwk' not found")
+	}
+	if _, found := jwkObject["d"]; found {
+		return "", "", fmt.Errorf("header 'jwk' contains a private key")
+	}
+
+	jwkBytes, err := json.Marshal(jwkObject)
+	if err != nil {
+		return "", "", fmt.Errorf("error encoding header 'jwk': %s", err.Error())
+	}
+
+	var jwk JWK
+	if err := json.Unmarshal(jwkBytes, &jwk); err != nil {
+		return "", "", fmt.Errorf("error decoding header 'jwk': %s", err.Error())
+	}
+
+	if jwk.Kty == "oct" {
+		return "", "", fmt.Errorf("header 'jwk' must be a public key")
+	}
+
+	publicKey, err := jwkParamsToKey(&jwk)
+	if err != nil {
+		return "", "", fmt.Errorf("error converting header 'jwk': %s", err.Error())
+	}
+
+	parsedProof, err := jwt.Parse(proof, func(token *jwt.Token) (interface{}, error) {
+		expectedMethod, localErr := getSigningMethod(alg)
+		if localErr != nil {
+			return nil, localErr
+		}
+
+		if token.Method != expectedMethod {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		return publicKey, nil
+	})
+	if err != nil || !parsedProof.Valid {
+		return "", "", fmt.Errorf("invalid signature: %v", err)
+	}
+
+	claims, ok := parsedProof.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", "", errMalformedToken
+	}
+
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return "", "", fmt.Errorf("claim 'jti' not found")
+	}
+
+	if htm, _ := claims["htm"].(string); htm != req.Method {
+		return "", "", fmt.Errorf("claim 'htm' does not match the request method")
+	}
+
+	htu, _ := claims["htu"].(string)
+	if !isSameTargetUri(htu, targetUri) {
+		return "", "", fmt.Errorf("claim 'htu' does not match the request URI")
+	}
+
+	issuedAt, err := claims.GetIssuedAt()
+	if err != nil || issuedAt == nil {
+		return "", "", fmt.Errorf("claim 'iat' not found")
+	}
+	if age := time.Since(issuedAt.Time); age > dpopProofLifetime || age < -dpopProofLifetime {
+		return "", "", fmt.Errorf("claim 'iat' is out of the accepted window")
+	}
+
+	// Proofs sent to resources are bound to the access token too
+	accessTokenHash := sha256.Sum256([]byte(accessToken))
+	expectedAth := base64.RawURLEncoding.EncodeToString(accessTokenHash[:])
+	if ath, _ := claims["ath"].(string); subtle.ConstantTimeCompare([]byte(ath), []byte(expectedAth)) != 1 {
+		return "", "", fmt.Errorf("claim 'ath' does not match the access token")
+	}
+
+	thumbprint, err := getJWKThumbprint(&jwk)
+	if err != nil {
+		return "", "", err
+	}
+
+	return thumbprint, jti, nil
+}
+
+// getJWKThumbprint returns the SHA-256 thumbprint of a public key, computed over its required members
+// Ref: https://datatracker.ietf.org/doc/html/rfc7638#section-3
+func getJWKThumbprint(jwk *JWK) (string, error) {
+	// Members must be in lexicographic order. Marshalling a map sorts them
+	var members map[string]string
+	switch jwk.Kty {
+	case "RSA":
+		members = map[string]string{"e": jwk.E, "kty": jwk.Kty, "n": jwk.N}
+	case "EC":
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X, "y": jwk.Y}
+	case "OKP":
+		members = map[string]string{"crv": jwk.Crv, "kty": jwk.Kty, "x": jwk.X}
+	default:
+		return "", fmt.Errorf("unsupported key type for thumbprint: %s", jwk.Kty)
+	}
+
+	membersBytes, err := json.Marshal(members)
+	if err != nil {
+		return "", fmt.Errorf("error encoding key members: %s", err.Error())
+	}
+
+	thumbprint := sha256.Sum256(membersBytes)
+	return base64.RawURLEncoding.EncodeToString(thumbprint[:]), nil
+}
+
+// getRequestTargetUri returns the URI the client sent the request to, without query nor fragment.
+// Scheme and host set by proxies in front of the server are only honoured when trusted,
+// as any client can send those headers when the server is reachable directly
+func getRequestTargetUri(req *http.Request, trustForwardedHeaders bool) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+
+	host := req.Host
+
+	if trustForwardedHeaders {
+		if forwardedProto := req.Header.Get("X-Forwarded-Proto"); forwardedProto != "" {
+			scheme = forwardedProto
+		}
+		if forwardedHost := req.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
+			host = forwardedHost
+		}
+	}
+
+	return scheme + "://" + host + req.URL.Path
+}
+
+// isSameTargetUri compares the 'htu' of a proof with the request URI, ignoring query and fragment,
+// the case of scheme and host, and default ports
+// Ref: https://datatracker.ietf.org/doc/html/rfc9449#section-4.3
+func isSameTargetUri(htu string, targetUri string) bool {
+	normalize := func(rawUri string) (string, bool) {
+		parsedUri, err := url.Parse(rawUri)
+		if err != nil || parsedUri.Host == "" {
+			return "", false
+		}
+
+		scheme := strings.ToLower(parsedUri.Scheme)
+		host := strings.ToLower(parsedUri.Hostname())
+		port := parsedUri.Port()
+		if (scheme == "https" && port == "443") || (scheme == "http" && port == "80") {
+			port = ""
+		}
+		if port != "" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		return scheme + "://" + host + parsedUri.EscapedPath(), true
+	}
+
+	normalizedHtu, ok := normalize(htu)
+	if !ok {
+		return false
+	}
+
+	normalizedTargetUri, ok := normalize(targetUri)
+	return ok && normalizedHtu == normalizedTargetUri
+}
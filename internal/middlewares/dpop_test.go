@@ -0,0 +1,290 @@
+package middlewares
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	//
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testDPoPKey represents the key pair of a client proving the possession of its tokens
+type testDPoPKey struct {
+	privateKey *ecdsa.PrivateKey
+	jwk        JWK
+}
+
+func newTestDPoPKey(t *testing.T) *testDPoPKey {
+	t.Helper()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed generating EC key: %s", err.Error())
+	}
+
+	return &testDPoPKey{
+		privateKey: privateKey,
+		jwk: JWK{
+			Kty: "EC",
+			Crv: "P-256",
+			X:   base64.RawURLEncoding.EncodeToString(privateKey.X.FillBytes(make([]byte, 32))),
+			Y:   base64.RawURLEncoding.EncodeToString(privateKey.Y.FillBytes(make([]byte, 32))),
+		},
+	}
+}
+
+// thumbprint returns the thumbprint of the public key, as bound in the 'cnf.jkt' claim of the tokens
+func (k *testDPoPKey) thumbprint(t *testing.T) string {
+	t.Helper()
+
+	thumbprint, err := getJWKThumbprint(&k.jwk)
+	if err != nil {
+		t.Fatalf("failed computing thumbprint: %s", err.Error())
+	}
+	return thumbprint
+}
+
+// proof returns a DPoP proof for a POST request to the test resource with the access token.
+// Claims and header can be changed before signing
+func (k *testDPoPKey) proof(t *testing.T, accessToken string, modify func(header map[string]any, claims jwt.MapClaims)) string {
+	t.Helper()
+
+	accessTokenHash := sha256.Sum256([]byte(accessToken))
+	claims := jwt.MapClaims{
+		"jti": rand.Text(),
+		"htm": http.MethodPost,
+		"htu": "https://mcp-go.example.com/mcp",
+		"iat": time.Now().Unix(),
+		"ath": base64.RawURLEncoding.EncodeToString(accessTokenHash[:]),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = map[string]any{"kty": k.jwk.Kty, "crv": k.jwk.Crv, "x": k.jwk.X, "y": k.jwk.Y}
+
+	if modify != nil {
+		modify(token.Header, claims)
+	}
+
+	proof, err := token.SignedString(k.privateKey)
+	if err != nil {
+		t.Fatalf("failed signing proof: %s", err.Error())
+	}
+	return proof
+}
+
+func TestJWTValidationMiddlewareDPoP(t *testing.T) {
+	key, jwk := newTestRSAKey(t, "first")
+	server := newTestJWKSServer(t, jwk)
+
+	clientKey := newTestDPoPKey(t)
+	otherKey := newTestDPoPKey(t)
+
+	tests := []struct {
+		name       string
+		unbound    bool
+		required   bool
+		scheme     string
+		proof      func(t *testing.T, accessToken string) string
+		wantStatus int
+		wantScheme string
+	}{
+		{
+			name:   "bound token with a valid proof is accepted",
+			scheme: "DPoP",
+			proof: func(t *testing.T, accessToken string) string {
+				return clientKey.proof(t, accessToken, nil)
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "bound token presented as a bearer token is rejected",
+			scheme: "Bearer",
+			proof: func(t *testing.T, accessToken string) string {
+				return clientKey.proof(t, accessToken, nil)
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantScheme: "DPoP",
+		},
+		{
+			name:       "bound token without proof is rejected",
+			scheme:     "DPoP",
+			wantStatus: http.StatusUnauthorized,
+			wantScheme: "DPoP",
+		},
+		{
+			name:   "proof signed by another key is rejected",
+			scheme: "DPoP",
+			proof: func(t *testing.T, accessToken string) string {
+				return otherKey.proof(t, accessToken, nil)
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantScheme: "DPoP",
+		},
+		{
+			name:   "proof for another method is rejected",
+			scheme: "DPoP",
+			proof: func(t *testing.T, accessToken string) string {
+				return clientKey.proof(t, accessToken, func(_ map[string]any, claims jwt.MapClaims) {
+					claims["htm"] = http.MethodGet
+				})
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantScheme: "DPoP",
+		},
+		{
+			name:   "proof for another URI is rejected",
+			scheme: "DPoP",
+			proof: func(t *testing.T, accessToken string) string {
+				return clientKey.proof(t, accessToken, func(_ map[string]any, claims jwt.MapClaims) {
+					claims["htu"] = "https://attacker.example.com/mcp"
+				})
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantScheme: "DPoP",
+		},
+		{
+			name:   "proof for the same URI with default port and query is accepted",
+			scheme: "DPoP",
+			proof: func(t *testing.T, accessToken string) string {
+				return clientKey.proof(t, accessToken, func(_ map[string]any, claims jwt.MapClaims) {
+					claims["htu"] = "https://MCP-GO.example.com:443/mcp?session=1"
+				})
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:   "stale proof is rejected",
+			scheme: "DPoP",
+			proof: func(t *testing.T, accessToken string) string {
+				return clientKey.proof(t, accessToken, func(_ map[string]any, claims jwt.MapClaims) {
+					claims["iat"] = time.Now().Add(-2 * dpopProofLifetime).Unix()
+				})
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantScheme: "DPoP",
+		},
+		{
+			name:   "proof for another access token is rejected",
+			scheme: "DPoP",
+			proof: func(t *testing.T, accessToken string) string {
+				return clientKey.proof(t, "another-token", nil)
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantScheme: "DPoP",
+		},
+		{
+			name:   "proof without the DPoP type is rejected",
+			scheme: "DPoP",
+			proof: func(t *testing.T, accessToken string) string {
+				return clientKey.proof(t, accessToken, func(header map[string]any, _ jwt.MapClaims) {
+					header["typ"] = "JWT"
+				})
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantScheme: "DPoP",
+		},
+		{
+			name:   "proof carrying a private key is rejected",
+			scheme: "DPoP",
+			proof: func(t *testing.T, accessToken string) string {
+				return clientKey.proof(t, accessToken, func(header map[string]any, _ jwt.MapClaims) {
+					header["jwk"].(map[string]any)["d"] = base64.RawURLEncoding.EncodeToString(clientKey.privateKey.D.Bytes())
+				})
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantScheme: "DPoP",
+		},
+		{
+			name:       "unbound bearer token is accepted when DPoP is not required",
+			unbound:    true,
+			scheme:     "Bearer",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:    "unbound token presented with the DPoP scheme is rejected",
+			unbound: true,
+			scheme:  "DPoP",
+			proof: func(t *testing.T, accessToken string) string {
+				return clientKey.proof(t, accessToken, nil)
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantScheme: "DPoP",
+		},
+		{
+			name:       "unbound bearer token is rejected when DPoP is required",
+			unbound:    true,
+			required:   true,
+			scheme:     "Bearer",
+			wantStatus: http.StatusUnauthorized,
+			wantScheme: "DPoP",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newTestJWTConfig(server.URL)
+			config.OAuthProtectedResource.DPoPBoundAccessTokensRequired = tt.required
+			mw, denialLog := newTestJWTValidationMiddleware(t, config)
+
+			claims := newTestClaims()
+			if !tt.unbound {
+				claims["cnf"] = map[string]any{"jkt": clientKey.thumbprint(t)}
+			}
+			accessToken := signTestToken(t, key, "first", claims)
+
+			req := newTestRequest("")
+			req.Header.Set("Authorization", tt.scheme+" "+accessToken)
+			if tt.proof != nil {
+				req.Header.Set("DPoP", tt.proof(t, accessToken))
+			}
+
+			recorder := serveTestRequest(mw, req)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK {
+				return
+			}
+
+			if got := denialLog.last(); got != denialReasonInvalidDPoPProof {
+				t.Errorf("denial reason = %s, want %s", got, denialReasonInvalidDPoPProof)
+			}
+
+			challenge := recorder.Header().Get("WWW-Authenticate")
+			if !strings.HasPrefix(challenge, tt.wantScheme+" ") || !strings.Contains(challenge, `algs="`) {
+				t.Errorf("challenge = %s, want the %s scheme with the accepted algorithms", challenge, tt.wantScheme)
+			}
+		})
+	}
+}
+
+func TestJWTValidationMiddlewareDPoPReplay(t *testing.T) {
+	key, jwk := newTestRSAKey(t, "first")
+	server := newTestJWKSServer(t, jwk)
+	clientKey := newTestDPoPKey(t)
+
+	mw, _ := newTestJWTValidationMiddleware(t, newTestJWTConfig(server.URL))
+
+	claims := newTestClaims()
+	claims["cnf"] = map[string]any{"jkt": clientKey.thumbprint(t)}
+	accessToken := signTestToken(t, key, "first", claims)
+	proof := clientKey.proof(t, accessToken, nil)
+
+	for i, wantStatus := range []int{http.StatusOK, http.StatusUnauthorized} {
+		req := newTestRequest("")
+		req.Header.Set("Authorization", "DPoP "+accessToken)
+		req.Header.Set("DPoP", proof)
+
+		if recorder := serveTestRequest(mw, req); recorder.Code != wantStatus {
+			t.Errorf("status of request %d = %d, want %d", i+1, recorder.Code, wantStatus)
+		}
+	}
+}
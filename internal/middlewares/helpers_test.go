@@ -0,0 +1,509 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	//
+	"mcp-go/api"
+	"mcp-go/internal/globals"
+
+	//
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	testIssuer   = "https://issuer.example.com"
+	testAudience = "mcp-go"
+)
+
+// testJWKSServer represents a remote publishing a JWKS, whose keys can be rotated during a test
+type testJWKSServer struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	keys     []JWK
+	status   int
+	delay    time.Duration
+	requests atomic.Int32
+}
+
+func newTestJWKSServer(t *testing.T, keys ...JWK) *testJWKSServer {
+	t.Helper()
+
+	s := &testJWKSServer{keys: keys, status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		s.requests.Add(1)
+
+		s.mutex.Lock()
+		keys, status, delay := s.keys, s.status, s.delay
+		s.mutex.Unlock()
+
+		time.Sleep(delay)
+
+		body, _ := json.Marshal(JWKS{Keys: keys})
+		bodyHash := sha256.Sum256(body)
+		etag := `"` + base64.RawURLEncoding.EncodeToString(bodyHash[:]) + `"`
+		if req.Header.Get("If-None-Match") == etag {
+			rw.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		rw.Header().Set("ETag", etag)
+		rw.WriteHeader(status)
+		_, _ = rw.Write(body)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// set replaces the keys and the status answered by the remote
+func (s *testJWKSServer) set(status int, keys ...JWK) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.status = status
+	s.keys = keys
+}
+
+// newTestRSAKey returns an RSA key pair, and its public part as a JWK
+func newTestRSAKey(t *testing.T, kid string) (*rsa.PrivateKey, JWK) {
+	t.Helper()
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed generating RSA key: %s", err.Error())
+	}
+
+	return privateKey, JWK{
+		Kid: kid,
+		Kty: "RSA",
+		Alg: "RS256",
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(privateKey.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(privateKey.E)).Bytes()),
+	}
+}
+
+// newTestECKey returns a P-256 key pair, and its public part as a JWK
+func newTestECKey(t *testing.T, kid string) (*ecdsa.PrivateKey, JWK) {
+	t.Helper()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed generating EC key: %s", err.Error())
+	}
+
+	// Uncompressed point: 0x04 | X | Y
+	ecdhKey, err := privateKey.PublicKey.ECDH()
+	if err != nil {
+		t.Fatalf("failed encoding EC key: %s", err.Error())
+	}
+	point := ecdhKey.Bytes()
+
+	return privateKey, JWK{
+		Kid: kid,
+		Kty: "EC",
+		Crv: "P-256",
+		Use: "sig",
+		X:   base64.RawURLEncoding.EncodeToString(point[1:33]),
+		Y:   base64.RawURLEncoding.EncodeToString(point[33:]),
+	}
+}
+
+// newTestEd25519Key returns an Ed25519 key pair, and its public part as a JWK
+func newTestEd25519Key(t *testing.T, kid string) (ed25519.PrivateKey, JWK) {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed generating Ed25519 key: %s", err.Error())
+	}
+
+	return privateKey, JWK{
+		Kid: kid,
+		Kty: "OKP",
+		Crv: "Ed25519",
+		Use: "sig",
+		X:   base64.RawURLEncoding.EncodeToString(publicKey),
+	}
+}
+
+// newTestCertificate returns a certificate for the public key, signed by the parent, or self-signed when there is none.
+// Certificates without parent are CAs
+func newTestCertificate(t *testing.T, name string, publicKey any, parent *x509.Certificate, parentKey any) *x509.Certificate {
+	t.Helper()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent = template
+	}
+
+	certificateBytes, err := x509.CreateCertificate(rand.Reader, template, parent, publicKey, parentKey)
+	if err != nil {
+		t.Fatalf("failed creating certificate: %s", err.Error())
+	}
+
+	certificate, err := x509.ParseCertificate(certificateBytes)
+	if err != nil {
+		t.Fatalf("failed parsing certificate: %s", err.Error())
+	}
+	return certificate
+}
+
+// testIssuerServer represents an issuer publishing its metadata on a well-known path.
+// Metadata can be changed during a test to move the JWKS
+type testIssuerServer struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	path     string
+	metadata map[string]any
+	delay    time.Duration
+}
+
+func newTestIssuerServer(t *testing.T, path string, metadata map[string]any) *testIssuerServer {
+	t.Helper()
+
+	s := &testIssuerServer{path: path, metadata: metadata}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		s.mutex.Lock()
+		path, metadata, delay := s.path, s.metadata, s.delay
+		s.mutex.Unlock()
+
+		time.Sleep(delay)
+
+		if req.URL.Path != path {
+			rw.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(metadata)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// setMetadata replaces the metadata published by the issuer
+func (s *testIssuerServer) setMetadata(metadata map[string]any) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.metadata = metadata
+}
+
+// testIntrospectionServer represents an introspection endpoint knowing a set of tokens.
+// Unknown tokens are answered as inactive
+type testIntrospectionServer struct {
+	*httptest.Server
+
+	responses map[string]map[string]any
+	status    int
+	delay     time.Duration
+	requests  atomic.Int32
+}
+
+func newTestIntrospectionServer(t *testing.T, responses map[string]map[string]any) *testIntrospectionServer {
+	t.Helper()
+
+	s := &testIntrospectionServer{responses: responses, status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		s.requests.Add(1)
+		time.Sleep(s.delay)
+
+		if err := req.ParseForm(); err != nil {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// Credentials are accepted in the header or in the form, as configured
+		clientID, clientSecret, ok := req.BasicAuth()
+		if !ok {
+			clientID, clientSecret = req.PostForm.Get("client_id"), req.PostForm.Get("client_secret")
+		}
+		if clientID != "mcp-server" || clientSecret != "s3cr3t" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if s.status != http.StatusOK {
+			rw.WriteHeader(s.status)
+			return
+		}
+
+		response, found := s.responses[req.PostForm.Get("token")]
+		if !found {
+			response = map[string]any{"active": false}
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(response)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// newTestIntrospectionConfig returns the settings to authenticate against newTestIntrospectionServer
+func newTestIntrospectionConfig(endpoint string) api.JWTValidationIntrospectionConfig {
+	return api.JWTValidationIntrospectionConfig{
+		Endpoint:     endpoint,
+		ClientID:     "mcp-server",
+		ClientSecret: "s3cr3t",
+		CacheTTL:     time.Minute,
+	}
+}
+
+// newTestSHA256Hash returns the hash of a key in the '$sha256$<salt>$<hex hash>' format
+func newTestSHA256Hash(salt string, key string) string {
+	keyHash := sha256.Sum256([]byte(salt + key))
+	return "$sha256$" + salt + "$" + hex.EncodeToString(keyHash[:])
+}
+
+// newTestArgon2idHash returns the hash of a key in PHC format, with cheap parameters to keep tests fast
+func newTestArgon2idHash(salt string, key string) string {
+	keyHash := argon2.IDKey([]byte(key), []byte(salt), 1, 1024, 1, 32)
+	return fmt.Sprintf("$argon2id$v=%d$m=1024,t=1,p=1$%s$%s", argon2.Version,
+		base64.RawStdEncoding.EncodeToString([]byte(salt)), base64.RawStdEncoding.EncodeToString(keyHash))
+}
+
+// newTestBcryptHash returns the hash of a key with the minimum cost, to keep tests fast
+func newTestBcryptHash(t *testing.T, key string) string {
+	t.Helper()
+
+	keyHash, err := bcrypt.GenerateFromPassword([]byte(key), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed hashing key: %s", err.Error())
+	}
+	return string(keyHash)
+}
+
+// newTestAPIKeyConfig returns keys hashed in every supported format, and an expired one
+func newTestAPIKeyConfig(t *testing.T) api.JWTValidationAPIKeyConfig {
+	return api.JWTValidationAPIKeyConfig{
+		Enabled: true,
+		Keys: []api.JWTValidationAPIKey{
+			{Name: "sha-bot", Hash: newTestSHA256Hash("salt", "sha-bot.s3cr3t"), Subject: "sha-service"},
+			{Name: "argon-bot", Hash: newTestArgon2idHash("saltsalt", "argon-bot.s3cr3t")},
+			{Name: "bcrypt-bot", Hash: newTestBcryptHash(t, "bcrypt-bot.s3cr3t")},
+			{Name: "expired-bot", Hash: newTestSHA256Hash("salt", "expired-bot.s3cr3t"), ExpiresAt: time.Now().Add(-time.Hour)},
+		},
+	}
+}
+
+// newTestJWTConfig returns a config validating tokens locally with the keys of the JWKS URI
+func newTestJWTConfig(jwksUri string) *api.Configuration {
+	return &api.Configuration{
+		Middleware: api.MiddlewareConfig{
+			JWT: api.JWTConfig{
+				Enabled: true,
+				Validation: api.JWTValidationConfig{
+					Strategy:        "local",
+					ForwardedHeader: "X-Validated-Jwt",
+					Local: api.JWTValidationLocalConfig{
+						JWKSUri:            jwksUri,
+						CacheInterval:      time.Hour,
+						MinRefreshInterval: time.Millisecond,
+						StartupTimeout:     5 * time.Second,
+						Issuers:            []string{testIssuer},
+						Audiences:          []string{testAudience},
+					},
+				},
+			},
+		},
+	}
+}
+
+// testDenialLog represents a logger handler recording the reasons of the denials logged with their details
+type testDenialLog struct {
+	mutex   sync.Mutex
+	reasons []string
+}
+
+func (l *testDenialLog) Enabled(context.Context, slog.Level) bool { return true }
+func (l *testDenialLog) WithAttrs([]slog.Attr) slog.Handler       { return l }
+func (l *testDenialLog) WithGroup(string) slog.Handler            { return l }
+
+func (l *testDenialLog) Handle(_ context.Context, record slog.Record) error {
+	record.Attrs(func(attr slog.Attr) bool {
+		if attr.Key == "reason" {
+			l.mutex.Lock()
+			l.reasons = append(l.reasons, attr.Value.String())
+			l.mutex.Unlock()
+		}
+		return true
+	})
+	return nil
+}
+
+// last returns the reason of the last denial, or an empty string when none was logged
+func (l *testDenialLog) last() string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if len(l.reasons) == 0 {
+		return ""
+	}
+	return l.reasons[len(l.reasons)-1]
+}
+
+// newTestJWTValidationMiddleware returns the middleware for a config, and the log of its denials.
+// It is stopped when the test ends
+func newTestJWTValidationMiddleware(t *testing.T, config *api.Configuration) (*JWTValidationMiddleware, *testDenialLog) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	denialLog := &testDenialLog{}
+	appCtx := globals.NewApplicationContextFromConfig(ctx, slog.New(denialLog), config)
+
+	mw, err := NewJWTValidationMiddleware(JWTValidationMiddlewareDependencies{AppCtx: appCtx})
+	if err != nil {
+		t.Fatalf("failed creating middleware: %s", err.Error())
+	}
+	return mw, denialLog
+}
+
+// newTestToolAuthorizationMiddleware returns the tool authorization middleware for a config
+func newTestToolAuthorizationMiddleware(t *testing.T, config *api.Configuration) *ToolAuthorizationMiddleware {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	appCtx := globals.NewApplicationContextFromConfig(ctx, slog.New(slog.DiscardHandler), config)
+
+	mw, err := NewToolAuthorizationMiddleware(ToolAuthorizationMiddlewareDependencies{AppCtx: appCtx})
+	if err != nil {
+		t.Fatalf("failed creating middleware: %s", err.Error())
+	}
+	return mw
+}
+
+// newTestToolScopesConfig returns a config requiring scopes to call 'hello' and 'delete', served over HTTP
+func newTestToolScopesConfig() *api.Configuration {
+	config := &api.Configuration{}
+	config.Server.Transport.Type = "http"
+	config.Middleware.ToolAuthorization.ToolScopes = []api.ToolScopesConfig{
+		{Tool: "hello", Scopes: []string{"mcp:read"}},
+		{Tool: "delete", Scopes: []string{"mcp:read", "mcp:admin"}},
+	}
+	return config
+}
+
+// newTestPrincipalContext returns a context carrying a principal with the given claims, when any
+func newTestPrincipalContext(claims map[string]any) context.Context {
+	if claims == nil {
+		return context.Background()
+	}
+	return WithPrincipal(context.Background(), newPrincipal(claims, AuthMethodJWT))
+}
+
+// serveTestRequest sends the request through the middleware. Accepted requests are answered
+// with the subject of the principal
+func serveTestRequest(mw *JWTValidationMiddleware, req *http.Request) *httptest.ResponseRecorder {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		principal, _ := PrincipalFromContext(req.Context())
+		if principal != nil {
+			_, _ = io.WriteString(rw, principal.Subject)
+		}
+	})
+
+	recorder := httptest.NewRecorder()
+	mw.Middleware(next).ServeHTTP(recorder, req)
+	return recorder
+}
+
+// newTestRequest returns a request to the MCP endpoint carrying the token, when given
+func newTestRequest(token string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "https://mcp-go.example.com/mcp", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req
+}
+
+// newTestMCPRequest returns a request to the MCP endpoint carrying the token and the JSON-RPC body
+func newTestMCPRequest(token string, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "https://mcp-go.example.com/mcp", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+// newTestClaims returns the claims of a token accepted by newTestJWTConfig
+func newTestClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss": testIssuer,
+		"aud": testAudience,
+		"sub": "alice",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	}
+}
+
+// signTestToken returns a token with the claims, signed with RS256 by the key
+func signTestToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+	return signTestTokenWithMethod(t, jwt.SigningMethodRS256, key, kid, claims)
+}
+
+// signTestTokenWithMethod returns a token with the claims, signed by the key with the given method
+func signTestTokenWithMethod(t *testing.T, method jwt.SigningMethod, key any, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+
+	signedToken, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("failed signing token: %s", err.Error())
+	}
+	return signedToken
+}
+
+// getDenialError returns the error code in the JSON body of a rejected request
+func getDenialError(t *testing.T, recorder *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var body DenialResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed decoding denial body '%s': %s", recorder.Body.String(), err.Error())
+	}
+	return body.Error
+}
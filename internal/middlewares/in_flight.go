@@ -0,0 +1,68 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	//
+	"mcp-go/internal/globals"
+
+	//
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+type InFlightMiddlewareDependencies struct {
+	AppCtx *globals.ApplicationContext
+}
+
+// InFlightMiddleware keeps track of the tool calls being executed,
+// so the shutdown can wait for them before releasing resources
+type InFlightMiddleware struct {
+	dependencies InFlightMiddlewareDependencies
+
+	// Carried stuff
+	waitGroup sync.WaitGroup
+}
+
+func NewInFlightMiddleware(deps InFlightMiddlewareDependencies) *InFlightMiddleware {
+
+	mw := &InFlightMiddleware{
+		dependencies: deps,
+	}
+
+	mw.dependencies.AppCtx.RegisterStopHook(globals.StopPhaseDrain, "in-flight tool calls", mw.wait)
+
+	return mw
+}
+
+func (mw *InFlightMiddleware) Name() string {
+	return "in_flight"
+}
+
+func (mw *InFlightMiddleware) Middleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		mw.waitGroup.Add(1)
+		defer mw.waitGroup.Done()
+
+		return next(ctx, request)
+	}
+}
+
+// wait blocks until all the in-flight tool calls are finished, or the context is done
+func (mw *InFlightMiddleware) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		mw.waitGroup.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("in-flight tool calls not finished: %s", ctx.Err().Error())
+	}
+}
@@ -8,6 +8,8 @@ import (
 )
 
 type ToolMiddleware interface {
+	// Name identifies the middleware in the 'middleware.tools' configuration
+	Name() string
 	Middleware(next server.ToolHandlerFunc) server.ToolHandlerFunc
 }
 
@@ -0,0 +1,129 @@
+package middlewares
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"os"
+)
+
+// getJWKCertificates decodes the certificate chain of a JWK. Certificates are DER encoded in standard base64.
+// The thumbprint of the first one is checked when present
+// Ref: https://datatracker.ietf.org/doc/html/rfc7517#section-4.7
+func getJWKCertificates(jwk *JWK) ([]*x509.Certificate, error) {
+	if len(jwk.X5c) == 0 {
+		return nil, fmt.Errorf("JWK has no certificate chain")
+	}
+
+	var certificates []*x509.Certificate
+	for i, encodedCertificate := range jwk.X5c {
+		certificateBytes, err := base64.StdEncoding.DecodeString(encodedCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding certificate %d of the chain: %s", i, err.Error())
+		}
+
+		certificate, err := x509.ParseCertificate(certificateBytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing certificate %d of the chain: %s", i, err.Error())
+		}
+
+		if i == 0 && jwk.X5tS256 != "" {
+			certificateHash := sha256.Sum256(certificateBytes)
+			thumbprint := base64.RawURLEncoding.EncodeToString(certificateHash[:])
+			if subtle.ConstantTimeCompare([]byte(thumbprint), []byte(jwk.X5tS256)) != 1 {
+				return nil, fmt.Errorf("certificate does not match the 'x5t#S256' thumbprint")
+			}
+		}
+
+		certificates = append(certificates, certificate)
+	}
+
+	return certificates, nil
+}
+
+// jwkCertificateToKey returns the key of the first certificate of the chain of a JWK.
+// When the JWK carries the params of the key too, they must describe the same key
+func jwkCertificateToKey(jwk *JWK) (interface{}, error) {
+	certificates, err := getJWKCertificates(jwk)
+	if err != nil {
+		return nil, err
+	}
+
+	var publicKey interface {
+		Equal(x crypto.PublicKey) bool
+	}
+	switch key := certificates[0].PublicKey.(type) {
+	case *rsa.PublicKey:
+		if jwk.Kty == "RSA" {
+			publicKey = key
+		}
+	case *ecdsa.PublicKey:
+		if jwk.Kty == "EC" {
+			publicKey = key
+		}
+	case ed25519.PublicKey:
+		if jwk.Kty == "OKP" {
+			publicKey = key
+		}
+	}
+	if publicKey == nil {
+		return nil, fmt.Errorf("certificate key does not match the key type '%s'", jwk.Kty)
+	}
+
+	if jwk.N != "" || jwk.X != "" {
+		paramsKey, err := jwkParamsToKey(jwk)
+		if err != nil {
+			return nil, err
+		}
+
+		if !publicKey.Equal(paramsKey) {
+			return nil, fmt.Errorf("certificate key does not match the key params")
+		}
+	}
+
+	return publicKey, nil
+}
+
+// verifyJWKCertificateChain checks the certificate chain of a JWK leads to one of the trusted roots
+func verifyJWKCertificateChain(jwk *JWK, roots *x509.CertPool) error {
+	certificates, err := getJWKCertificates(jwk)
+	if err != nil {
+		return err
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+
+	_, err = certificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("error verifying certificate chain: %s", err.Error())
+	}
+
+	return nil
+}
+
+// loadCertPool reads a bundle of PEM certificates
+func loadCertPool(filePath string) (*x509.CertPool, error) {
+	bundleBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading CA bundle: %s", err.Error())
+	}
+
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(bundleBytes) {
+		return nil, fmt.Errorf("CA bundle does not contain valid PEM certificates")
+	}
+	return certPool, nil
+}
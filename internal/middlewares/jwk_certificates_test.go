@@ -0,0 +1,213 @@
+package middlewares
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestCertificateChain returns a leaf certificate for the RSA key, signed by a new CA, and the CA
+func newTestCertificateChain(t *testing.T, key *rsa.PrivateKey) (*x509.Certificate, *x509.Certificate) {
+	t.Helper()
+
+	caKey, _ := newTestECKey(t, "ca")
+	ca := newTestCertificate(t, "Test CA", &caKey.PublicKey, nil, caKey)
+	return newTestCertificate(t, "Test signer", &key.PublicKey, ca, caKey), ca
+}
+
+// encodeTestCertificates returns the certificates in the 'x5c' format of a JWK
+func encodeTestCertificates(certificates ...*x509.Certificate) []string {
+	var encodedCertificates []string
+	for _, certificate := range certificates {
+		encodedCertificates = append(encodedCertificates, base64.StdEncoding.EncodeToString(certificate.Raw))
+	}
+	return encodedCertificates
+}
+
+// writeTestCABundle writes the certificates as a PEM bundle, returning its path
+func writeTestCABundle(t *testing.T, certificates ...*x509.Certificate) string {
+	t.Helper()
+
+	var bundle []byte
+	for _, certificate := range certificates {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})...)
+	}
+
+	filePath := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(filePath, bundle, 0o600); err != nil {
+		t.Fatalf("failed writing CA bundle: %s", err.Error())
+	}
+	return filePath
+}
+
+func TestJWKToKeyCertificates(t *testing.T) {
+	key, paramsJWK := newTestRSAKey(t, "first")
+	_, otherParamsJWK := newTestRSAKey(t, "first")
+	leaf, ca := newTestCertificateChain(t, key)
+
+	leafHash := sha256.Sum256(leaf.Raw)
+	thumbprint := base64.RawURLEncoding.EncodeToString(leafHash[:])
+
+	tests := []struct {
+		name    string
+		jwk     JWK
+		wantErr string
+	}{
+		{
+			name: "key is taken from the first certificate",
+			jwk:  JWK{Kty: "RSA", X5c: encodeTestCertificates(leaf, ca)},
+		},
+		{
+			name: "certificate with a matching thumbprint",
+			jwk:  JWK{Kty: "RSA", X5c: encodeTestCertificates(leaf), X5tS256: thumbprint},
+		},
+		{
+			name: "certificate with the same key as the params",
+			jwk:  JWK{Kty: "RSA", N: paramsJWK.N, E: paramsJWK.E, X5c: encodeTestCertificates(leaf)},
+		},
+		{
+			name:    "certificate not matching the thumbprint",
+			jwk:     JWK{Kty: "RSA", X5c: encodeTestCertificates(leaf), X5tS256: base64.RawURLEncoding.EncodeToString(make([]byte, 32))},
+			wantErr: "certificate does not match the 'x5t#S256' thumbprint",
+		},
+		{
+			name:    "certificate with another key than the params",
+			jwk:     JWK{Kty: "RSA", N: otherParamsJWK.N, E: otherParamsJWK.E, X5c: encodeTestCertificates(leaf)},
+			wantErr: "certificate key does not match the key params",
+		},
+		{
+			name:    "certificate with another key type",
+			jwk:     JWK{Kty: "EC", X5c: encodeTestCertificates(leaf)},
+			wantErr: "certificate key does not match the key type 'EC'",
+		},
+		{
+			name:    "certificate not encoded in standard base64",
+			jwk:     JWK{Kty: "RSA", X5c: []string{"not*base64"}},
+			wantErr: "error decoding certificate 0 of the chain",
+		},
+		{
+			name:    "malformed certificate",
+			jwk:     JWK{Kty: "RSA", X5c: []string{base64.StdEncoding.EncodeToString([]byte("certificate"))}},
+			wantErr: "error parsing certificate 0 of the chain",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publicKey, err := jwkToKey(&tt.jwk)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing '%s'", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if rsaKey, ok := publicKey.(*rsa.PublicKey); !ok || !rsaKey.Equal(&key.PublicKey) {
+				t.Errorf("key = %v, want the one of the certificate", publicKey)
+			}
+		})
+	}
+}
+
+func TestJWKToKeyOKP(t *testing.T) {
+	edKey, jwk := newTestEd25519Key(t, "okp")
+
+	publicKey, err := jwkToKey(&jwk)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if edPublicKey, ok := publicKey.(ed25519.PublicKey); !ok || !edPublicKey.Equal(edKey.Public()) {
+		t.Errorf("key = %v, want the Ed25519 public key", publicKey)
+	}
+
+	jwk.Crv = "X25519"
+	if _, err := jwkToKey(&jwk); err == nil || err.Error() != "unsupported curve: X25519" {
+		t.Errorf("error = %v, want an unsupported curve", err)
+	}
+
+	jwk.Crv = "Ed25519"
+	jwk.X = base64.RawURLEncoding.EncodeToString([]byte("short"))
+	if _, err := jwkToKey(&jwk); err == nil || err.Error() != "invalid Ed25519 public key size: 5" {
+		t.Errorf("error = %v, want an invalid key size", err)
+	}
+}
+
+func TestJWTValidationMiddlewareX5CCAFile(t *testing.T) {
+	trustedKey, trustedJWK := newTestRSAKey(t, "trusted")
+	trustedLeaf, trustedCA := newTestCertificateChain(t, trustedKey)
+	trustedJWK.X5c = encodeTestCertificates(trustedLeaf)
+
+	untrustedKey, untrustedJWK := newTestRSAKey(t, "untrusted")
+	untrustedLeaf, untrustedCA := newTestCertificateChain(t, untrustedKey)
+	untrustedJWK.X5c = encodeTestCertificates(untrustedLeaf, untrustedCA)
+
+	_, plainJWK := newTestRSAKey(t, "plain")
+
+	server := newTestJWKSServer(t, trustedJWK, untrustedJWK, plainJWK)
+
+	tests := []struct {
+		name       string
+		caFile     string
+		key        *rsa.PrivateKey
+		kid        string
+		wantStatus int
+		wantReason string
+	}{
+		{
+			name:       "key with a chain leading to the CA is accepted",
+			caFile:     writeTestCABundle(t, trustedCA),
+			key:        trustedKey,
+			kid:        "trusted",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "key with a chain leading to another CA is ignored",
+			caFile:     writeTestCABundle(t, trustedCA),
+			key:        untrustedKey,
+			kid:        "untrusted",
+			wantStatus: http.StatusUnauthorized,
+			wantReason: denialReasonUnknownKid,
+		},
+		{
+			name:       "chains are not verified without CA",
+			key:        untrustedKey,
+			kid:        "untrusted",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "tokens can not be validated while the CA bundle can not be read",
+			caFile:     filepath.Join(t.TempDir(), "missing.pem"),
+			key:        trustedKey,
+			kid:        "trusted",
+			wantStatus: http.StatusServiceUnavailable,
+			wantReason: denialReasonJWKSUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newTestJWTConfig(server.URL)
+			config.Middleware.JWT.Validation.Local.X5CCAFile = tt.caFile
+			mw, denialLog := newTestJWTValidationMiddleware(t, config)
+
+			recorder := serveTestRequest(mw, newTestRequest(signTestToken(t, tt.key, tt.kid, newTestClaims())))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if got := denialLog.last(); got != tt.wantReason {
+				t.Errorf("denial reason = %s, want %s", got, tt.wantReason)
+			}
+		})
+	}
+}
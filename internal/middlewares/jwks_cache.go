@@ -0,0 +1,567 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	//
+	"mcp-go/api"
+	"mcp-go/internal/metrics"
+)
+
+const (
+	// defaultJWKSStartupTimeout is the time given to the first load of the JWKS
+	defaultJWKSStartupTimeout = 10 * time.Second
+
+	// defaultJWKSMinRefreshInterval is the minimum time between two refreshes of the JWKS,
+	// protecting the remote from tokens carrying random 'kid' values
+	defaultJWKSMinRefreshInterval = 30 * time.Second
+
+	// jwksInitialBackoff is the time to wait before retrying a failed refresh. It doubles on each failure
+	jwksInitialBackoff = 1 * time.Second
+
+	// jwksRequestTimeout is the time given to each request to the remote
+	jwksRequestTimeout = 10 * time.Second
+
+	// jwksMaxBodySize is the maximum size of the JWKS accepted from the remote
+	jwksMaxBodySize = 1 << 20
+
+	// jwksFileReloadInterval is the time between checks for changes in the JWKS file
+	jwksFileReloadInterval = 10 * time.Second
+)
+
+var (
+	errJWKSNotLoaded   = errors.New("JWKS not loaded yet")
+	errJWKSUnavailable = errors.New("JWKS can not be refreshed")
+)
+
+// jwksKey represents a key from the JWKS, already converted into its real type (RSA, EC, etc.)
+type jwksKey struct {
+	jwk       JWK
+	publicKey interface{}
+}
+
+// jwksFetch represents a request to the remote in progress. Everyone needing fresh keys meanwhile waits for it,
+// instead of requesting them again
+type jwksFetch struct {
+	done        chan struct{}
+	nextRefresh time.Duration
+	err         error
+}
+
+// jwksFetchResult represents the outcome of a successful request to the remote
+type jwksFetchResult struct {
+	keys         map[string]*jwksKey
+	etag         string
+	lastModified string
+	notModified  bool
+	nextRefresh  time.Duration
+}
+
+// jwksCacheConfig represents the settings of a JWKS cache. Caches are replaced when they change
+type jwksCacheConfig struct {
+	uri                string
+	file               string
+	refreshInterval    time.Duration
+	minRefreshInterval time.Duration
+	startupTimeout     time.Duration
+	allowSymmetricKeys bool
+	x5cCAFile          string
+}
+
+// jwksCache keeps the keys published in a remote JWKS, or written in a local file, up-to-date.
+// When both are configured, their keys are merged. The last good set of keys is served while a source is failing
+type jwksCache struct {
+	config jwksCacheConfig
+	logger *slog.Logger
+	client *http.Client
+
+	// Keys indexed by 'kid', from every source. They are parsed once per refresh
+	keys atomic.Pointer[map[string]*jwksKey]
+
+	// Refresh stuff. The mutex is never held while requesting the remote
+	refreshMutex sync.Mutex
+	fetching     *jwksFetch
+	remoteKeys   map[string]*jwksKey
+	fileKeys     map[string]*jwksKey
+	fileHash     []byte
+	lastRefresh  time.Time
+	nextRefresh  time.Duration
+	etag         string
+	lastModified string
+	cancel       context.CancelFunc
+}
+
+// getJWKSCacheConfig returns the JWKS cache settings from the local JWT validation config, with defaults applied
+func getJWKSCacheConfig(localConfig api.JWTValidationLocalConfig) jwksCacheConfig {
+	cacheConfig := jwksCacheConfig{
+		uri:                localConfig.JWKSUri,
+		refreshInterval:    localConfig.CacheInterval,
+		minRefreshInterval: localConfig.MinRefreshInterval,
+		startupTimeout:     localConfig.StartupTimeout,
+	}
+
+	if cacheConfig.minRefreshInterval <= 0 {
+		cacheConfig.minRefreshInterval = defaultJWKSMinRefreshInterval
+	}
+
+	if cacheConfig.startupTimeout <= 0 {
+		cacheConfig.startupTimeout = defaultJWKSStartupTimeout
+	}
+
+	return cacheConfig
+}
+
+func newJWKSCache(logger *slog.Logger, config jwksCacheConfig) *jwksCache {
+	return &jwksCache{
+		config: config,
+		logger: logger,
+		client: &http.Client{Timeout: jwksRequestTimeout},
+	}
+}
+
+// load fetches the keys for the first time, waiting up to the startup timeout.
+// On failure, keys keep being requested in background once the cache is started
+func (c *jwksCache) load(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.startupTimeout)
+	defer cancel()
+
+	_, err := c.refresh(ctx)
+	return err
+}
+
+// start keeps the keys refreshed in background until the context is done or the cache is stopped.
+// Starting a running cache does nothing, so caches can be shared between issuers and configs.
+// A stopped cache can be started again, as a config prepared meanwhile may still use it
+func (c *jwksCache) start(ctx context.Context) {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+
+	if c.cancel != nil {
+		return
+	}
+	ctx, c.cancel = context.WithCancel(ctx)
+
+	if c.config.uri != "" {
+		wait := c.nextRefresh
+		if c.remoteKeys == nil {
+			wait = jwksInitialBackoff
+		}
+		go c.run(ctx, wait)
+	}
+
+	if c.config.file != "" {
+		go c.watchFile(ctx)
+	}
+}
+
+// stop finishes the background refresh
+func (c *jwksCache) stop() {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+
+	if c.cancel != nil {
+		c.cancel()
+		c.cancel = nil
+	}
+}
+
+// run refreshes the keys when the remote says they expire, backing off exponentially on errors
+func (c *jwksCache) run(ctx context.Context, wait time.Duration) {
+	c.logger.Info("JWKS cache daemon running for JWT auth middleware", "uri", c.config.uri)
+
+	backoff := jwksInitialBackoff
+	for {
+		select {
+		case <-ctx.Done():
+			c.logger.Info("JWKS cache daemon stopped", "uri", c.config.uri)
+			return
+		case <-time.After(wait):
+		}
+
+		nextRefresh, err := c.refreshRemote(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				continue
+			}
+
+			c.logger.Error("failed refreshing JWKS, keeping last good keys",
+				"uri", c.config.uri, "retry_in", backoff.String(), "error", err.Error())
+
+			wait = backoff
+			backoff = min(backoff*2, max(c.config.refreshInterval, jwksInitialBackoff))
+			continue
+		}
+
+		backoff = jwksInitialBackoff
+		wait = nextRefresh
+	}
+}
+
+// watchFile reads the JWKS file from time to time, so keys written there are picked up without restarting
+func (c *jwksCache) watchFile(ctx context.Context) {
+	c.logger.Info("JWKS file watcher running for JWT auth middleware", "file", c.config.file)
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.logger.Info("JWKS file watcher stopped", "file", c.config.file)
+			return
+		case <-time.After(jwksFileReloadInterval):
+		}
+
+		c.refreshMutex.Lock()
+		err := c.readFile()
+		c.mergeKeys()
+		c.refreshMutex.Unlock()
+
+		if err != nil {
+			c.logger.Error("failed reading JWKS file, keeping last good keys", "file", c.config.file, "error", err.Error())
+		}
+	}
+}
+
+// lookup returns the key matching the 'kid'. When it is unknown, the keys are requested again,
+// as they may have been rotated. Those refreshes are rate-limited to protect the remote
+func (c *jwksCache) lookup(ctx context.Context, kid string) (*jwksKey, error) {
+	if key := c.find(kid); key != nil {
+		return key, nil
+	}
+
+	refreshErr := c.refreshForKid(ctx, kid)
+	if refreshErr != nil {
+		c.logger.Error("failed refreshing JWKS for unknown kid", "uri", c.config.uri, "file", c.config.file, "error", refreshErr.Error())
+	}
+
+	if key := c.find(kid); key != nil {
+		return key, nil
+	}
+
+	// The 'kid' can only be told unknown when the keys are up to date
+	switch {
+	case c.keys.Load() == nil:
+		return nil, errJWKSNotLoaded
+	case refreshErr != nil:
+		return nil, fmt.Errorf("%w: %s", errJWKSUnavailable, refreshErr.Error())
+	}
+	return nil, errUnknownKid
+}
+
+// refreshForKid loads the keys again looking for an unknown 'kid', unless they were refreshed recently.
+// Checks are done under the mutex, so concurrent callers share the same request to the remote
+func (c *jwksCache) refreshForKid(ctx context.Context, kid string) error {
+	c.refreshMutex.Lock()
+
+	// The key may have been loaded while waiting for the mutex
+	if c.find(kid) != nil {
+		c.refreshMutex.Unlock()
+		return nil
+	}
+
+	var errs []error
+	fetch := c.fetching
+	if fetch == nil && time.Since(c.lastRefresh) >= c.config.minRefreshInterval {
+		c.lastRefresh = time.Now()
+
+		if c.config.file != "" {
+			errs = append(errs, c.readFile())
+			c.mergeKeys()
+		}
+
+		if c.config.uri != "" {
+			fetch = c.startFetch(ctx)
+		}
+	}
+	c.refreshMutex.Unlock()
+
+	if fetch != nil {
+		_, err := waitFetch(ctx, fetch)
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// find returns the key matching the 'kid' from the current keys, or nil
+func (c *jwksCache) find(kid string) *jwksKey {
+	keys := c.keys.Load()
+	if keys == nil {
+		return nil
+	}
+	return (*keys)[kid]
+}
+
+// loaded returns an error when the keys were never loaded
+func (c *jwksCache) loaded() error {
+	if c.keys.Load() == nil {
+		return errJWKSNotLoaded
+	}
+	return nil
+}
+
+// refresh loads the keys again from every source, returning the time until the next refresh of the remote
+func (c *jwksCache) refresh(ctx context.Context) (time.Duration, error) {
+	var nextRefresh time.Duration
+	var errs []error
+	if c.config.uri != "" {
+		var err error
+		nextRefresh, err = c.refreshRemote(ctx)
+		errs = append(errs, err)
+	}
+
+	if c.config.file != "" {
+		c.refreshMutex.Lock()
+		errs = append(errs, c.readFile())
+		c.mergeKeys()
+		c.refreshMutex.Unlock()
+	}
+
+	return nextRefresh, errors.Join(errs...)
+}
+
+// refreshRemote requests the keys to the remote, returning the time until the next refresh.
+// When a request is already in progress, its outcome is awaited instead
+func (c *jwksCache) refreshRemote(ctx context.Context) (time.Duration, error) {
+	c.refreshMutex.Lock()
+	fetch := c.fetching
+	if fetch == nil {
+		c.lastRefresh = time.Now()
+		fetch = c.startFetch(ctx)
+	}
+	c.refreshMutex.Unlock()
+
+	return waitFetch(ctx, fetch)
+}
+
+// startFetch requests the keys to the remote in background, publishing them when they arrive.
+// It must be called with the refresh mutex held. The request outlives the context of whoever started it,
+// as others may be waiting for it too, so it is only bounded by the request timeout
+func (c *jwksCache) startFetch(ctx context.Context) *jwksFetch {
+	fetch := &jwksFetch{done: make(chan struct{})}
+	c.fetching = fetch
+
+	// Conditional requests are used, so unchanged keys are not downloaded nor parsed again
+	var etag, lastModified string
+	if c.remoteKeys != nil {
+		etag, lastModified = c.etag, c.lastModified
+	}
+
+	go func() {
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jwksRequestTimeout)
+		result, err := c.fetch(fetchCtx, etag, lastModified)
+		cancel()
+		metrics.ObserveJWKSRefresh(err)
+
+		c.refreshMutex.Lock()
+		if err == nil {
+			if !result.notModified {
+				c.remoteKeys = result.keys
+				c.etag = result.etag
+				c.lastModified = result.lastModified
+			}
+			c.nextRefresh = result.nextRefresh
+			c.mergeKeys()
+
+			fetch.nextRefresh = result.nextRefresh
+		}
+		fetch.err = err
+		c.fetching = nil
+		c.refreshMutex.Unlock()
+
+		close(fetch.done)
+	}()
+
+	return fetch
+}
+
+// waitFetch waits for a request to the remote to finish, returning the time until the next refresh
+func waitFetch(ctx context.Context, fetch *jwksFetch) (time.Duration, error) {
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-fetch.done:
+		return fetch.nextRefresh, fetch.err
+	}
+}
+
+// fetch performs the request to the remote, conditional when the validators of the last response are given
+func (c *jwksCache) fetch(ctx context.Context, etag string, lastModified string) (*jwksFetchResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.config.uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating JWKS request: %s", err.Error())
+	}
+	req.Header.Set("Accept", "application/json")
+
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting JWKS from remote: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return &jwksFetchResult{notModified: true, nextRefresh: c.getNextRefresh(resp.Header)}, nil
+	default:
+		return nil, fmt.Errorf("unexpected status code getting JWKS from remote: %d", resp.StatusCode)
+	}
+
+	var jwks JWKS
+	if err := json.NewDecoder(io.LimitReader(resp.Body, jwksMaxBodySize)).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("failed decoding JWKS from remote: %s", err.Error())
+	}
+
+	keys := c.parseKeys(&jwks)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS from remote has no usable signing keys")
+	}
+
+	return &jwksFetchResult{
+		keys:         keys,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		nextRefresh:  c.getNextRefresh(resp.Header),
+	}, nil
+}
+
+// readFile reads the keys from the JWKS file. It must be called with the refresh mutex held.
+// Content is compared instead of modification times, as Kubernetes replaces mounted files through symlinks
+func (c *jwksCache) readFile() error {
+	fileBytes, err := os.ReadFile(c.config.file)
+	if err != nil {
+		return fmt.Errorf("failed reading JWKS file: %s", err.Error())
+	}
+
+	fileHash := sha256.Sum256(fileBytes)
+	if c.fileKeys != nil && bytes.Equal(fileHash[:], c.fileHash) {
+		return nil
+	}
+
+	var jwks JWKS
+	if err := json.Unmarshal(fileBytes, &jwks); err != nil {
+		return fmt.Errorf("failed decoding JWKS file: %s", err.Error())
+	}
+
+	keys := c.parseKeys(&jwks)
+	if len(keys) == 0 {
+		return fmt.Errorf("JWKS file has no usable signing keys")
+	}
+
+	c.fileKeys = keys
+	c.fileHash = fileHash[:]
+	return nil
+}
+
+// mergeKeys publishes the keys of every source. Keys from the file take precedence over the remote ones
+// with the same 'kid'. It must be called with the refresh mutex held
+func (c *jwksCache) mergeKeys() {
+	if c.remoteKeys == nil && c.fileKeys == nil {
+		return
+	}
+
+	keys := make(map[string]*jwksKey, len(c.remoteKeys)+len(c.fileKeys))
+	maps.Copy(keys, c.remoteKeys)
+	maps.Copy(keys, c.fileKeys)
+	c.keys.Store(&keys)
+}
+
+// parseKeys converts the signing keys of a JWKS into real keys. Broken keys are skipped, as well as
+// symmetric ones when not allowed, and the ones without a valid certificate chain when a CA is configured
+func (c *jwksCache) parseKeys(jwks *JWKS) map[string]*jwksKey {
+	keys := map[string]*jwksKey{}
+
+	// CA bundle is read on each refresh, so rotations are picked up with the keys
+	var x5cRoots *x509.CertPool
+	if c.config.x5cCAFile != "" {
+		var err error
+		x5cRoots, err = loadCertPool(c.config.x5cCAFile)
+		if err != nil {
+			c.logger.Error("failed loading CA bundle for JWK certificate chains, skipping all keys", "error", err.Error())
+			return keys
+		}
+	}
+
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+
+		publicKey, err := jwkToTrustedKey(&jwk, c.config.allowSymmetricKeys, x5cRoots)
+		if err != nil {
+			c.logger.Warn("skipping JWK", "kid", jwk.Kid, "error", err.Error())
+			continue
+		}
+
+		keys[jwk.Kid] = &jwksKey{
+			jwk:       jwk,
+			publicKey: publicKey,
+		}
+	}
+
+	return keys
+}
+
+// jwkToTrustedKey converts a JWK into a real key, checking it can be trusted: symmetric keys must be allowed,
+// and the certificate chain must lead to one of the roots, when there are some
+func jwkToTrustedKey(jwk *JWK, allowSymmetricKeys bool, x5cRoots *x509.CertPool) (interface{}, error) {
+	if jwk.Kty == "oct" && !allowSymmetricKeys {
+		return nil, fmt.Errorf("symmetric keys are not allowed")
+	}
+
+	publicKey, err := jwkToKey(jwk)
+	if err != nil {
+		return nil, fmt.Errorf("error converting JWK into a key: %s", err.Error())
+	}
+
+	if x5cRoots != nil {
+		err = verifyJWKCertificateChain(jwk, x5cRoots)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return publicKey, nil
+}
+
+// getNextRefresh returns the time until the next refresh, honouring 'Cache-Control: max-age' from the remote.
+// It is never lower than the minimum refresh interval
+func (c *jwksCache) getNextRefresh(header http.Header) time.Duration {
+	nextRefresh := c.config.refreshInterval
+
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+
+		switch strings.ToLower(name) {
+		case "no-cache", "no-store":
+			nextRefresh = 0
+		case "max-age":
+			seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+			if err == nil && seconds >= 0 {
+				nextRefresh = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	return max(nextRefresh, c.config.minRefreshInterval)
+}
@@ -0,0 +1,222 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestJWKSCache returns a cache of the given remote, refreshed hourly unless an unknown kid is looked up
+func newTestJWKSCache(uri string, minRefreshInterval time.Duration) *jwksCache {
+	return newJWKSCache(slog.New(slog.NewTextHandler(io.Discard, nil)), jwksCacheConfig{
+		uri:                uri,
+		refreshInterval:    time.Hour,
+		minRefreshInterval: minRefreshInterval,
+		startupTimeout:     5 * time.Second,
+	})
+}
+
+func TestJWKSCacheLookup(t *testing.T) {
+	_, firstKey := newTestRSAKey(t, "first")
+	_, rotatedKey := newTestRSAKey(t, "rotated")
+
+	tests := []struct {
+		name               string
+		minRefreshInterval time.Duration
+		kid                string
+		rotate             func(s *testJWKSServer)
+		wantErr            error
+		wantRequests       int32
+	}{
+		{
+			name:               "known kid is served from the cache",
+			minRefreshInterval: time.Millisecond,
+			kid:                "first",
+			wantRequests:       1,
+		},
+		{
+			name:               "unknown kid refreshes the keys after a rotation",
+			minRefreshInterval: time.Millisecond,
+			kid:                "rotated",
+			rotate: func(s *testJWKSServer) {
+				s.set(http.StatusOK, firstKey, rotatedKey)
+			},
+			wantRequests: 2,
+		},
+		{
+			name:               "unknown kid is rejected after refreshing",
+			minRefreshInterval: time.Millisecond,
+			kid:                "random",
+			wantErr:            errUnknownKid,
+			wantRequests:       2,
+		},
+		{
+			name:               "unknown kid does not refresh within the minimum interval",
+			minRefreshInterval: time.Hour,
+			kid:                "rotated",
+			rotate: func(s *testJWKSServer) {
+				s.set(http.StatusOK, firstKey, rotatedKey)
+			},
+			wantErr:      errUnknownKid,
+			wantRequests: 1,
+		},
+		{
+			name:               "last good keys are kept while the remote fails",
+			minRefreshInterval: time.Millisecond,
+			kid:                "first",
+			rotate: func(s *testJWKSServer) {
+				s.set(http.StatusInternalServerError)
+			},
+			wantRequests: 1,
+		},
+		{
+			name:               "unknown kid is not rejected as invalid while the remote fails",
+			minRefreshInterval: time.Millisecond,
+			kid:                "rotated",
+			rotate: func(s *testJWKSServer) {
+				s.set(http.StatusInternalServerError)
+			},
+			wantErr:      errJWKSUnavailable,
+			wantRequests: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestJWKSServer(t, firstKey)
+			cache := newTestJWKSCache(server.URL, tt.minRefreshInterval)
+
+			if err := cache.load(context.Background()); err != nil {
+				t.Fatalf("failed loading JWKS: %s", err.Error())
+			}
+
+			if tt.rotate != nil {
+				tt.rotate(server)
+			}
+			time.Sleep(2 * time.Millisecond)
+
+			key, err := cache.lookup(context.Background(), tt.kid)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("lookup error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && key.jwk.Kid != tt.kid {
+				t.Errorf("lookup kid = %s, want %s", key.jwk.Kid, tt.kid)
+			}
+
+			if got := server.requests.Load(); got != tt.wantRequests {
+				t.Errorf("requests to the remote = %d, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestJWKSCacheLookupConcurrentUnknownKid(t *testing.T) {
+	_, firstKey := newTestRSAKey(t, "first")
+	_, rotatedKey := newTestRSAKey(t, "rotated")
+
+	server := newTestJWKSServer(t, firstKey)
+	cache := newTestJWKSCache(server.URL, time.Millisecond)
+
+	if err := cache.load(context.Background()); err != nil {
+		t.Fatalf("failed loading JWKS: %s", err.Error())
+	}
+
+	server.set(http.StatusOK, firstKey, rotatedKey)
+	server.mutex.Lock()
+	server.delay = 100 * time.Millisecond
+	server.mutex.Unlock()
+	time.Sleep(2 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.lookup(context.Background(), "rotated")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("lookup error = %s, want none", err.Error())
+		}
+	}
+
+	// Every lookup shares the same request, besides the first load
+	if got := server.requests.Load(); got != 2 {
+		t.Errorf("requests to the remote = %d, want 2", got)
+	}
+}
+
+func TestJWKSCacheLookupNotBlockedBySlowRemote(t *testing.T) {
+	_, firstKey := newTestRSAKey(t, "first")
+
+	server := newTestJWKSServer(t, firstKey)
+	cache := newTestJWKSCache(server.URL, time.Millisecond)
+
+	if err := cache.load(context.Background()); err != nil {
+		t.Fatalf("failed loading JWKS: %s", err.Error())
+	}
+
+	server.mutex.Lock()
+	server.delay = 2 * time.Second
+	server.mutex.Unlock()
+	time.Sleep(2 * time.Millisecond)
+
+	// A slow refresh is in progress while the next lookups happen
+	go func() {
+		_, _ = cache.lookup(context.Background(), "slow")
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if _, err := cache.lookup(context.Background(), "first"); err != nil {
+		t.Fatalf("lookup error = %s, want none", err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := cache.lookup(ctx, "other"); !errors.Is(err, errJWKSUnavailable) {
+		t.Fatalf("lookup error = %v, want %v", err, errJWKSUnavailable)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("lookups took %s while the remote was slow", elapsed)
+	}
+}
+
+func TestJWTValidationMiddlewareJWKSReadiness(t *testing.T) {
+	_, jwk := newTestRSAKey(t, "first")
+	server := newTestJWKSServer(t)
+	server.set(http.StatusInternalServerError)
+
+	mw, _ := newTestJWTValidationMiddleware(t, newTestJWTConfig(server.URL))
+
+	// Not ready until the keys are loaded once, then ready while the remote keeps them
+	if err := mw.dependencies.AppCtx.CheckReadiness(context.Background())["jwks"]; err == nil {
+		t.Fatalf("expected the jwks check to fail before the keys are loaded")
+	}
+
+	server.set(http.StatusOK, jwk)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := mw.dependencies.AppCtx.CheckReadiness(context.Background())["jwks"]
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the jwks check to pass once the keys are loaded, got: %s", err.Error())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
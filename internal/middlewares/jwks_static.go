@@ -0,0 +1,112 @@
+package middlewares
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+)
+
+// parseStaticKeys converts the keys written in the config of an issuer: the inline 'jwks' and the PEM 'public_keys'.
+// Unlike the keys fetched from a remote, a broken one rejects the config, as it is written by hand
+func parseStaticKeys(config trustedIssuerConfig) (map[string]*jwksKey, error) {
+	keys := map[string]*jwksKey{}
+
+	if config.jwks != "" {
+		var jwks JWKS
+		if err := json.Unmarshal([]byte(config.jwks), &jwks); err != nil {
+			return nil, fmt.Errorf("error decoding inline JWKS: %s", err.Error())
+		}
+
+		var x5cRoots *x509.CertPool
+		if config.x5cCAFile != "" {
+			var err error
+			x5cRoots, err = loadCertPool(config.x5cCAFile)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, jwk := range jwks.Keys {
+			if jwk.Use != "" && jwk.Use != "sig" {
+				continue
+			}
+
+			publicKey, err := jwkToTrustedKey(&jwk, config.allowSymmetricKeys, x5cRoots)
+			if err != nil {
+				return nil, fmt.Errorf("inline JWK '%s': %s", jwk.Kid, err.Error())
+			}
+
+			keys[jwk.Kid] = &jwksKey{
+				jwk:       jwk,
+				publicKey: publicKey,
+			}
+		}
+	}
+
+	// PEM keys are trusted as they are, so the CA of the 'x5c' chains does not apply to them
+	for _, publicKeyConfig := range config.publicKeys {
+		if _, ok := keys[publicKeyConfig.Kid]; ok {
+			return nil, fmt.Errorf("public key '%s': kid is already used by the inline JWKS", publicKeyConfig.Kid)
+		}
+
+		publicKey, err := pemToPublicKey(publicKeyConfig.PEM)
+		if err != nil {
+			return nil, fmt.Errorf("public key '%s': %s", publicKeyConfig.Kid, err.Error())
+		}
+
+		keys[publicKeyConfig.Kid] = &jwksKey{
+			jwk: JWK{
+				Kid: publicKeyConfig.Kid,
+				Alg: publicKeyConfig.Algorithm,
+				Use: "sig",
+			},
+			publicKey: publicKey,
+		}
+	}
+
+	return keys, nil
+}
+
+// pemToPublicKey decodes a PEM encoded public key (PKIX or PKCS #1), or the key of a PEM encoded certificate.
+// Only RSA, EC and Ed25519 keys are supported
+func pemToPublicKey(pemString string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(pemString))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+
+	var publicKey interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing public key: %s", err.Error())
+		}
+		publicKey = key
+	case "RSA PUBLIC KEY":
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing RSA public key: %s", err.Error())
+		}
+		publicKey = key
+	case "CERTIFICATE":
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing certificate: %s", err.Error())
+		}
+		publicKey = certificate.PublicKey
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+	}
+
+	switch publicKey.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return publicKey, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type: %T", publicKey)
+	}
+}
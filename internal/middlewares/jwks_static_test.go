@@ -0,0 +1,362 @@
+package middlewares
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	//
+	"mcp-go/api"
+)
+
+// encodeTestPEM returns the bytes as a PEM block of the given type
+func encodeTestPEM(blockType string, blockBytes []byte) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: blockBytes}))
+}
+
+// encodeTestPublicKeyPEM returns the public key as a PKIX PEM block
+func encodeTestPublicKeyPEM(t *testing.T, publicKey any) string {
+	t.Helper()
+
+	publicKeyBytes, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatalf("failed encoding public key: %s", err.Error())
+	}
+	return encodeTestPEM("PUBLIC KEY", publicKeyBytes)
+}
+
+// encodeTestJWKS returns the keys as a JWKS document
+func encodeTestJWKS(t *testing.T, keys ...JWK) string {
+	t.Helper()
+
+	jwksBytes, err := json.Marshal(JWKS{Keys: keys})
+	if err != nil {
+		t.Fatalf("failed encoding JWKS: %s", err.Error())
+	}
+	return string(jwksBytes)
+}
+
+// writeTestJWKSFile writes the keys as a JWKS file, replacing the previous content
+func writeTestJWKSFile(t *testing.T, filePath string, keys ...JWK) {
+	t.Helper()
+
+	if err := os.WriteFile(filePath, []byte(encodeTestJWKS(t, keys...)), 0o600); err != nil {
+		t.Fatalf("failed writing JWKS file: %s", err.Error())
+	}
+}
+
+func TestPemToPublicKey(t *testing.T) {
+	rsaKey, _ := newTestRSAKey(t, "rsa")
+	ecKey, _ := newTestECKey(t, "ec")
+	edKey, _ := newTestEd25519Key(t, "okp")
+	certificate := newTestCertificate(t, "Test signer", &ecKey.PublicKey, nil, ecKey)
+
+	tests := []struct {
+		name    string
+		pem     string
+		want    any
+		wantErr string
+	}{
+		{
+			name: "RSA key in PKIX format",
+			pem:  encodeTestPublicKeyPEM(t, &rsaKey.PublicKey),
+			want: &rsaKey.PublicKey,
+		},
+		{
+			name: "RSA key in PKCS #1 format",
+			pem:  encodeTestPEM("RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)),
+			want: &rsaKey.PublicKey,
+		},
+		{
+			name: "EC key",
+			pem:  encodeTestPublicKeyPEM(t, &ecKey.PublicKey),
+			want: &ecKey.PublicKey,
+		},
+		{
+			name: "Ed25519 key",
+			pem:  encodeTestPublicKeyPEM(t, edKey.Public()),
+			want: edKey.Public(),
+		},
+		{
+			name: "key of a certificate",
+			pem:  encodeTestPEM("CERTIFICATE", certificate.Raw),
+			want: &ecKey.PublicKey,
+		},
+		{
+			name:    "not PEM",
+			pem:     "not a PEM block",
+			wantErr: "no PEM block found",
+		},
+		{
+			name:    "private key",
+			pem:     encodeTestPEM("PRIVATE KEY", []byte("private")),
+			wantErr: "unsupported PEM block type: PRIVATE KEY",
+		},
+		{
+			name:    "malformed public key",
+			pem:     encodeTestPEM("PUBLIC KEY", []byte("public")),
+			wantErr: "error parsing public key",
+		},
+		{
+			name:    "malformed certificate",
+			pem:     encodeTestPEM("CERTIFICATE", []byte("certificate")),
+			wantErr: "error parsing certificate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pemToPublicKey(tt.pem)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing '%s'", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			publicKey, ok := got.(interface{ Equal(x crypto.PublicKey) bool })
+			if !ok || !publicKey.Equal(tt.want) {
+				t.Errorf("pemToPublicKey() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStaticKeys(t *testing.T) {
+	rsaKey, rsaJWK := newTestRSAKey(t, "rsa")
+	_, ecJWK := newTestECKey(t, "ec")
+	_, encryptionJWK := newTestRSAKey(t, "encryption")
+	encryptionJWK.Use = "enc"
+	hmacJWK := JWK{Kid: "oct", Kty: "oct", Use: "sig", K: base64.RawURLEncoding.EncodeToString([]byte("s3cr3t"))}
+
+	tests := []struct {
+		name     string
+		config   trustedIssuerConfig
+		wantKids []string
+		wantErr  string
+	}{
+		{
+			name: "inline JWKS and PEM keys are merged",
+			config: trustedIssuerConfig{
+				jwks:       encodeTestJWKS(t, rsaJWK, encryptionJWK),
+				publicKeys: []api.JWTValidationPublicKey{{Kid: "pem", PEM: encodeTestPublicKeyPEM(t, &rsaKey.PublicKey), Algorithm: "RS256"}},
+			},
+			wantKids: []string{"pem", "rsa"},
+		},
+		{
+			name:    "inline JWKS is not JSON",
+			config:  trustedIssuerConfig{jwks: "{"},
+			wantErr: "error decoding inline JWKS",
+		},
+		{
+			name:    "broken inline key rejects the config",
+			config:  trustedIssuerConfig{jwks: encodeTestJWKS(t, JWK{Kid: "broken", Kty: "EC", Crv: "P-256"})},
+			wantErr: "inline JWK 'broken': error converting JWK into a key: incomplete EC key data",
+		},
+		{
+			name:    "inline symmetric key is rejected unless allowed",
+			config:  trustedIssuerConfig{jwks: encodeTestJWKS(t, hmacJWK)},
+			wantErr: "inline JWK 'oct': symmetric keys are not allowed",
+		},
+		{
+			name:     "inline symmetric key is accepted when allowed",
+			config:   trustedIssuerConfig{jwks: encodeTestJWKS(t, hmacJWK, ecJWK), allowSymmetricKeys: true},
+			wantKids: []string{"ec", "oct"},
+		},
+		{
+			name: "PEM key reusing a kid of the inline JWKS",
+			config: trustedIssuerConfig{
+				jwks:       encodeTestJWKS(t, rsaJWK),
+				publicKeys: []api.JWTValidationPublicKey{{Kid: "rsa", PEM: encodeTestPublicKeyPEM(t, &rsaKey.PublicKey)}},
+			},
+			wantErr: "public key 'rsa': kid is already used by the inline JWKS",
+		},
+		{
+			name:    "broken PEM key rejects the config",
+			config:  trustedIssuerConfig{publicKeys: []api.JWTValidationPublicKey{{Kid: "pem", PEM: "not a PEM block"}}},
+			wantErr: "public key 'pem': no PEM block found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := parseStaticKeys(tt.config)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing '%s'", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			var kids []string
+			for kid := range keys {
+				kids = append(kids, kid)
+			}
+			if len(kids) != len(tt.wantKids) {
+				t.Fatalf("kids = %v, want %v", kids, tt.wantKids)
+			}
+			for _, kid := range tt.wantKids {
+				if keys[kid] == nil {
+					t.Errorf("kid '%s' is missing, got %v", kid, kids)
+				}
+			}
+		})
+	}
+}
+
+func TestJWTValidationMiddlewareStaticKeys(t *testing.T) {
+	remoteKey, remoteJWK := newTestRSAKey(t, "shared")
+	fileKey, fileJWK := newTestRSAKey(t, "shared")
+	inlineKey, inlineJWK := newTestRSAKey(t, "shared")
+	pemKey, _ := newTestECKey(t, "pem")
+	_, otherRemoteJWK := newTestRSAKey(t, "remote-only")
+
+	server := newTestJWKSServer(t, remoteJWK, otherRemoteJWK)
+
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	writeTestJWKSFile(t, jwksFile, fileJWK)
+
+	tests := []struct {
+		name       string
+		configure  func(config *api.JWTValidationLocalConfig)
+		key        any
+		kid        string
+		wantStatus int
+		wantReason string
+	}{
+		{
+			name:       "keys of the file take precedence over the remote ones",
+			configure:  func(config *api.JWTValidationLocalConfig) { config.JWKSFile = jwksFile },
+			key:        fileKey,
+			kid:        "shared",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "remote key replaced by the file is not accepted",
+			configure:  func(config *api.JWTValidationLocalConfig) { config.JWKSFile = jwksFile },
+			key:        remoteKey,
+			kid:        "shared",
+			wantStatus: http.StatusUnauthorized,
+			wantReason: denialReasonInvalidToken,
+		},
+		{
+			name: "inline keys take precedence over the file and the remote",
+			configure: func(config *api.JWTValidationLocalConfig) {
+				config.JWKSFile = jwksFile
+				config.JWKS = encodeTestJWKS(t, inlineJWK)
+			},
+			key:        inlineKey,
+			kid:        "shared",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "inline JWKS without remote",
+			configure: func(config *api.JWTValidationLocalConfig) {
+				config.JWKSUri = ""
+				config.JWKS = encodeTestJWKS(t, inlineJWK)
+			},
+			key:        inlineKey,
+			kid:        "shared",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "PEM key without remote",
+			configure: func(config *api.JWTValidationLocalConfig) {
+				config.JWKSUri = ""
+				config.PublicKeys = []api.JWTValidationPublicKey{{Kid: "pem", PEM: encodeTestPublicKeyPEM(t, &pemKey.PublicKey), Algorithm: "ES256"}}
+			},
+			key:        pemKey,
+			kid:        "pem",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "unknown kid without remote",
+			configure: func(config *api.JWTValidationLocalConfig) {
+				config.JWKSUri = ""
+				config.JWKS = encodeTestJWKS(t, inlineJWK)
+			},
+			key:        inlineKey,
+			kid:        "remote-only",
+			wantStatus: http.StatusUnauthorized,
+			wantReason: denialReasonUnknownKid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newTestJWTConfig(server.URL)
+			tt.configure(&config.Middleware.JWT.Validation.Local)
+			mw, denialLog := newTestJWTValidationMiddleware(t, config)
+
+			method, err := getSigningMethod("RS256")
+			if _, ok := tt.key.(*rsa.PrivateKey); !ok {
+				method, err = getSigningMethod("ES256")
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			recorder := serveTestRequest(mw, newTestRequest(signTestTokenWithMethod(t, method, tt.key, tt.kid, newTestClaims())))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if got := denialLog.last(); got != tt.wantReason {
+				t.Errorf("denial reason = %s, want %s", got, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestJWTValidationMiddlewareJWKSFileReload(t *testing.T) {
+	firstKey, firstJWK := newTestRSAKey(t, "first")
+	rotatedKey, rotatedJWK := newTestRSAKey(t, "rotated")
+
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	writeTestJWKSFile(t, jwksFile, firstJWK)
+
+	config := newTestJWTConfig("")
+	config.Middleware.JWT.Validation.Local.JWKSFile = jwksFile
+	mw, _ := newTestJWTValidationMiddleware(t, config)
+
+	serve := func(key *rsa.PrivateKey, kid string) int {
+		return serveTestRequest(mw, newTestRequest(signTestToken(t, key, kid, newTestClaims()))).Code
+	}
+
+	if status := serve(firstKey, "first"); status != http.StatusOK {
+		t.Fatalf("status with the first key = %d, want %d", status, http.StatusOK)
+	}
+
+	// Unknown kids read the file again, so rotated keys are accepted before the next check
+	writeTestJWKSFile(t, jwksFile, rotatedJWK)
+	if status := serve(rotatedKey, "rotated"); status != http.StatusOK {
+		t.Fatalf("status with the rotated key = %d, want %d", status, http.StatusOK)
+	}
+	if status := serve(firstKey, "first"); status != http.StatusUnauthorized {
+		t.Errorf("status with the removed key = %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	// A broken file keeps the last good keys, while unknown kids can not be told invalid
+	if err := os.WriteFile(jwksFile, []byte("{"), 0o600); err != nil {
+		t.Fatalf("failed writing JWKS file: %s", err.Error())
+	}
+	if status := serve(firstKey, "first"); status != http.StatusServiceUnavailable {
+		t.Errorf("status with the removed key = %d, want %d", status, http.StatusServiceUnavailable)
+	}
+	if status := serve(rotatedKey, "rotated"); status != http.StatusOK {
+		t.Errorf("status with the last good key = %d, want %d", status, http.StatusOK)
+	}
+}
@@ -3,18 +3,48 @@ package middlewares
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	//
+	"mcp-go/api"
 	"mcp-go/internal/globals"
 
 	//
 	"github.com/google/cel-go/cel"
 )
 
+// Reasons for rejecting a request. They are used as metric labels
+const (
+	denialReasonMissingHeader            = "missing_header"
+	denialReasonMalformedToken           = "malformed_token"
+	denialReasonUnknownKid               = "unknown_kid"
+	denialReasonJWKSUnavailable          = "jwks_unavailable"
+	denialReasonExpired                  = "expired"
+	denialReasonNotYetValid              = "not_yet_valid"
+	denialReasonInvalidIssuer            = "invalid_issuer"
+	denialReasonInvalidAudience          = "invalid_audience"
+	denialReasonMissingClaim             = "missing_claim"
+	denialReasonTokenTooOld              = "token_too_old"
+	denialReasonAlgorithmNotAllowed      = "algorithm_not_allowed"
+	denialReasonInvalidToken             = "invalid_token"
+	denialReasonCertificateBinding       = "certificate_binding"
+	denialReasonInvalidDPoPProof         = "invalid_dpop_proof"
+	denialReasonInvalidAPIKey            = "invalid_api_key"
+	denialReasonInactiveToken            = "inactive_token"
+	denialReasonIntrospectionUnavailable = "introspection_unavailable"
+	denialReasonTokenRevoked             = "token_revoked"
+	denialReasonAdminDenied              = "admin_denied"
+	denialReasonCELDenied                = "cel_denied"
+	denialReasonPolicyDenied             = "policy_denied"
+	denialReasonInternalError            = "internal_error"
+)
+
 type JWTValidationMiddlewareDependencies struct {
 	AppCtx *globals.ApplicationContext
 }
@@ -23,35 +53,121 @@ type JWTValidationMiddleware struct {
 	dependencies JWTValidationMiddlewareDependencies
 
 	// Carried stuff
-	jwks  *JWKS
-	mutex sync.Mutex
+	trustedIssuers      atomic.Pointer[[]*trustedIssuer]
+	trustedIssuersMutex sync.Mutex
+	jwksReadinessOnce   sync.Once
+	discoveryOnce       sync.Once
+	introspector        *tokenIntrospector
+	apiKeys             *apiKeyAuthenticator
+	dpopReplays         *dpopReplayCache
+	revocations         *revocationList
+
+	revocationsReadinessOnce sync.Once
 
 	//
-	celPrograms []*cel.Program
+	celPrograms      []*cel.Program
+	policies         []*compiledPolicy
+	celProgramsMutex sync.RWMutex
 }
 
 func NewJWTValidationMiddleware(deps JWTValidationMiddlewareDependencies) (*JWTValidationMiddleware, error) {
 
 	mw := &JWTValidationMiddleware{
 		dependencies: deps,
-	}
-
-	// Launch JWKS worker only when requested
-	if mw.dependencies.AppCtx.Config.Middleware.JWT.Enabled &&
-		mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Strategy == "local" {
-		go mw.cacheJWKS()
+		introspector: newTokenIntrospector(),
+		apiKeys:      newAPIKeyAuthenticator(),
+		dpopReplays:  newDPoPReplayCache(),
+		revocations:  newRevocationList(),
 	}
 
 	// Precompile and check CEL expressions to fail-fast and safe resources.
 	// They will be truly used later.
-	allowConditionsEnv, err := cel.NewEnv(
-		cel.Variable("payload", cel.DynType),
-	)
+	celPrograms, err := compileAllowConditions(getAllowConditions(mw.dependencies.AppCtx.Config()))
+	if err != nil {
+		return nil, err
+	}
+	mw.celPrograms = celPrograms
+
+	policies, err := compileRequestPolicies(mw.dependencies.AppCtx.Config().Middleware.JWT.Validation.Policies)
+	if err != nil {
+		return nil, err
+	}
+	mw.policies = policies
+
+	// Discover the issuers and load their JWKS eagerly, only when requested.
+	// Failures are retried in background, rejecting requests meanwhile
+	trustedIssuers, err := mw.prepareTrustedIssuers(mw.dependencies.AppCtx.Config(), false)
+	if err != nil {
+		return nil, err
+	}
+	mw.applyTrustedIssuers(mw.dependencies.AppCtx.Config(), trustedIssuers)
+
+	mw.applyRevocations(mw.dependencies.AppCtx.Config())
+	go mw.watchRevocations()
+
+	mw.dependencies.AppCtx.RegisterConfigReloadHook("jwt validation middleware", mw.reloadConfig)
+
+	return mw, nil
+}
+
+// reloadConfig recompiles the CEL expressions and policies of a new config, which is rejected when they are broken.
+// New issuers are discovered, and new JWKS caches are loaded when their settings changed
+func (mw *JWTValidationMiddleware) reloadConfig(newConfig *api.Configuration) (func(), error) {
+	celPrograms, err := compileAllowConditions(getAllowConditions(newConfig))
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := compileRequestPolicies(newConfig.Middleware.JWT.Validation.Policies)
+	if err != nil {
+		return nil, err
+	}
+
+	trustedIssuers, err := mw.prepareTrustedIssuers(newConfig, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		mw.celProgramsMutex.Lock()
+		mw.celPrograms = celPrograms
+		mw.policies = policies
+		mw.celProgramsMutex.Unlock()
+
+		mw.applyTrustedIssuers(newConfig, trustedIssuers)
+		mw.applyRevocations(newConfig)
+	}, nil
+}
+
+// applyRevocations reads the revocations file of a config right away, instead of waiting for the watcher
+func (mw *JWTValidationMiddleware) applyRevocations(config *api.Configuration) {
+	revocationConfig := config.Middleware.JWT.Validation.Revocation
+	if revocationConfig.Enabled && revocationConfig.File != "" {
+		mw.revocationsReadinessOnce.Do(func() {
+			mw.dependencies.AppCtx.RegisterReadinessCheck("revocations", mw.checkRevocationsRead)
+		})
+	}
+
+	mw.refreshRevocations()
+}
+
+// getAllowConditions returns the CEL expressions of the configured validation strategy
+func getAllowConditions(config *api.Configuration) []api.JWTValidationAllowCondition {
+	if config.Middleware.JWT.Validation.Strategy == "introspection" {
+		return config.Middleware.JWT.Validation.Introspection.AllowConditions
+	}
+	return config.Middleware.JWT.Validation.Local.AllowConditions
+}
+
+// compileAllowConditions compiles the CEL expressions used to check the JWT payload and the request
+func compileAllowConditions(allowConditions []api.JWTValidationAllowCondition) ([]*cel.Program, error) {
+	allowConditionsEnv, err := newCELEnv()
 	if err != nil {
 		return nil, fmt.Errorf("CEL environment creation error: %s", err.Error())
 	}
 
-	for _, allowCondition := range mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Local.AllowConditions {
+	var celPrograms []*cel.Program
+	for _, allowCondition := range allowConditions {
 
 		// Compile and execute the code
 		ast, issues := allowConditionsEnv.Compile(allowCondition.Expression)
@@ -63,39 +179,52 @@ func NewJWTValidationMiddleware(deps JWTValidationMiddlewareDependencies) (*JWTV
 		if err != nil {
 			return nil, fmt.Errorf("CEL program construction error: %s", err.Error())
 		}
-		mw.celPrograms = append(mw.celPrograms, &prg)
+		celPrograms = append(celPrograms, &prg)
 	}
 
-	return mw, nil
+	return celPrograms, nil
 }
 
 func (mw *JWTValidationMiddleware) Middleware(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
 
-		if !mw.dependencies.AppCtx.Config.Middleware.JWT.Enabled {
+		// Authenticated caller, carried to the tools through the request context
+		var principal *Principal
+
+		if !mw.dependencies.AppCtx.Config().Middleware.JWT.Enabled {
 			goto nextStage
 		}
 
-		switch mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Strategy {
+		// Machine clients present API keys instead of tokens. They are recognized by the header
+		if mw.isAPIKeyRequest(req) {
+			claims, ok := mw.authenticateAPIKey(rw, req)
+			if !ok {
+				return
+			}
+			principal = newPrincipal(claims, AuthMethodAPIKey)
+			goto nextStage
+		}
+
+		switch mw.dependencies.AppCtx.Config().Middleware.JWT.Validation.Strategy {
 		case "local":
 			// 1. Extract token from header
 			authHeader := req.Header.Get("Authorization")
 			if authHeader == "" {
-				http.Error(rw, "RBAC: Access Denied: Authorization header not found", http.StatusUnauthorized)
+				mw.deny(rw, req, denialReasonMissingHeader, nil)
 				return
 			}
-			tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
+			tokenScheme, tokenString := getAuthorizationToken(authHeader)
 
 			// Reject unauthorized requests
-			_, err := mw.isTokenValid(tokenString)
+			issuer, err := mw.validateToken(req.Context(), tokenString)
 			if err != nil {
-				http.Error(rw, fmt.Sprintf("RBAC: Access Denied: Invalid token: %v", err.Error()), http.StatusUnauthorized)
+				mw.deny(rw, req, getTokenDenialReason(err), err)
 				return
 			}
 
 			// Put the JWT into the validated request header
-			req.Header.Set(mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.ForwardedHeader, tokenString)
+			req.Header.Set(mw.dependencies.AppCtx.Config().Middleware.JWT.Validation.ForwardedHeader, tokenString)
 
 			// Extract the JWT payload
 			tokenStringParts := strings.Split(tokenString, ".")
@@ -104,7 +233,7 @@ func (mw *JWTValidationMiddleware) Middleware(next http.Handler) http.Handler {
 			tokenPayloadBytes, err := base64.RawURLEncoding.DecodeString(tokenStringParts[1])
 			if err != nil {
 				mw.dependencies.AppCtx.Logger.Error("error decoding JWT payload from base64", "error", err.Error())
-				http.Error(rw, fmt.Sprintf("RBAC: Access Denied: JWT Payload can not be decoded"), http.StatusUnauthorized)
+				mw.deny(rw, req, denialReasonMalformedToken, nil)
 				return
 			}
 
@@ -112,36 +241,163 @@ func (mw *JWTValidationMiddleware) Middleware(next http.Handler) http.Handler {
 			err = json.Unmarshal(tokenPayloadBytes, &tokenPayload)
 			if err != nil {
 				mw.dependencies.AppCtx.Logger.Error("error decoding JWT payload from JSON", "error", err.Error())
-				http.Error(rw, fmt.Sprintf("RBAC: Access Denied: Internal Issue"), http.StatusUnauthorized)
+				mw.deny(rw, req, denialReasonMalformedToken, nil)
+				return
+			}
+
+			if mw.isRevoked(rw, req, tokenPayload, AuthMethodJWT) {
+				return
+			}
+
+			// Check the sender owns the token when it is bound to a DPoP key
+			err = mw.checkDPoP(req, tokenScheme, tokenString, tokenPayload)
+			if err != nil {
+				mw.deny(rw, req, denialReasonInvalidDPoPProof, err)
 				return
 			}
 
-			// Check allowance conditions for the JWT
+			// Check allowance conditions for the JWT, global ones first and then the ones from its issuer
 			// At this point, we assume the JWT is unmarshalled into a golang structure
-			for _, celProgram := range mw.celPrograms {
-				out, _, err := (*celProgram).Eval(map[string]interface{}{
-					"payload": tokenPayload,
-				})
-
-				if err != nil {
-					mw.dependencies.AppCtx.Logger.Error("CEL program evaluation error", "error", err.Error())
-					http.Error(rw, fmt.Sprintf("RBAC: Access Denied: Internal Issue"), http.StatusUnauthorized)
-					return
-				}
-
-				if out.Value() != true {
-					http.Error(rw, fmt.Sprintf("RBAC: Access Denied: JWT does not meet conditions"), http.StatusUnauthorized)
-					return
-				}
+			mw.celProgramsMutex.RLock()
+			celPrograms := slices.Concat(mw.celPrograms, issuer.celPrograms)
+			mw.celProgramsMutex.RUnlock()
+
+			if !mw.isPayloadAllowed(rw, req, tokenPayload, celPrograms) {
+				return
+			}
+			principal = newPrincipal(tokenPayload, AuthMethodJWT)
+
+		case "introspection":
+			// 1. Extract token from header
+			authHeader := req.Header.Get("Authorization")
+			if authHeader == "" {
+				mw.deny(rw, req, denialReasonMissingHeader, nil)
+				return
+			}
+			tokenScheme, tokenString := getAuthorizationToken(authHeader)
+
+			// Ask the authorization server about the token. Inactive ones are rejected as any invalid token
+			introspectionConfig := mw.dependencies.AppCtx.Config().Middleware.JWT.Validation.Introspection
+			tokenPayload, err := mw.introspector.introspect(req.Context(), introspectionConfig, tokenString)
+			if errors.Is(err, errTokenInactive) {
+				mw.deny(rw, req, denialReasonInactiveToken, err)
+				return
+			}
+			if err != nil {
+				mw.dependencies.AppCtx.Logger.Error("token introspection error", "error", err.Error())
+				mw.deny(rw, req, denialReasonIntrospectionUnavailable, nil)
+				return
+			}
+
+			if mw.isRevoked(rw, req, tokenPayload, AuthMethodIntrospection) {
+				return
+			}
+
+			// Put the token into the validated request header
+			req.Header.Set(mw.dependencies.AppCtx.Config().Middleware.JWT.Validation.ForwardedHeader, tokenString)
+
+			// Check the sender owns the token when it is bound to a DPoP key
+			err = mw.checkDPoP(req, tokenScheme, tokenString, tokenPayload)
+			if err != nil {
+				mw.deny(rw, req, denialReasonInvalidDPoPProof, err)
+				return
+			}
+
+			// Introspection responses are checked as JWT payloads
+			mw.celProgramsMutex.RLock()
+			celPrograms := mw.celPrograms
+			mw.celProgramsMutex.RUnlock()
+
+			if !mw.isPayloadAllowed(rw, req, tokenPayload, celPrograms) {
+				return
 			}
+			principal = newPrincipal(tokenPayload, AuthMethodIntrospection)
 
 		default:
 			// Having a validated JWT into a specific header is the default behavior,
 			// as having tools like Istio securing APIs is much more safe and reliable
-			// When the token is already validated, do nothing.
+			// When the token is already validated, do nothing, except checking it was not revoked meanwhile
+			if mw.isForwardedTokenRevoked(rw, req) {
+				return
+			}
 		}
 
 	nextStage:
+		if principal != nil {
+			req = req.WithContext(WithPrincipal(req.Context(), principal))
+		}
 		next.ServeHTTP(rw, req)
 	})
 }
+
+// isPayloadAllowed checks the claims of a valid token: the certificate binding and the allowance conditions.
+// The request is rejected when they are not met
+func (mw *JWTValidationMiddleware) isPayloadAllowed(rw http.ResponseWriter, req *http.Request, tokenPayload map[string]any, celPrograms []*cel.Program) bool {
+
+	// Check the token is bound to the client certificate when the resource advertises it
+	if mw.dependencies.AppCtx.Config().OAuthProtectedResource.TLSClientCertificateBoundAccessTokens {
+		err := checkCertificateBinding(req, tokenPayload)
+		if err != nil {
+			mw.deny(rw, req, denialReasonCertificateBinding, err)
+			return false
+		}
+	}
+
+	return mw.isAuthorized(rw, req, tokenPayload, celPrograms)
+}
+
+// isAPIKeyRequest returns whether the request must be authenticated with an API key:
+// always with strategy 'api_key', and when the key header is present if API keys are enabled with other strategy
+func (mw *JWTValidationMiddleware) isAPIKeyRequest(req *http.Request) bool {
+	validationConfig := mw.dependencies.AppCtx.Config().Middleware.JWT.Validation
+	if validationConfig.Strategy == "api_key" {
+		return true
+	}
+	return validationConfig.APIKey.Enabled && req.Header.Get(getAPIKeyHeader(validationConfig.APIKey)) != ""
+}
+
+// authenticateAPIKey checks the API key of the request, returning the synthetic claims of the key.
+// They are forwarded as a token too, for tools reading the header. The request is rejected when the key is not valid
+func (mw *JWTValidationMiddleware) authenticateAPIKey(rw http.ResponseWriter, req *http.Request) (map[string]any, bool) {
+	validationConfig := mw.dependencies.AppCtx.Config().Middleware.JWT.Validation
+
+	presentedKey := req.Header.Get(getAPIKeyHeader(validationConfig.APIKey))
+	if presentedKey == "" {
+		mw.deny(rw, req, denialReasonMissingHeader, nil)
+		return nil, false
+	}
+
+	key, err := mw.apiKeys.authenticate(validationConfig.APIKey, presentedKey)
+	switch {
+	case errors.Is(err, errInvalidAPIKey):
+		mw.deny(rw, req, denialReasonInvalidAPIKey, err)
+		return nil, false
+	case errors.Is(err, errExpiredAPIKey):
+		mw.deny(rw, req, denialReasonExpired, err)
+		return nil, false
+	case err != nil:
+		mw.dependencies.AppCtx.Logger.Error("API key authentication error", "error", err.Error())
+		mw.deny(rw, req, denialReasonInternalError, nil)
+		return nil, false
+	}
+
+	claims := getAPIKeyClaims(key)
+	if mw.isRevoked(rw, req, claims, AuthMethodAPIKey) {
+		return nil, false
+	}
+
+	syntheticToken, err := getSyntheticToken(claims)
+	if err != nil {
+		mw.dependencies.AppCtx.Logger.Error("error encoding API key claims", "error", err.Error())
+		mw.deny(rw, req, denialReasonInternalError, nil)
+		return nil, false
+	}
+	req.Header.Set(validationConfig.ForwardedHeader, syntheticToken)
+
+	// Keys are not bound to certificates, so only allowance conditions and policies apply
+	mw.celProgramsMutex.RLock()
+	celPrograms := mw.celPrograms
+	mw.celProgramsMutex.RUnlock()
+
+	return claims, mw.isAuthorized(rw, req, claims, celPrograms)
+}
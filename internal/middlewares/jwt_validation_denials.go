@@ -0,0 +1,157 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	//
+	"mcp-go/api"
+	"mcp-go/internal/metrics"
+)
+
+const (
+	// protectedResourceMetadataPath is the path where the metadata of this resource is published
+	protectedResourceMetadataPath = "/.well-known/oauth-protected-resource"
+)
+
+// denial represents how a rejection is answered to the client. Only generic descriptions are sent,
+// as details about why a token is not valid help attackers more than clients.
+// Server side failures are answered with a 5xx status and no challenge, so clients do not discard valid tokens
+// Ref: https://datatracker.ietf.org/doc/html/rfc6750#section-3.1
+type denial struct {
+	status      int
+	errorCode   string
+	description string
+}
+
+// DenialResponse represents the JSON body of the rejected requests
+type DenialResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+var (
+	// denials indexes how to answer each reason of rejection
+	denials = map[string]denial{
+		denialReasonMissingHeader:            {http.StatusUnauthorized, "", "Authentication is required"},
+		denialReasonMalformedToken:           {http.StatusUnauthorized, "invalid_token", "The access token is malformed"},
+		denialReasonUnknownKid:               {http.StatusUnauthorized, "invalid_token", "The access token is not valid"},
+		denialReasonJWKSUnavailable:          {http.StatusServiceUnavailable, "temporarily_unavailable", "The access token can not be validated now"},
+		denialReasonExpired:                  {http.StatusUnauthorized, "invalid_token", "The credentials expired"},
+		denialReasonNotYetValid:              {http.StatusUnauthorized, "invalid_token", "The access token is not valid yet"},
+		denialReasonInvalidIssuer:            {http.StatusUnauthorized, "invalid_token", "The access token is issued by an untrusted issuer"},
+		denialReasonInvalidAudience:          {http.StatusUnauthorized, "invalid_token", "The access token is not intended for this resource"},
+		denialReasonMissingClaim:             {http.StatusUnauthorized, "invalid_token", "The access token lacks required claims"},
+		denialReasonTokenTooOld:              {http.StatusUnauthorized, "invalid_token", "The access token was issued too long ago"},
+		denialReasonAlgorithmNotAllowed:      {http.StatusUnauthorized, "invalid_token", "The access token is not valid"},
+		denialReasonInvalidToken:             {http.StatusUnauthorized, "invalid_token", "The access token is not valid"},
+		denialReasonCertificateBinding:       {http.StatusUnauthorized, "invalid_token", "The access token is not bound to the client certificate"},
+		denialReasonInactiveToken:            {http.StatusUnauthorized, "invalid_token", "The access token is not active"},
+		denialReasonIntrospectionUnavailable: {http.StatusServiceUnavailable, "temporarily_unavailable", "The access token can not be validated now"},
+		denialReasonInvalidDPoPProof:         {http.StatusUnauthorized, "invalid_dpop_proof", "The DPoP proof is not valid"},
+		denialReasonInvalidAPIKey:            {http.StatusUnauthorized, "invalid_token", "The API key is not valid"},
+		denialReasonTokenRevoked:             {http.StatusUnauthorized, "invalid_token", "The credentials are revoked"},
+		denialReasonAdminDenied:              {http.StatusForbidden, "insufficient_scope", "The credentials do not grant access to this resource"},
+		denialReasonCELDenied:                {http.StatusForbidden, "insufficient_scope", "The credentials do not grant access to this resource"},
+		denialReasonPolicyDenied:             {http.StatusForbidden, "insufficient_scope", "The request is denied by a policy"},
+		denialReasonInternalError:            {http.StatusInternalServerError, "server_error", "The credentials can not be validated now"},
+	}
+)
+
+// deny rejects the request, recording the reason of the rejection. The client is told where to authenticate
+// through the 'WWW-Authenticate' header, and what failed in a JSON body. Details are only logged
+// Ref: https://datatracker.ietf.org/doc/html/rfc9728#section-5.1
+func (mw *JWTValidationMiddleware) deny(rw http.ResponseWriter, req *http.Request, reason string, err error) {
+	metrics.JWTRejectionsTotal.WithLabelValues(reason).Inc()
+
+	if err != nil {
+		mw.dependencies.AppCtx.Logger.Debug("request denied", "reason", reason, "error", err.Error())
+	}
+
+	config := mw.dependencies.AppCtx.Config()
+	writeDenial(rw, req, config, denials[reason], config.GetScopesSupported())
+}
+
+// writeDenial answers a rejected request with the challenge and the JSON body.
+// The challenge uses the 'DPoP' scheme when DPoP proofs are involved, and 'Bearer' otherwise
+func writeDenial(rw http.ResponseWriter, req *http.Request, config *api.Configuration, d denial, scopes []string) {
+	if d.status == 0 {
+		d = denials[denialReasonInvalidToken]
+	}
+
+	// Credentials are not at fault on server side failures, so there is nothing to challenge
+	if d.status >= http.StatusInternalServerError {
+		writeDenialBody(rw, d)
+		return
+	}
+
+	scheme := "Bearer"
+	if d.errorCode == "invalid_dpop_proof" || config.OAuthProtectedResource.DPoPBoundAccessTokensRequired {
+		scheme = "DPoP"
+	}
+
+	params := []string{}
+	if config.OAuthProtectedResource.Enabled {
+		params = append(params, fmt.Sprintf("resource_metadata=%q", getProtectedResourceMetadataUri(req, config)))
+	}
+
+	// No error is given when credentials are missing, as clients may not know authentication is needed
+	if d.errorCode != "" {
+		params = append(params, fmt.Sprintf("error=%q", d.errorCode), fmt.Sprintf("error_description=%q", d.description))
+	}
+
+	if len(scopes) > 0 {
+		params = append(params, fmt.Sprintf("scope=%q", strings.Join(scopes, " ")))
+	}
+
+	if scheme == "DPoP" {
+		algs := config.OAuthProtectedResource.DPoPSigningAlgValuesSupported
+		if len(algs) == 0 {
+			algs = defaultDPoPSigningAlgs
+		}
+		params = append(params, fmt.Sprintf("algs=%q", strings.Join(algs, " ")))
+	}
+
+	challenge := scheme
+	if len(params) > 0 {
+		challenge += " " + strings.Join(params, ", ")
+	}
+
+	// Browser based clients can only read the challenge when it is exposed
+	rw.Header().Set("WWW-Authenticate", challenge)
+	rw.Header().Set("Access-Control-Expose-Headers", "WWW-Authenticate")
+	writeDenialBody(rw, d)
+}
+
+// writeDenialBody writes the status and the JSON body of a rejected request
+func writeDenialBody(rw http.ResponseWriter, d denial) {
+	body := DenialResponse{
+		Error:            d.errorCode,
+		ErrorDescription: d.description,
+	}
+	if body.Error == "" {
+		body.Error = "unauthorized"
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(d.status)
+	_ = json.NewEncoder(rw).Encode(body)
+}
+
+// getProtectedResourceMetadataUri returns the URI of the metadata of this resource, on the origin of the
+// resource identifier. When it is not a URL, the origin the request was sent to is used
+func getProtectedResourceMetadataUri(req *http.Request, config *api.Configuration) string {
+	if resourceUri, err := url.Parse(config.OAuthProtectedResource.Resource); err == nil && resourceUri.Host != "" {
+		return resourceUri.Scheme + "://" + resourceUri.Host + protectedResourceMetadataPath
+	}
+
+	requestUri, err := url.Parse(getRequestTargetUri(req, config.Server.Transport.HTTP.TrustForwardedHeaders))
+	if err != nil {
+		return protectedResourceMetadataPath
+	}
+	return requestUri.Scheme + "://" + requestUri.Host + protectedResourceMetadataPath
+}
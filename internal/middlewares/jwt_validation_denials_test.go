@@ -0,0 +1,321 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	//
+	"mcp-go/api"
+)
+
+func TestWriteDenial(t *testing.T) {
+	tests := []struct {
+		name          string
+		configure     func(config *api.Configuration)
+		reason        string
+		wantStatus    int
+		wantError     string
+		wantChallenge string
+	}{
+		{
+			name:       "missing credentials are challenged without error",
+			reason:     denialReasonMissingHeader,
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "unauthorized",
+			wantChallenge: `Bearer resource_metadata="https://mcp-go.example.com/.well-known/oauth-protected-resource", ` +
+				`scope="mcp:read mcp:write"`,
+		},
+		{
+			name:       "invalid token is challenged with the error and its description",
+			reason:     denialReasonExpired,
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_token",
+			wantChallenge: `Bearer resource_metadata="https://mcp-go.example.com/.well-known/oauth-protected-resource", ` +
+				`error="invalid_token", error_description="The credentials expired", scope="mcp:read mcp:write"`,
+		},
+		{
+			name:   "metadata is located on the origin of the configured resource",
+			reason: denialReasonMissingHeader,
+			configure: func(config *api.Configuration) {
+				config.OAuthProtectedResource.Resource = "https://public.example.com/mcp"
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "unauthorized",
+			wantChallenge: `Bearer resource_metadata="https://public.example.com/.well-known/oauth-protected-resource", ` +
+				`scope="mcp:read mcp:write"`,
+		},
+		{
+			name:   "metadata is not advertised when the protected resource is disabled",
+			reason: denialReasonMissingHeader,
+			configure: func(config *api.Configuration) {
+				config.OAuthProtectedResource.Enabled = false
+				config.OAuthProtectedResource.ScopesSupported = nil
+			},
+			wantStatus:    http.StatusUnauthorized,
+			wantError:     "unauthorized",
+			wantChallenge: "Bearer",
+		},
+		{
+			name:   "required DPoP is challenged with the accepted algorithms",
+			reason: denialReasonMissingHeader,
+			configure: func(config *api.Configuration) {
+				config.OAuthProtectedResource.DPoPBoundAccessTokensRequired = true
+				config.OAuthProtectedResource.DPoPSigningAlgValuesSupported = []string{"ES256", "EdDSA"}
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "unauthorized",
+			wantChallenge: `DPoP resource_metadata="https://mcp-go.example.com/.well-known/oauth-protected-resource", ` +
+				`scope="mcp:read mcp:write", algs="ES256 EdDSA"`,
+		},
+		{
+			name:       "insufficient scope is forbidden",
+			reason:     denialReasonCELDenied,
+			wantStatus: http.StatusForbidden,
+			wantError:  "insufficient_scope",
+			wantChallenge: `Bearer resource_metadata="https://mcp-go.example.com/.well-known/oauth-protected-resource", ` +
+				`error="insufficient_scope", error_description="The credentials do not grant access to this resource", ` +
+				`scope="mcp:read mcp:write"`,
+		},
+		{
+			name:       "JWKS outage is not challenged",
+			reason:     denialReasonJWKSUnavailable,
+			wantStatus: http.StatusServiceUnavailable,
+			wantError:  "temporarily_unavailable",
+		},
+		{
+			name:       "introspection outage is not challenged",
+			reason:     denialReasonIntrospectionUnavailable,
+			wantStatus: http.StatusServiceUnavailable,
+			wantError:  "temporarily_unavailable",
+		},
+		{
+			name:       "internal error is not challenged",
+			reason:     denialReasonInternalError,
+			wantStatus: http.StatusInternalServerError,
+			wantError:  "server_error",
+		},
+		{
+			name:       "unknown reason is taken as an invalid token",
+			reason:     "unknown",
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_token",
+			wantChallenge: `Bearer resource_metadata="https://mcp-go.example.com/.well-known/oauth-protected-resource", ` +
+				`error="invalid_token", error_description="The access token is not valid", scope="mcp:read mcp:write"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &api.Configuration{
+				OAuthProtectedResource: api.OAuthProtectedResourceConfig{
+					Enabled:         true,
+					ScopesSupported: []string{"mcp:read", "mcp:write"},
+				},
+			}
+			if tt.configure != nil {
+				tt.configure(config)
+			}
+
+			recorder := httptest.NewRecorder()
+			writeDenial(recorder, newTestRequest(""), config, denials[tt.reason], config.GetScopesSupported())
+
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, tt.wantStatus)
+			}
+			if got := getDenialError(t, recorder); got != tt.wantError {
+				t.Errorf("error = %s, want %s", got, tt.wantError)
+			}
+			if got := recorder.Header().Get("WWW-Authenticate"); got != tt.wantChallenge {
+				t.Errorf("challenge = %s, want %s", got, tt.wantChallenge)
+			}
+			if got := recorder.Header().Get("Cache-Control"); got != "no-store" {
+				t.Errorf("Cache-Control = %s, want no-store", got)
+			}
+		})
+	}
+}
+
+func TestGetRequestTargetUri(t *testing.T) {
+	tests := []struct {
+		name                  string
+		uri                   string
+		forwardedProto        string
+		forwardedHost         string
+		trustForwardedHeaders bool
+		want                  string
+	}{
+		{
+			name: "plain request",
+			uri:  "http://10.0.0.1:8080/mcp?session=1",
+			want: "http://10.0.0.1:8080/mcp",
+		},
+		{
+			name: "TLS request",
+			uri:  "https://mcp-go.example.com/mcp",
+			want: "https://mcp-go.example.com/mcp",
+		},
+		{
+			name:           "forwarded headers are ignored unless trusted",
+			uri:            "http://10.0.0.1:8080/mcp",
+			forwardedProto: "https",
+			forwardedHost:  "attacker.example.com",
+			want:           "http://10.0.0.1:8080/mcp",
+		},
+		{
+			name:                  "trusted forwarded headers are honoured",
+			uri:                   "http://10.0.0.1:8080/mcp",
+			forwardedProto:        "https",
+			forwardedHost:         "mcp-go.example.com",
+			trustForwardedHeaders: true,
+			want:                  "https://mcp-go.example.com/mcp",
+		},
+		{
+			name:                  "trusted forwarded headers are honoured one by one",
+			uri:                   "http://mcp-go.example.com/mcp",
+			forwardedProto:        "https",
+			trustForwardedHeaders: true,
+			want:                  "https://mcp-go.example.com/mcp",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.uri, nil)
+			if tt.forwardedProto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.forwardedProto)
+			}
+			if tt.forwardedHost != "" {
+				req.Header.Set("X-Forwarded-Host", tt.forwardedHost)
+			}
+
+			if got := getRequestTargetUri(req, tt.trustForwardedHeaders); got != tt.want {
+				t.Errorf("getRequestTargetUri() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetProtectedResourceMetadataUri(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "http://10.0.0.1:8080/mcp", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.Header.Set("X-Forwarded-Host", "attacker.example.com")
+
+	tests := []struct {
+		name                  string
+		resource              string
+		trustForwardedHeaders bool
+		want                  string
+	}{
+		{
+			name:     "configured resource is preferred over the request",
+			resource: "https://mcp-go.example.com/mcp",
+			want:     "https://mcp-go.example.com/.well-known/oauth-protected-resource",
+		},
+		{
+			name:                  "configured resource is preferred over trusted forwarded headers",
+			resource:              "https://mcp-go.example.com/mcp",
+			trustForwardedHeaders: true,
+			want:                  "https://mcp-go.example.com/.well-known/oauth-protected-resource",
+		},
+		{
+			name:     "request origin is used when the resource is not a URL",
+			resource: "mcp-go",
+			want:     "http://10.0.0.1:8080/.well-known/oauth-protected-resource",
+		},
+		{
+			name:                  "trusted forwarded headers are used when the resource is not a URL",
+			trustForwardedHeaders: true,
+			want:                  "https://attacker.example.com/.well-known/oauth-protected-resource",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &api.Configuration{}
+			config.OAuthProtectedResource.Resource = tt.resource
+			config.Server.Transport.HTTP.TrustForwardedHeaders = tt.trustForwardedHeaders
+
+			if got := getProtectedResourceMetadataUri(req, config); got != tt.want {
+				t.Errorf("getProtectedResourceMetadataUri() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWTValidationMiddlewareJWKSOutage(t *testing.T) {
+	key, jwk := newTestRSAKey(t, "first")
+	rotatedKey, _ := newTestRSAKey(t, "rotated")
+	server := newTestJWKSServer(t, jwk)
+
+	mw, denialLog := newTestJWTValidationMiddleware(t, newTestJWTConfig(server.URL))
+
+	// Keys already loaded keep validating tokens, while unknown ones can not be told invalid
+	server.set(http.StatusInternalServerError)
+
+	recorder := serveTestRequest(mw, newTestRequest(signTestToken(t, key, "first", newTestClaims())))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("status with a loaded key = %d, want %d", recorder.Code, http.StatusOK)
+	}
+
+	recorder = serveTestRequest(mw, newTestRequest(signTestToken(t, rotatedKey, "rotated", newTestClaims())))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status with a rotated key = %d, want %d", recorder.Code, http.StatusServiceUnavailable)
+	}
+	if got := recorder.Header().Get("WWW-Authenticate"); got != "" {
+		t.Errorf("challenge = %s, want none", got)
+	}
+	if got := denialLog.last(); got != denialReasonJWKSUnavailable {
+		t.Errorf("denial reason = %s, want %s", got, denialReasonJWKSUnavailable)
+	}
+}
+
+func TestJWTValidationMiddlewareDPoPBehindProxy(t *testing.T) {
+	key, jwk := newTestRSAKey(t, "first")
+	server := newTestJWKSServer(t, jwk)
+	clientKey := newTestDPoPKey(t)
+
+	tests := []struct {
+		name                  string
+		trustForwardedHeaders bool
+		wantStatus            int
+	}{
+		{
+			name:                  "proof for the public URI is accepted when forwarded headers are trusted",
+			trustForwardedHeaders: true,
+			wantStatus:            http.StatusOK,
+		},
+		{
+			name:       "proof for the public URI is rejected when forwarded headers are not trusted",
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newTestJWTConfig(server.URL)
+			config.Server.Transport.HTTP.TrustForwardedHeaders = tt.trustForwardedHeaders
+			mw, _ := newTestJWTValidationMiddleware(t, config)
+
+			claims := newTestClaims()
+			claims["cnf"] = map[string]any{"jkt": clientKey.thumbprint(t)}
+			accessToken := signTestToken(t, key, "first", claims)
+
+			// The proxy terminates TLS, and forwards the request to the address of the server
+			req := httptest.NewRequest(http.MethodPost, "http://10.0.0.1:8080/mcp", nil)
+			req.Header.Set("X-Forwarded-Proto", "https")
+			req.Header.Set("X-Forwarded-Host", "mcp-go.example.com")
+			req.Header.Set("Authorization", "DPoP "+accessToken)
+			req.Header.Set("DPoP", clientKey.proof(t, accessToken, nil))
+
+			recorder := serveTestRequest(mw, req)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK && !strings.HasPrefix(recorder.Header().Get("WWW-Authenticate"), "DPoP ") {
+				t.Errorf("challenge = %s, want the DPoP scheme", recorder.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	//
+	"mcp-go/api"
+	"mcp-go/internal/metrics"
+
+	//
+	"github.com/google/cel-go/cel"
+)
+
+const (
+	// policyMaxBodySize is the maximum size of the request body parsed to expose the MCP call to CEL expressions.
+	// Bigger bodies are passed through untouched, exposing an empty call
+	policyMaxBodySize = 1 << 20
+)
+
+// compiledPolicy represents a policy from the config with its expression ready to be evaluated
+type compiledPolicy struct {
+	config  api.Policy
+	program cel.Program
+}
+
+// newCELEnv returns the environment for the allow conditions and policies. Variables:
+//   - payload: claims of the caller (JWT payload, introspection response or API key claims)
+//   - request: HTTP request, as {method, path, headers, remote_addr}. Header names are lowercase
+//   - now: current time, as a timestamp
+//   - mcp: JSON-RPC call, as {method, tool}. Fields are empty when the request carries no call
+func newCELEnv() (*cel.Env, error) {
+	return cel.NewEnv(
+		cel.Variable("payload", cel.DynType),
+		cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("now", cel.TimestampType),
+		cel.Variable("mcp", cel.MapType(cel.StringType, cel.StringType)),
+	)
+}
+
+// compilePolicies compiles the CEL expressions of the policies in the given environment
+func compilePolicies(env *cel.Env, policies []api.Policy) ([]*compiledPolicy, error) {
+	var compiledPolicies []*compiledPolicy
+	for _, policy := range policies {
+		ast, issues := env.Compile(policy.Expression)
+		if issues != nil && issues.Err() != nil {
+			return nil, fmt.Errorf("CEL expression of policy '%s' compilation exited with error: %s", policy.Name, issues.Err())
+		}
+
+		prg, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("CEL program construction error for policy '%s': %s", policy.Name, err.Error())
+		}
+
+		compiledPolicies = append(compiledPolicies, &compiledPolicy{
+			config:  policy,
+			program: prg,
+		})
+	}
+
+	return compiledPolicies, nil
+}
+
+// compileRequestPolicies compiles the policies checked for authenticated requests
+func compileRequestPolicies(policies []api.Policy) ([]*compiledPolicy, error) {
+	env, err := newCELEnv()
+	if err != nil {
+		return nil, fmt.Errorf("CEL environment creation error: %s", err.Error())
+	}
+	return compilePolicies(env, policies)
+}
+
+// isDenied returns whether the result of a policy expression denies the request, according to its effect.
+// Allow policies deny what they do not match, deny policies deny what they match
+func (p *compiledPolicy) isDenied(out any) bool {
+	if p.config.Effect == "deny" {
+		return out == true
+	}
+	return out != true
+}
+
+// isAuthorized checks the allowance conditions and then the policies for an authenticated caller.
+// Batched JSON-RPC calls are checked one by one, so a denied call can not hide behind an allowed one.
+// The request is rejected when they are not met
+func (mw *JWTValidationMiddleware) isAuthorized(rw http.ResponseWriter, req *http.Request, tokenPayload map[string]any, celPrograms []*cel.Program) bool {
+	mw.celProgramsMutex.RLock()
+	policies := mw.policies
+	mw.celProgramsMutex.RUnlock()
+
+	if len(celPrograms) == 0 && len(policies) == 0 {
+		return true
+	}
+
+	for _, activation := range getCELActivations(req, tokenPayload) {
+		for _, celProgram := range celPrograms {
+			out, _, err := (*celProgram).Eval(activation)
+			if err != nil {
+				mw.dependencies.AppCtx.Logger.Error("CEL program evaluation error", "error", err.Error())
+				mw.deny(rw, req, denialReasonInternalError, nil)
+				return false
+			}
+
+			if out.Value() != true {
+				mw.deny(rw, req, denialReasonCELDenied, nil)
+				return false
+			}
+		}
+
+		for _, policy := range policies {
+			out, _, err := policy.program.Eval(activation)
+			if err != nil {
+				mw.dependencies.AppCtx.Logger.Error("CEL policy evaluation error", "policy", policy.config.Name, "error", err.Error())
+
+				// Policies in dry-run never reject requests, not even when they are broken
+				if policy.config.DryRun {
+					continue
+				}
+				mw.deny(rw, req, denialReasonInternalError, nil)
+				return false
+			}
+
+			if !policy.isDenied(out.Value()) {
+				continue
+			}
+
+			mcpCall, _ := activation["mcp"].(map[string]string)
+			if policy.config.DryRun {
+				mw.dependencies.AppCtx.Logger.Info("policy in dry-run would deny the request",
+					"policy", policy.config.Name, "subject", tokenPayload["sub"], "path", req.URL.Path,
+					"mcp_method", mcpCall["method"], "mcp_tool", mcpCall["tool"])
+				continue
+			}
+
+			mw.dependencies.AppCtx.Logger.Info("policy denied the request",
+				"policy", policy.config.Name, "subject", tokenPayload["sub"], "path", req.URL.Path,
+				"mcp_method", mcpCall["method"], "mcp_tool", mcpCall["tool"])
+			mw.denyByPolicy(rw, req, policy.config)
+			return false
+		}
+	}
+
+	return true
+}
+
+// denyByPolicy rejects the request denied by a policy. Its message is sent to the client, as it is
+// written by the administrators for them
+func (mw *JWTValidationMiddleware) denyByPolicy(rw http.ResponseWriter, req *http.Request, policy api.Policy) {
+	metrics.JWTRejectionsTotal.WithLabelValues(denialReasonPolicyDenied).Inc()
+
+	d := denials[denialReasonPolicyDenied]
+	if policy.Message != "" {
+		d.description = policy.Message
+	}
+
+	config := mw.dependencies.AppCtx.Config()
+	writeDenial(rw, req, config, d, config.GetScopesSupported())
+}
+
+// getCELActivations returns the variables for the CEL expressions, one set for each JSON-RPC call in the request
+func getCELActivations(req *http.Request, tokenPayload map[string]any) []map[string]any {
+	headers := map[string]any{}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+
+	request := map[string]any{
+		"method":      req.Method,
+		"path":        req.URL.Path,
+		"headers":     headers,
+		"remote_addr": req.RemoteAddr,
+	}
+	now := time.Now()
+
+	var activations []map[string]any
+	for _, mcpCall := range getMCPCalls(req) {
+		activations = append(activations, map[string]any{
+			"payload": tokenPayload,
+			"request": request,
+			"now":     now,
+			"mcp":     mcpCall,
+		})
+	}
+	return activations
+}
+
+// getMCPCalls returns the method and tool name of the JSON-RPC calls in the request body, which is kept
+// readable for the next handlers. It returns one empty call when the body carries none
+func getMCPCalls(req *http.Request) []map[string]string {
+	emptyCalls := []map[string]string{{"method": "", "tool": ""}}
+
+	if req.Method != http.MethodPost || req.Body == nil {
+		return emptyCalls
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return emptyCalls
+	}
+
+	// The read part is put back in front of the rest, so bodies over the limit are not cut
+	body, err := io.ReadAll(io.LimitReader(req.Body, policyMaxBodySize+1))
+	req.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+
+	if err != nil || len(body) > policyMaxBodySize {
+		return emptyCalls
+	}
+
+	type jsonRPCMessage struct {
+		Method string `json:"method"`
+		Params struct {
+			Name string `json:"name"`
+		} `json:"params"`
+	}
+
+	var messages []jsonRPCMessage
+	trimmedBody := bytes.TrimSpace(body)
+	if len(trimmedBody) > 0 && trimmedBody[0] == '[' {
+		err = json.Unmarshal(trimmedBody, &messages)
+	} else {
+		var message jsonRPCMessage
+		err = json.Unmarshal(trimmedBody, &message)
+		messages = append(messages, message)
+	}
+
+	if err != nil || len(messages) == 0 {
+		return emptyCalls
+	}
+
+	var calls []map[string]string
+	for _, message := range messages {
+		call := map[string]string{"method": message.Method, "tool": ""}
+		if message.Method == "tools/call" {
+			call["tool"] = message.Params.Name
+		}
+		calls = append(calls, call)
+	}
+	return calls
+}
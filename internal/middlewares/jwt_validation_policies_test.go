@@ -0,0 +1,291 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	//
+	"mcp-go/api"
+)
+
+func TestJWTValidationMiddlewarePolicies(t *testing.T) {
+	key, jwk := newTestRSAKey(t, "first")
+	server := newTestJWKSServer(t, jwk)
+
+	const (
+		listTools  = `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`
+		callHello  = `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"hello"}}`
+		callDelete = `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"delete"}}`
+	)
+
+	tests := []struct {
+		name            string
+		allowConditions []api.JWTValidationAllowCondition
+		policies        []api.Policy
+		body            string
+		headers         map[string]string
+		wantStatus      int
+		wantError       string
+		wantDescription string
+	}{
+		{
+			name:            "allow condition over the request headers is met",
+			allowConditions: []api.JWTValidationAllowCondition{{Expression: `request.headers["x-tenant"] == "acme"`}},
+			body:            listTools,
+			headers:         map[string]string{"X-Tenant": "acme"},
+			wantStatus:      http.StatusOK,
+		},
+		{
+			name:            "allow condition over the request headers is not met",
+			allowConditions: []api.JWTValidationAllowCondition{{Expression: `request.headers["x-tenant"] == "acme"`}},
+			body:            listTools,
+			headers:         map[string]string{"X-Tenant": "other"},
+			wantStatus:      http.StatusForbidden,
+			wantError:       "insufficient_scope",
+		},
+		{
+			name:            "allow condition over the current time",
+			allowConditions: []api.JWTValidationAllowCondition{{Expression: `now < timestamp("2000-01-01T00:00:00Z")`}},
+			body:            listTools,
+			wantStatus:      http.StatusForbidden,
+			wantError:       "insufficient_scope",
+		},
+		{
+			name:            "allow condition over the called tool",
+			allowConditions: []api.JWTValidationAllowCondition{{Expression: `mcp.method != "tools/call" || mcp.tool == "hello"`}},
+			body:            callDelete,
+			wantStatus:      http.StatusForbidden,
+			wantError:       "insufficient_scope",
+		},
+		{
+			name:            "broken allow condition is an internal error",
+			allowConditions: []api.JWTValidationAllowCondition{{Expression: `payload.missing == "value"`}},
+			body:            listTools,
+			wantStatus:      http.StatusInternalServerError,
+			wantError:       "server_error",
+		},
+		{
+			name: "deny policy denies matching calls with its message",
+			policies: []api.Policy{
+				{Name: "no-delete", Expression: `mcp.tool == "delete"`, Effect: "deny", Message: "Deleting is disabled"},
+			},
+			body:            callDelete,
+			wantStatus:      http.StatusForbidden,
+			wantError:       "insufficient_scope",
+			wantDescription: "Deleting is disabled",
+		},
+		{
+			name: "deny policy lets other calls through",
+			policies: []api.Policy{
+				{Name: "no-delete", Expression: `mcp.tool == "delete"`, Effect: "deny"},
+			},
+			body:       callHello,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "allow policy denies calls not matching it",
+			policies: []api.Policy{
+				{Name: "only-alice", Expression: `payload.sub == "bob"`, Effect: "allow"},
+			},
+			body:            listTools,
+			wantStatus:      http.StatusForbidden,
+			wantError:       "insufficient_scope",
+			wantDescription: denials[denialReasonPolicyDenied].description,
+		},
+		{
+			name: "denied call can not hide in a batch behind an allowed one",
+			policies: []api.Policy{
+				{Name: "no-delete", Expression: `mcp.tool == "delete"`, Effect: "deny"},
+			},
+			body:       "[" + callHello + "," + callDelete + "]",
+			wantStatus: http.StatusForbidden,
+			wantError:  "insufficient_scope",
+		},
+		{
+			name: "policy in dry-run does not deny",
+			policies: []api.Policy{
+				{Name: "no-delete", Expression: `mcp.tool == "delete"`, Effect: "deny", DryRun: true},
+			},
+			body:       callDelete,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "broken policy in dry-run does not deny",
+			policies: []api.Policy{
+				{Name: "broken", Expression: `payload.missing == "value"`, Effect: "deny", DryRun: true},
+			},
+			body:       listTools,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "broken policy is an internal error",
+			policies: []api.Policy{
+				{Name: "broken", Expression: `payload.missing == "value"`, Effect: "deny"},
+			},
+			body:       listTools,
+			wantStatus: http.StatusInternalServerError,
+			wantError:  "server_error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newTestJWTConfig(server.URL)
+			config.Middleware.JWT.Validation.Local.AllowConditions = tt.allowConditions
+			config.Middleware.JWT.Validation.Policies = tt.policies
+			mw, _ := newTestJWTValidationMiddleware(t, config)
+
+			req := newTestMCPRequest(signTestToken(t, key, "first", newTestClaims()), tt.body)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			recorder := serveTestRequest(mw, req)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if tt.wantError == "" {
+				return
+			}
+
+			var body DenialResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatalf("failed decoding denial body '%s': %s", recorder.Body.String(), err.Error())
+			}
+			if body.Error != tt.wantError {
+				t.Errorf("error = %s, want %s", body.Error, tt.wantError)
+			}
+			if tt.wantDescription != "" && body.ErrorDescription != tt.wantDescription {
+				t.Errorf("error description = %s, want %s", body.ErrorDescription, tt.wantDescription)
+			}
+		})
+	}
+}
+
+func TestJWTValidationMiddlewarePoliciesKeepBody(t *testing.T) {
+	key, jwk := newTestRSAKey(t, "first")
+	server := newTestJWKSServer(t, jwk)
+
+	config := newTestJWTConfig(server.URL)
+	config.Middleware.JWT.Validation.Policies = []api.Policy{
+		{Name: "no-delete", Expression: `mcp.tool == "delete"`, Effect: "deny"},
+	}
+	mw, _ := newTestJWTValidationMiddleware(t, config)
+
+	// Bodies are read for the policies, and must reach the next handlers untouched
+	for _, body := range []string{
+		`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"hello"}}`,
+		strings.Repeat(" ", policyMaxBodySize) + `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`,
+	} {
+		var gotBody []byte
+		next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			gotBody, _ = io.ReadAll(req.Body)
+		})
+
+		recorder := httptest.NewRecorder()
+		mw.Middleware(next).ServeHTTP(recorder, newTestMCPRequest(signTestToken(t, key, "first", newTestClaims()), body))
+
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+		}
+		if string(gotBody) != body {
+			t.Errorf("body reaching the next handler has %d bytes, want %d", len(gotBody), len(body))
+		}
+	}
+}
+
+func TestGetMCPCalls(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		want        []map[string]string
+	}{
+		{
+			name:        "single tool call",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"hello"}}`,
+			want:        []map[string]string{{"method": "tools/call", "tool": "hello"}},
+		},
+		{
+			name:        "batched calls",
+			method:      http.MethodPost,
+			contentType: "application/json; charset=utf-8",
+			body:        ` [{"method":"tools/list"},{"method":"tools/call","params":{"name":"hello"}}]`,
+			want: []map[string]string{
+				{"method": "tools/list", "tool": ""},
+				{"method": "tools/call", "tool": "hello"},
+			},
+		},
+		{
+			name:        "tool name is only taken from tool calls",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        `{"method":"prompts/get","params":{"name":"hello"}}`,
+			want:        []map[string]string{{"method": "prompts/get", "tool": ""}},
+		},
+		{
+			name:        "other content types carry no call",
+			method:      http.MethodPost,
+			contentType: "text/plain",
+			body:        `{"method":"tools/call","params":{"name":"hello"}}`,
+			want:        []map[string]string{{"method": "", "tool": ""}},
+		},
+		{
+			name:        "malformed body carries no call",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        `{"method":`,
+			want:        []map[string]string{{"method": "", "tool": ""}},
+		},
+		{
+			name:   "GET requests carry no call",
+			method: http.MethodGet,
+			want:   []map[string]string{{"method": "", "tool": ""}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "https://mcp-go.example.com/mcp", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+
+			got := getMCPCalls(req)
+			if len(got) != len(tt.want) {
+				t.Fatalf("getMCPCalls() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i]["method"] != tt.want[i]["method"] || got[i]["tool"] != tt.want[i]["tool"] {
+					t.Errorf("getMCPCalls()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestJWTValidationMiddlewareReloadRejectsBrokenPolicies(t *testing.T) {
+	key, jwk := newTestRSAKey(t, "first")
+	server := newTestJWKSServer(t, jwk)
+
+	mw, _ := newTestJWTValidationMiddleware(t, newTestJWTConfig(server.URL))
+
+	newConfig := newTestJWTConfig(server.URL)
+	newConfig.Middleware.JWT.Validation.Policies = []api.Policy{
+		{Name: "broken", Expression: `payload.sub ==`, Effect: "deny"},
+	}
+	if _, err := mw.reloadConfig(newConfig); err == nil || !strings.Contains(err.Error(), "'broken'") {
+		t.Fatalf("reload error = %v, want the broken policy to be named", err)
+	}
+
+	// The previous policies keep applying
+	recorder := serveTestRequest(mw, newTestRequest(signTestToken(t, key, "first", newTestClaims())))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,504 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	//
+	"mcp-go/api"
+
+	//
+	"github.com/golang-jwt/jwt/v5"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// defaultRevocationsReloadInterval is the time between checks for changes in the revocations file
+	defaultRevocationsReloadInterval = 10 * time.Second
+
+	// defaultRevocationTTL is the lifetime of the revocations added through the admin endpoint without 'expires_at'.
+	// It covers the lifetime of the development tokens
+	defaultRevocationTTL = 24 * time.Hour
+
+	// revocationsMaxBodySize is the maximum size of the revocations sent to the admin endpoint
+	revocationsMaxBodySize = 1 << 16
+
+	// Places the revocations come from
+	revocationSourceFile  = "file"
+	revocationSourceAdmin = "admin"
+)
+
+var (
+	errTokenRevoked       = errors.New("token is revoked")
+	errRevocationsNotRead = errors.New("revocations file not read yet")
+)
+
+// revocationEntry represents revoked credentials: a token by its 'jti', every token of a subject, or the tokens
+// issued before a moment, for a subject or for everyone. Fields are combined, and 'issuer' narrows the entry
+// to the tokens of an issuer. Entries are ignored after 'expires_at', when present
+type revocationEntry struct {
+	ID           string    `yaml:"id,omitempty" json:"id,omitempty"`
+	JTI          string    `yaml:"jti,omitempty" json:"jti,omitempty"`
+	Subject      string    `yaml:"subject,omitempty" json:"subject,omitempty"`
+	Issuer       string    `yaml:"issuer,omitempty" json:"issuer,omitempty"`
+	IssuedBefore time.Time `yaml:"issued_before,omitempty" json:"issued_before,omitzero"`
+	ExpiresAt    time.Time `yaml:"expires_at,omitempty" json:"expires_at,omitzero"`
+	Reason       string    `yaml:"reason,omitempty" json:"reason,omitempty"`
+	Source       string    `yaml:"-" json:"source"`
+}
+
+// revocationsFile represents the content of the revocations file
+type revocationsFile struct {
+	Revocations []revocationEntry `yaml:"revocations"`
+}
+
+// RevocationsResponse represents the JSON body listing the revocations in the admin endpoint
+type RevocationsResponse struct {
+	Revocations []revocationEntry `json:"revocations"`
+}
+
+// validate checks the entry revokes something. Entries only narrowed by issuer are rejected,
+// as revoking every token of an issuer is done by not trusting it
+func (e *revocationEntry) validate() error {
+	if e.JTI == "" && e.Subject == "" && e.IssuedBefore.IsZero() {
+		return fmt.Errorf("at least one of 'jti', 'subject' or 'issued_before' is required")
+	}
+	return nil
+}
+
+// expired returns whether the entry stopped applying
+func (e *revocationEntry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && now.After(e.ExpiresAt)
+}
+
+// matches returns whether the entry revokes the credentials with the given claims, authenticated with the given method.
+// Tokens without 'iat' can not prove they were issued after a cut-off, so cut-offs apply to them.
+// API keys are not issued tokens, so cut-offs never apply to them: they are revoked by subject or removed from the config
+func (e *revocationEntry) matches(claims jwt.MapClaims, authMethod string) bool {
+	if e.Issuer != "" {
+		if issuer, _ := claims.GetIssuer(); issuer != e.Issuer {
+			return false
+		}
+	}
+
+	if e.JTI != "" {
+		if jti, _ := claims["jti"].(string); jti != e.JTI {
+			return false
+		}
+	}
+
+	if e.Subject != "" {
+		if subject, _ := claims.GetSubject(); subject != e.Subject {
+			return false
+		}
+	}
+
+	if !e.IssuedBefore.IsZero() {
+		if authMethod == AuthMethodAPIKey {
+			return false
+		}
+
+		issuedAt, err := claims.GetIssuedAt()
+		if err == nil && issuedAt != nil && !issuedAt.Before(e.IssuedBefore) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// revocationList keeps the revoked credentials, from the file and from the admin endpoint.
+// Entries added through the endpoint live in memory, so they are lost on restarts and not shared between replicas
+type revocationList struct {
+	mutex        sync.RWMutex
+	fileEntries  []revocationEntry
+	adminEntries []revocationEntry
+
+	// Entries indexed by the claim they are looked up with: 'jti', subject, or none for global cut-offs
+	byJTI     map[string][]revocationEntry
+	bySubject map[string][]revocationEntry
+	cutoffs   []revocationEntry
+
+	// File stuff
+	filePath string
+	fileHash []byte
+}
+
+func newRevocationList() *revocationList {
+	return &revocationList{}
+}
+
+// check returns an error when some entry revokes the credentials with the given claims, authenticated with the given method
+func (l *revocationList) check(claims map[string]any, authMethod string) error {
+	mapClaims := jwt.MapClaims(claims)
+	jti, _ := claims["jti"].(string)
+	subject, _ := mapClaims.GetSubject()
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	now := time.Now()
+	for _, entries := range [][]revocationEntry{l.byJTI[jti], l.bySubject[subject], l.cutoffs} {
+		for _, entry := range entries {
+			if !entry.expired(now) && entry.matches(mapClaims, authMethod) {
+				return fmt.Errorf("%w: jti '%s', subject '%s'", errTokenRevoked, jti, subject)
+			}
+		}
+	}
+	return nil
+}
+
+// list returns the entries still applying
+func (l *revocationList) list() []revocationEntry {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	now := time.Now()
+	entries := []revocationEntry{}
+	for _, entry := range slices.Concat(l.fileEntries, l.adminEntries) {
+		if !entry.expired(now) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// add appends an entry managed through the admin endpoint
+func (l *revocationList) add(entry revocationEntry) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.adminEntries = append(l.adminEntries, entry)
+	l.reindex()
+}
+
+// remove deletes an entry managed through the admin endpoint, returning whether it was found
+func (l *revocationList) remove(id string) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	index := slices.IndexFunc(l.adminEntries, func(entry revocationEntry) bool {
+		return entry.ID == id
+	})
+	if index < 0 {
+		return false
+	}
+
+	l.adminEntries = slices.Delete(l.adminEntries, index, index+1)
+	l.reindex()
+	return true
+}
+
+// prune removes the expired entries added through the admin endpoint. Expired entries from the file
+// are kept, as they are there until someone edits it, but they are ignored anyway
+func (l *revocationList) prune() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	previousLength := len(l.adminEntries)
+	l.adminEntries = slices.DeleteFunc(l.adminEntries, func(entry revocationEntry) bool {
+		return entry.expired(now)
+	})
+
+	pruned := previousLength - len(l.adminEntries)
+	if pruned > 0 {
+		l.reindex()
+	}
+	return pruned
+}
+
+// readFile loads the entries of the revocations file. Entries are replaced only when its content changes,
+// and the previous ones are kept when it is broken. An empty path removes the entries of the file
+func (l *revocationList) readFile(filePath string) error {
+	if filePath == "" {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+
+		if l.filePath == "" {
+			return nil
+		}
+
+		l.fileEntries, l.filePath, l.fileHash = nil, "", nil
+		l.reindex()
+		return nil
+	}
+
+	fileBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed reading revocations file: %s", err.Error())
+	}
+
+	fileHash := sha256.Sum256(fileBytes)
+
+	l.mutex.RLock()
+	unchanged := l.filePath == filePath && bytes.Equal(fileHash[:], l.fileHash)
+	l.mutex.RUnlock()
+
+	if unchanged {
+		return nil
+	}
+
+	var content revocationsFile
+	if err := yaml.Unmarshal(fileBytes, &content); err != nil {
+		return fmt.Errorf("failed decoding revocations file: %s", err.Error())
+	}
+
+	for i := range content.Revocations {
+		if err := content.Revocations[i].validate(); err != nil {
+			return fmt.Errorf("revocation %d of the file: %s", i, err.Error())
+		}
+		content.Revocations[i].Source = revocationSourceFile
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.fileEntries = content.Revocations
+	l.filePath = filePath
+	l.fileHash = fileHash[:]
+	l.reindex()
+	return nil
+}
+
+// fileRead returns an error when the revocations file was never read
+func (l *revocationList) fileRead(filePath string) error {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	if l.filePath != filePath {
+		return errRevocationsNotRead
+	}
+	return nil
+}
+
+// reindex builds the indexes used to look up the entries. It must be called with the mutex held
+func (l *revocationList) reindex() {
+	l.byJTI = map[string][]revocationEntry{}
+	l.bySubject = map[string][]revocationEntry{}
+	l.cutoffs = nil
+
+	for _, entry := range slices.Concat(l.fileEntries, l.adminEntries) {
+		switch {
+		case entry.JTI != "":
+			l.byJTI[entry.JTI] = append(l.byJTI[entry.JTI], entry)
+		case entry.Subject != "":
+			l.bySubject[entry.Subject] = append(l.bySubject[entry.Subject], entry)
+		default:
+			l.cutoffs = append(l.cutoffs, entry)
+		}
+	}
+}
+
+// isRevoked checks the claims of credentials authenticated with the given method against the revocations,
+// when they are enabled. The request is rejected when they are revoked
+func (mw *JWTValidationMiddleware) isRevoked(rw http.ResponseWriter, req *http.Request, claims map[string]any, authMethod string) bool {
+	if !mw.dependencies.AppCtx.Config().Middleware.JWT.Validation.Revocation.Enabled {
+		return false
+	}
+
+	if err := mw.revocations.check(claims, authMethod); err != nil {
+		mw.deny(rw, req, denialReasonTokenRevoked, err)
+		return true
+	}
+	return false
+}
+
+// isForwardedTokenRevoked checks the token validated by the proxy in front against the revocations,
+// as the proxy does not know about them. The request is rejected when it is revoked
+func (mw *JWTValidationMiddleware) isForwardedTokenRevoked(rw http.ResponseWriter, req *http.Request) bool {
+	validationConfig := mw.dependencies.AppCtx.Config().Middleware.JWT.Validation
+	if !validationConfig.Revocation.Enabled || validationConfig.ForwardedHeader == "" {
+		return false
+	}
+
+	forwardedToken := req.Header.Get(validationConfig.ForwardedHeader)
+	if forwardedToken == "" {
+		return false
+	}
+
+	claims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(forwardedToken, claims)
+	if err != nil {
+		mw.deny(rw, req, denialReasonMalformedToken, err)
+		return true
+	}
+
+	return mw.isRevoked(rw, req, claims, AuthMethodForwarded)
+}
+
+// watchRevocations reads the revocations file from time to time, and removes the expired entries.
+// Changes in the config are picked up on each round
+func (mw *JWTValidationMiddleware) watchRevocations() {
+	for {
+		revocationConfig := mw.dependencies.AppCtx.Config().Middleware.JWT.Validation.Revocation
+
+		reloadInterval := revocationConfig.ReloadInterval
+		if reloadInterval <= 0 {
+			reloadInterval = defaultRevocationsReloadInterval
+		}
+
+		select {
+		case <-mw.dependencies.AppCtx.Context.Done():
+			return
+		case <-time.After(reloadInterval):
+		}
+
+		mw.refreshRevocations()
+
+		if pruned := mw.revocations.prune(); pruned > 0 {
+			mw.dependencies.AppCtx.Logger.Info("expired revocations removed", "count", pruned)
+		}
+	}
+}
+
+// refreshRevocations reads the revocations file of the current config, keeping the previous entries on errors
+func (mw *JWTValidationMiddleware) refreshRevocations() {
+	revocationConfig := mw.dependencies.AppCtx.Config().Middleware.JWT.Validation.Revocation
+
+	filePath := revocationConfig.File
+	if !revocationConfig.Enabled {
+		filePath = ""
+	}
+
+	if err := mw.revocations.readFile(filePath); err != nil {
+		mw.dependencies.AppCtx.Logger.Error("failed reading revocations, keeping previous ones",
+			"file", filePath, "error", err.Error())
+	}
+}
+
+// checkRevocationsRead verifies the revocations file was read, so revoked tokens are not accepted meanwhile
+func (mw *JWTValidationMiddleware) checkRevocationsRead(ctx context.Context) error {
+	revocationConfig := mw.dependencies.AppCtx.Config().Middleware.JWT.Validation.Revocation
+	if !revocationConfig.Enabled || revocationConfig.File == "" {
+		return nil
+	}
+	return mw.revocations.fileRead(revocationConfig.File)
+}
+
+// HandleRevocations manages the revocations at runtime. It must be served behind this middleware,
+// so callers are authenticated, and they need the scopes and groups configured for the admin endpoint:
+//   - GET lists the revocations still applying
+//   - POST adds a revocation, given as JSON. It expires after 'default_ttl' unless 'expires_at' is given
+//   - DELETE removes the revocation added through this endpoint with the 'id' query parameter
+func (mw *JWTValidationMiddleware) HandleRevocations(rw http.ResponseWriter, req *http.Request) {
+	revocationConfig := mw.dependencies.AppCtx.Config().Middleware.JWT.Validation.Revocation
+	if !revocationConfig.Enabled || !revocationConfig.Admin.Enabled {
+		http.NotFound(rw, req)
+		return
+	}
+
+	// Only the principals authenticated by this middleware are trusted. The tokens forwarded with strategy 'external'
+	// are not verified here, so they can not manage revocations
+	principal, ok := PrincipalFromContext(req.Context())
+	if !ok {
+		mw.deny(rw, req, denialReasonMissingHeader, nil)
+		return
+	}
+
+	if !isRevocationsAdmin(revocationConfig.Admin, principal) {
+		mw.deny(rw, req, denialReasonAdminDenied, fmt.Errorf("subject '%s' can not manage revocations", principal.Subject))
+		return
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		writeRevocationsResponse(rw, http.StatusOK, RevocationsResponse{Revocations: mw.revocations.list()})
+
+	case http.MethodPost:
+		var entry revocationEntry
+		err := json.NewDecoder(io.LimitReader(req.Body, revocationsMaxBodySize)).Decode(&entry)
+		if err != nil {
+			writeRevocationsError(rw, http.StatusBadRequest, fmt.Sprintf("invalid revocation: %s", err.Error()))
+			return
+		}
+
+		if err := entry.validate(); err != nil {
+			writeRevocationsError(rw, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		defaultTTL := revocationConfig.Admin.DefaultTTL
+		if defaultTTL <= 0 {
+			defaultTTL = defaultRevocationTTL
+		}
+
+		if entry.ExpiresAt.IsZero() {
+			entry.ExpiresAt = time.Now().Add(defaultTTL)
+		}
+		if entry.expired(time.Now()) {
+			writeRevocationsError(rw, http.StatusBadRequest, "'expires_at' is in the past")
+			return
+		}
+
+		entry.ID = rand.Text()
+		entry.Source = revocationSourceAdmin
+		mw.revocations.add(entry)
+
+		mw.dependencies.AppCtx.Logger.Info("revocation added", "admin", principal.Subject, "id", entry.ID,
+			"jti", entry.JTI, "subject", entry.Subject, "issuer", entry.Issuer, "expires_at", entry.ExpiresAt, "reason", entry.Reason)
+		writeRevocationsResponse(rw, http.StatusCreated, entry)
+
+	case http.MethodDelete:
+		id := req.URL.Query().Get("id")
+		if id == "" {
+			writeRevocationsError(rw, http.StatusBadRequest, "query parameter 'id' is required")
+			return
+		}
+
+		if !mw.revocations.remove(id) {
+			writeRevocationsError(rw, http.StatusNotFound, fmt.Sprintf("revocation '%s' not found among the ones added through this endpoint", id))
+			return
+		}
+
+		mw.dependencies.AppCtx.Logger.Info("revocation removed", "admin", principal.Subject, "id", id)
+		rw.WriteHeader(http.StatusNoContent)
+
+	default:
+		rw.Header().Set("Allow", "GET, POST, DELETE")
+		writeRevocationsError(rw, http.StatusMethodNotAllowed, fmt.Sprintf("method '%s' is not allowed", req.Method))
+	}
+}
+
+// isRevocationsAdmin returns whether the principal is granted every scope, and belongs to some group, of the admin config
+func isRevocationsAdmin(adminConfig api.JWTValidationRevocationAdminConfig, principal *Principal) bool {
+	for _, scope := range adminConfig.Scopes {
+		if !slices.Contains(principal.Scopes, scope) {
+			return false
+		}
+	}
+
+	if len(adminConfig.Groups) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(adminConfig.Groups, func(group string) bool {
+		return slices.Contains(principal.Groups, group)
+	})
+}
+
+// writeRevocationsResponse answers the admin endpoint with a JSON body
+func writeRevocationsResponse(rw http.ResponseWriter, status int, body any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(body)
+}
+
+// writeRevocationsError answers the admin endpoint with an error, shaped as the ones of rejected requests
+func writeRevocationsError(rw http.ResponseWriter, status int, description string) {
+	writeRevocationsResponse(rw, status, DenialResponse{
+		Error:            "invalid_request",
+		ErrorDescription: description,
+	})
+}
@@ -0,0 +1,377 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	//
+	"mcp-go/api"
+
+	//
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeTestRevocationsFile writes the revocations into a file of a temporary directory, returning its path
+func writeTestRevocationsFile(t *testing.T, content string) string {
+	t.Helper()
+
+	filePath := filepath.Join(t.TempDir(), "revocations.yaml")
+	if err := os.WriteFile(filePath, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed writing revocations file: %s", err.Error())
+	}
+	return filePath
+}
+
+func TestRevocationEntryMatches(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		entry      revocationEntry
+		claims     jwt.MapClaims
+		authMethod string
+		want       bool
+	}{
+		{
+			name:   "token revoked by jti",
+			entry:  revocationEntry{JTI: "token-1"},
+			claims: jwt.MapClaims{"jti": "token-1", "sub": "alice"},
+			want:   true,
+		},
+		{
+			name:   "token with another jti",
+			entry:  revocationEntry{JTI: "token-1"},
+			claims: jwt.MapClaims{"jti": "token-2", "sub": "alice"},
+		},
+		{
+			name:   "token revoked by subject",
+			entry:  revocationEntry{Subject: "alice"},
+			claims: jwt.MapClaims{"jti": "token-1", "sub": "alice"},
+			want:   true,
+		},
+		{
+			name:   "entry narrowed to another issuer",
+			entry:  revocationEntry{Subject: "alice", Issuer: "https://other.example.com"},
+			claims: jwt.MapClaims{"iss": testIssuer, "sub": "alice"},
+		},
+		{
+			name:   "fields are combined",
+			entry:  revocationEntry{JTI: "token-1", Subject: "bob"},
+			claims: jwt.MapClaims{"jti": "token-1", "sub": "alice"},
+		},
+		{
+			name:   "token issued before the cut-off",
+			entry:  revocationEntry{IssuedBefore: now},
+			claims: jwt.MapClaims{"sub": "alice", "iat": float64(now.Add(-time.Hour).Unix())},
+			want:   true,
+		},
+		{
+			name:   "token issued after the cut-off",
+			entry:  revocationEntry{IssuedBefore: now.Add(-time.Hour)},
+			claims: jwt.MapClaims{"sub": "alice", "iat": float64(now.Unix())},
+		},
+		{
+			name:   "token without 'iat' can not prove it was issued after the cut-off",
+			entry:  revocationEntry{IssuedBefore: now},
+			claims: jwt.MapClaims{"sub": "alice"},
+			want:   true,
+		},
+		{
+			name:       "cut-offs do not apply to API keys",
+			entry:      revocationEntry{IssuedBefore: now},
+			claims:     jwt.MapClaims{"sub": "sha-service"},
+			authMethod: AuthMethodAPIKey,
+		},
+		{
+			name:       "API keys are revoked by subject",
+			entry:      revocationEntry{Subject: "sha-service"},
+			claims:     jwt.MapClaims{"sub": "sha-service"},
+			authMethod: AuthMethodAPIKey,
+			want:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authMethod := tt.authMethod
+			if authMethod == "" {
+				authMethod = AuthMethodJWT
+			}
+
+			if got := tt.entry.matches(tt.claims, authMethod); got != tt.want {
+				t.Errorf("matches() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWTValidationMiddlewareRevocations(t *testing.T) {
+	key, jwk := newTestRSAKey(t, "first")
+	server := newTestJWKSServer(t, jwk)
+
+	now := time.Now().UTC()
+	revocations := `
+revocations:
+  - jti: revoked-token
+  - subject: mallory
+  - subject: argon-bot
+  - subject: sha-service
+    issuer: https://other.example.com
+  - issued_before: ` + now.Add(-time.Minute).Format(time.RFC3339) + `
+  - subject: bob
+    expires_at: ` + now.Add(-time.Minute).Format(time.RFC3339) + `
+`
+
+	tests := []struct {
+		name       string
+		strategy   string
+		claims     jwt.MapClaims
+		apiKey     string
+		wantStatus int
+	}{
+		{
+			name:       "token revoked by jti is rejected",
+			claims:     jwt.MapClaims{"jti": "revoked-token"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "token revoked by subject is rejected",
+			claims:     jwt.MapClaims{"sub": "mallory"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "token issued before the cut-off is rejected",
+			claims:     jwt.MapClaims{"iat": now.Add(-time.Hour).Unix()},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "token issued after the cut-off is accepted",
+			claims:     jwt.MapClaims{"jti": "another-token"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "expired revocation is ignored",
+			claims:     jwt.MapClaims{"sub": "bob"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "token validated by the proxy in front is checked too",
+			strategy:   "external",
+			claims:     jwt.MapClaims{"sub": "mallory"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "API key is not revoked by the cut-off",
+			apiKey:     "sha-bot.s3cr3t",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "API key is revoked by subject",
+			apiKey:     "argon-bot.s3cr3t",
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newTestJWTConfig(server.URL)
+			if tt.strategy != "" {
+				config.Middleware.JWT.Validation.Strategy = tt.strategy
+			}
+			config.Middleware.JWT.Validation.APIKey = newTestAPIKeyConfig(t)
+			config.Middleware.JWT.Validation.Revocation = api.JWTValidationRevocationConfig{
+				Enabled: true,
+				File:    writeTestRevocationsFile(t, revocations),
+			}
+			mw, denialLog := newTestJWTValidationMiddleware(t, config)
+
+			claims := newTestClaims()
+			for name, value := range tt.claims {
+				claims[name] = value
+			}
+			token := signTestToken(t, key, "first", claims)
+
+			req := newTestRequest(token)
+			switch {
+			case tt.apiKey != "":
+				req = newTestRequest("")
+				req.Header.Set(defaultAPIKeyHeader, tt.apiKey)
+			case tt.strategy == "external":
+				req.Header.Set("X-Validated-Jwt", token)
+			}
+
+			recorder := serveTestRequest(mw, req)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if tt.wantStatus == http.StatusOK {
+				return
+			}
+
+			if got := denialLog.last(); got != denialReasonTokenRevoked {
+				t.Errorf("denial reason = %s, want %s", got, denialReasonTokenRevoked)
+			}
+		})
+	}
+}
+
+func TestJWTValidationMiddlewareHandleRevocations(t *testing.T) {
+	key, jwk := newTestRSAKey(t, "first")
+	server := newTestJWKSServer(t, jwk)
+
+	config := newTestJWTConfig(server.URL)
+	config.Middleware.JWT.Validation.Revocation = api.JWTValidationRevocationConfig{
+		Enabled: true,
+		Admin: api.JWTValidationRevocationAdminConfig{
+			Enabled: true,
+			Scopes:  []string{"revocations:admin"},
+		},
+	}
+	mw, _ := newTestJWTValidationMiddleware(t, config)
+
+	adminClaims := newTestClaims()
+	adminClaims["sub"] = "admin"
+	adminClaims["scope"] = "revocations:admin"
+	adminToken := signTestToken(t, key, "first", adminClaims)
+
+	// The endpoint is served behind the middleware, as it is by the server
+	serveAdminRequest := func(method string, target string, token string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "https://mcp-go.example.com/admin/revocations"+target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		recorder := httptest.NewRecorder()
+		mw.Middleware(http.HandlerFunc(mw.HandleRevocations)).ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	revokedClaims := newTestClaims()
+	revokedClaims["jti"] = "leaked-token"
+	revokedToken := signTestToken(t, key, "first", revokedClaims)
+
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{
+			name:       "caller without the admin scopes is forbidden",
+			method:     http.MethodGet,
+			token:      signTestToken(t, key, "first", newTestClaims()),
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "revocation narrowed only by issuer is rejected",
+			method:     http.MethodPost,
+			token:      adminToken,
+			body:       `{"issuer":"https://issuer.example.com"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "revocation already expired is rejected",
+			method:     http.MethodPost,
+			token:      adminToken,
+			body:       `{"jti":"leaked-token","expires_at":"2000-01-01T00:00:00Z"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "malformed revocation is rejected",
+			method:     http.MethodPost,
+			token:      adminToken,
+			body:       `{"jti":`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "removal without id is rejected",
+			method:     http.MethodDelete,
+			token:      adminToken,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "other methods are not allowed",
+			method:     http.MethodPut,
+			token:      adminToken,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serveAdminRequest(tt.method, "", tt.token, tt.body)
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body: %s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+		})
+	}
+
+	t.Run("revocations are added, listed and removed", func(t *testing.T) {
+		recorder := serveAdminRequest(http.MethodPost, "", adminToken, `{"jti":"leaked-token","reason":"leaked"}`)
+		if recorder.Code != http.StatusCreated {
+			t.Fatalf("status adding = %d, want %d (body: %s)", recorder.Code, http.StatusCreated, recorder.Body.String())
+		}
+
+		var entry revocationEntry
+		if err := json.Unmarshal(recorder.Body.Bytes(), &entry); err != nil {
+			t.Fatalf("failed decoding revocation: %s", err.Error())
+		}
+		if entry.ID == "" || entry.ExpiresAt.IsZero() || entry.Source != revocationSourceAdmin {
+			t.Errorf("revocation = %+v, want an id, a default expiration and the admin source", entry)
+		}
+
+		if recorder = serveTestRequest(mw, newTestRequest(revokedToken)); recorder.Code != http.StatusUnauthorized {
+			t.Errorf("status of the revoked token = %d, want %d", recorder.Code, http.StatusUnauthorized)
+		}
+
+		var list RevocationsResponse
+		recorder = serveAdminRequest(http.MethodGet, "", adminToken, "")
+		if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil || len(list.Revocations) != 1 {
+			t.Errorf("listed revocations = %s, want the added one", recorder.Body.String())
+		}
+
+		if recorder = serveAdminRequest(http.MethodDelete, "?id="+entry.ID, adminToken, ""); recorder.Code != http.StatusNoContent {
+			t.Fatalf("status removing = %d, want %d", recorder.Code, http.StatusNoContent)
+		}
+		if recorder = serveTestRequest(mw, newTestRequest(revokedToken)); recorder.Code != http.StatusOK {
+			t.Errorf("status of the token once unrevoked = %d, want %d", recorder.Code, http.StatusOK)
+		}
+		if recorder = serveAdminRequest(http.MethodDelete, "?id="+entry.ID, adminToken, ""); recorder.Code != http.StatusNotFound {
+			t.Errorf("status removing twice = %d, want %d", recorder.Code, http.StatusNotFound)
+		}
+	})
+}
+
+func TestJWTValidationMiddlewareHandleRevocationsExternal(t *testing.T) {
+	key, jwk := newTestRSAKey(t, "first")
+	server := newTestJWKSServer(t, jwk)
+
+	config := newTestJWTConfig(server.URL)
+	config.Middleware.JWT.Validation.Strategy = "external"
+	config.Middleware.JWT.Validation.Revocation = api.JWTValidationRevocationConfig{
+		Enabled: true,
+		Admin:   api.JWTValidationRevocationAdminConfig{Enabled: true},
+	}
+	mw, _ := newTestJWTValidationMiddleware(t, config)
+
+	// Forwarded tokens are not verified by this server, so anyone reaching it directly could forge them
+	claims := newTestClaims()
+	claims["sub"] = "admin"
+	req := httptest.NewRequest(http.MethodPost, "https://mcp-go.example.com/admin/revocations", strings.NewReader(`{"subject":"alice"}`))
+	req.Header.Set("X-Validated-Jwt", signTestToken(t, key, "first", claims))
+
+	recorder := httptest.NewRecorder()
+	mw.Middleware(http.HandlerFunc(mw.HandleRevocations)).ServeHTTP(recorder, req)
+
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", recorder.Code, http.StatusUnauthorized)
+	}
+	if got := mw.revocations.list(); len(got) != 0 {
+		t.Errorf("revocations = %v, want none", got)
+	}
+}
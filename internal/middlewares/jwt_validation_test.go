@@ -0,0 +1,342 @@
+package middlewares
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	//
+	"mcp-go/api"
+
+	//
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestJWTValidationMiddlewareClaims(t *testing.T) {
+	key, jwk := newTestRSAKey(t, "first")
+	otherKey, _ := newTestRSAKey(t, "first")
+
+	server := newTestJWKSServer(t, jwk)
+
+	tests := []struct {
+		name       string
+		configure  func(config *api.JWTValidationLocalConfig)
+		claims     func(claims jwt.MapClaims)
+		signingKey *rsa.PrivateKey
+		kid        string
+		noToken    bool
+		wantStatus int
+		wantError  string
+		wantReason string
+	}{
+		{
+			name:       "valid token is accepted with its principal",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "missing token is challenged without error",
+			noToken:    true,
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "unauthorized",
+		},
+		{
+			name: "untrusted issuer is rejected",
+			claims: func(claims jwt.MapClaims) {
+				claims["iss"] = "https://attacker.example.com"
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_token",
+			wantReason: denialReasonInvalidIssuer,
+		},
+		{
+			name: "token for another audience is rejected",
+			claims: func(claims jwt.MapClaims) {
+				claims["aud"] = "another-resource"
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_token",
+			wantReason: denialReasonInvalidAudience,
+		},
+		{
+			name: "audience is accepted among several",
+			claims: func(claims jwt.MapClaims) {
+				claims["aud"] = []string{"another-resource", testAudience}
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "expired token is rejected",
+			claims: func(claims jwt.MapClaims) {
+				claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_token",
+			wantReason: denialReasonExpired,
+		},
+		{
+			name: "expired token is accepted within the leeway",
+			configure: func(config *api.JWTValidationLocalConfig) {
+				config.Leeway = 2 * time.Minute
+			},
+			claims: func(claims jwt.MapClaims) {
+				claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "token not valid yet is rejected",
+			claims: func(claims jwt.MapClaims) {
+				claims["nbf"] = time.Now().Add(time.Minute).Unix()
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_token",
+			wantReason: denialReasonNotYetValid,
+		},
+		{
+			name: "token issued in the future is rejected",
+			claims: func(claims jwt.MapClaims) {
+				claims["iat"] = time.Now().Add(time.Minute).Unix()
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_token",
+			wantReason: denialReasonNotYetValid,
+		},
+		{
+			name: "token lacking a required claim is rejected",
+			configure: func(config *api.JWTValidationLocalConfig) {
+				config.RequiredClaims = []string{"email"}
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_token",
+			wantReason: denialReasonMissingClaim,
+		},
+		{
+			name: "token issued too long ago is rejected",
+			configure: func(config *api.JWTValidationLocalConfig) {
+				config.MaxTokenAge = time.Minute
+			},
+			claims: func(claims jwt.MapClaims) {
+				claims["iat"] = time.Now().Add(-time.Hour).Unix()
+			},
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_token",
+			wantReason: denialReasonTokenTooOld,
+		},
+		{
+			name:       "token signed by another key with the same kid is rejected",
+			signingKey: otherKey,
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_token",
+			wantReason: denialReasonInvalidToken,
+		},
+		{
+			name:       "token with an unknown kid is rejected",
+			kid:        "random",
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_token",
+			wantReason: denialReasonUnknownKid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newTestJWTConfig(server.URL)
+			if tt.configure != nil {
+				tt.configure(&config.Middleware.JWT.Validation.Local)
+			}
+			mw, denialLog := newTestJWTValidationMiddleware(t, config)
+
+			claims := newTestClaims()
+			if tt.claims != nil {
+				tt.claims(claims)
+			}
+
+			signingKey, kid := key, "first"
+			if tt.signingKey != nil {
+				signingKey = tt.signingKey
+			}
+			if tt.kid != "" {
+				kid = tt.kid
+			}
+
+			var token string
+			if !tt.noToken {
+				token = signTestToken(t, signingKey, kid, claims)
+			}
+
+			recorder := serveTestRequest(mw, newTestRequest(token))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+
+			if tt.wantError != "" {
+				if got := getDenialError(t, recorder); got != tt.wantError {
+					t.Errorf("error = %s, want %s", got, tt.wantError)
+				}
+				if got := denialLog.last(); got != tt.wantReason {
+					t.Errorf("denial reason = %s, want %s", got, tt.wantReason)
+				}
+				return
+			}
+
+			if got := recorder.Body.String(); got != "alice" {
+				t.Errorf("principal subject = %s, want alice", got)
+			}
+		})
+	}
+}
+
+func TestJWTValidationMiddlewareAudienceDefaultsToResource(t *testing.T) {
+	key, jwk := newTestRSAKey(t, "first")
+	server := newTestJWKSServer(t, jwk)
+
+	config := newTestJWTConfig(server.URL)
+	config.Middleware.JWT.Validation.Local.Audiences = nil
+	config.OAuthProtectedResource.Resource = "https://mcp-go.example.com"
+	mw, _ := newTestJWTValidationMiddleware(t, config)
+
+	tests := []struct {
+		name       string
+		audience   string
+		wantStatus int
+	}{
+		{name: "token for the resource is accepted", audience: "https://mcp-go.example.com", wantStatus: http.StatusOK},
+		{name: "token for another resource is rejected", audience: testAudience, wantStatus: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := newTestClaims()
+			claims["aud"] = tt.audience
+
+			recorder := serveTestRequest(mw, newTestRequest(signTestToken(t, key, "first", claims)))
+			if recorder.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d (body: %s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetSigningMethod(t *testing.T) {
+	tests := []struct {
+		alg     string
+		want    jwt.SigningMethod
+		wantErr bool
+	}{
+		{alg: "RS256", want: jwt.SigningMethodRS256},
+		{alg: "RS384", want: jwt.SigningMethodRS384},
+		{alg: "RS512", want: jwt.SigningMethodRS512},
+		{alg: "PS256", want: jwt.SigningMethodPS256},
+		{alg: "PS384", want: jwt.SigningMethodPS384},
+		{alg: "PS512", want: jwt.SigningMethodPS512},
+		{alg: "ES256", want: jwt.SigningMethodES256},
+		{alg: "ES384", want: jwt.SigningMethodES384},
+		{alg: "ES512", want: jwt.SigningMethodES512},
+		{alg: "EdDSA", want: jwt.SigningMethodEdDSA},
+		{alg: "HS256", want: jwt.SigningMethodHS256},
+		{alg: "HS384", want: jwt.SigningMethodHS384},
+		{alg: "HS512", want: jwt.SigningMethodHS512},
+		{alg: "none", wantErr: true},
+		{alg: "Ed25519", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			got, err := getSigningMethod(tt.alg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getSigningMethod() error = %v, want error %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("getSigningMethod() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJWTValidationMiddlewareKeyTypes(t *testing.T) {
+	rsaKey, rsaJWK := newTestRSAKey(t, "rsa")
+	rsaJWK.Alg = ""
+	rs256Key, rs256JWK := newTestRSAKey(t, "rsa-rs256")
+	ecKey, ecJWK := newTestECKey(t, "ec")
+	edKey, edJWK := newTestEd25519Key(t, "okp")
+
+	hmacKey := []byte("0123456789abcdef0123456789abcdef")
+	hmacJWK := JWK{Kid: "oct", Kty: "oct", Use: "sig", K: base64.RawURLEncoding.EncodeToString(hmacKey)}
+
+	server := newTestJWKSServer(t, rsaJWK, rs256JWK, ecJWK, edJWK, hmacJWK)
+
+	tests := []struct {
+		name       string
+		configure  func(config *api.JWTValidationLocalConfig)
+		method     jwt.SigningMethod
+		key        any
+		kid        string
+		wantStatus int
+		wantReason string
+	}{
+		{name: "RSA-PSS with SHA-256 is accepted", method: jwt.SigningMethodPS256, key: rsaKey, kid: "rsa", wantStatus: http.StatusOK},
+		{name: "RSA-PSS with SHA-384 is accepted", method: jwt.SigningMethodPS384, key: rsaKey, kid: "rsa", wantStatus: http.StatusOK},
+		{name: "RSA-PSS with SHA-512 is accepted", method: jwt.SigningMethodPS512, key: rsaKey, kid: "rsa", wantStatus: http.StatusOK},
+		{name: "ECDSA is accepted", method: jwt.SigningMethodES256, key: ecKey, kid: "ec", wantStatus: http.StatusOK},
+		{name: "EdDSA with an OKP key is accepted", method: jwt.SigningMethodEdDSA, key: edKey, kid: "okp", wantStatus: http.StatusOK},
+		{
+			name:       "algorithm not matching the one of the key is rejected",
+			method:     jwt.SigningMethodPS256,
+			key:        rs256Key,
+			kid:        "rsa-rs256",
+			wantStatus: http.StatusUnauthorized,
+			wantReason: denialReasonInvalidToken,
+		},
+		{
+			name: "algorithm out of the allowlist is rejected",
+			configure: func(config *api.JWTValidationLocalConfig) {
+				config.Algorithms = []string{"PS256", "EdDSA"}
+			},
+			method:     jwt.SigningMethodES256,
+			key:        ecKey,
+			kid:        "ec",
+			wantStatus: http.StatusUnauthorized,
+			wantReason: denialReasonAlgorithmNotAllowed,
+		},
+		{
+			name:       "symmetric key is rejected unless allowed",
+			method:     jwt.SigningMethodHS256,
+			key:        hmacKey,
+			kid:        "oct",
+			wantStatus: http.StatusUnauthorized,
+			wantReason: denialReasonAlgorithmNotAllowed,
+		},
+		{
+			name: "symmetric key is accepted when allowed",
+			configure: func(config *api.JWTValidationLocalConfig) {
+				config.AllowSymmetricKeys = true
+			},
+			method:     jwt.SigningMethodHS256,
+			key:        hmacKey,
+			kid:        "oct",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newTestJWTConfig(server.URL)
+			if tt.configure != nil {
+				tt.configure(&config.Middleware.JWT.Validation.Local)
+			}
+			mw, denialLog := newTestJWTValidationMiddleware(t, config)
+
+			token := signTestTokenWithMethod(t, tt.method, tt.key, tt.kid, newTestClaims())
+
+			recorder := serveTestRequest(mw, newTestRequest(token))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if got := denialLog.last(); got != tt.wantReason {
+				t.Errorf("denial reason = %s, want %s", got, tt.wantReason)
+			}
+		})
+	}
+}
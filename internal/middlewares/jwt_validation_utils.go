@@ -1,21 +1,40 @@
 package middlewares
 
 import (
+	"context"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/elliptic"
 	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/big"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
+	//
+	"mcp-go/api"
+
 	//
 	"github.com/golang-jwt/jwt/v5"
 )
 
+var (
+	errUnknownKid          = errors.New("no matching 'kid' in JWKS")
+	errMalformedToken      = errors.New("malformed token: It must be like header.payload.signature")
+	errInvalidIssuer       = errors.New("token issuer is not trusted")
+	errInvalidAudience     = errors.New("token audience does not include this resource")
+	errMissingClaim        = errors.New("token lacks a required claim")
+	errTokenTooOld         = errors.New("token was issued too long ago")
+	errAlgorithmNotAllowed = errors.New("token algorithm is not allowed")
+)
+
 // JWKS represents a set (group) of several JWK
 type JWKS struct {
 	Keys []JWK `json:"keys"`
@@ -29,102 +48,79 @@ type JWK struct {
 	N string `json:"n,omitempty"` // RSA modulus
 	E string `json:"e,omitempty"` // RSA exponent
 
-	Crv string `json:"crv,omitempty"` // EC curve
-	X   string `json:"x,omitempty"`   // EC x coordinate
+	Crv string `json:"crv,omitempty"` // EC or OKP curve
+	X   string `json:"x,omitempty"`   // EC x coordinate, or OKP public key
 	Y   string `json:"y,omitempty"`   // EC y coordinate
 
 	K   string `json:"k,omitempty"` // Symmetric key (for HMAC)
 	Alg string `json:"alg"`
 	Use string `json:"use"`
-}
-
-// cacheJWKS obtains JWKS keys from remote, from time to time,
-// and keep internal cache reasonable up-to-date
-func (mw *JWTValidationMiddleware) cacheJWKS() {
 
-	// Bypass the cache thread when middleware is disabled by config
-	if !mw.dependencies.AppCtx.Config.Middleware.JWT.Enabled {
-		return
-	}
-
-	mw.dependencies.AppCtx.Logger.Info("JWKS cache daemon running for JWT auth middleware")
-
-	for {
-		var jwks JWKS
-
-		//
-		resp, err := http.Get(mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Local.JWKSUri)
-		if err != nil {
-			mw.dependencies.AppCtx.Logger.Error("failed getting JWKS from remote", "error", err.Error())
-			goto haveANap
-		}
-		defer resp.Body.Close()
-
-		//
-		if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
-			mw.dependencies.AppCtx.Logger.Error("failed decoding JWKS from remote", "error", err.Error())
-			goto haveANap
-		}
-
-		//
-		mw.mutex.Lock()
-		mw.jwks = &jwks
-		mw.mutex.Unlock()
-
-		// Don't be greedy, man
-	haveANap:
-		time.Sleep(mw.dependencies.AppCtx.Config.Middleware.JWT.Validation.Local.CacheInterval)
-	}
+	X5c     []string `json:"x5c,omitempty"`      // Certificate chain, the first one holding the key
+	X5tS256 string   `json:"x5t#S256,omitempty"` // SHA-256 thumbprint of the first certificate
 }
 
-func (mw *JWTValidationMiddleware) isTokenValid(token string) (bool, error) {
+// validateToken verifies a token against the trusted issuer in charge of it, which is chosen by the
+// unverified 'iss' claim and returned, so its own policies can be evaluated later
+func (mw *JWTValidationMiddleware) validateToken(ctx context.Context, token string) (*trustedIssuer, error) {
 	// Get JWT header
 	header, err := parseJWTHeader(token)
 	if err != nil {
-		return false, fmt.Errorf("error parsing token: %v", err)
+		return nil, fmt.Errorf("error parsing token: %w", err)
 	}
 
 	// Retrieve 'Kid' and 'Alg' from token's header
 	kid, ok := header["kid"].(string)
 	if !ok {
-		return false, fmt.Errorf("jwt header 'kid' field not found")
+		return nil, fmt.Errorf("jwt header 'kid' field not found")
 	}
 
 	alg, ok := header["alg"].(string)
 	if !ok {
-		return false, fmt.Errorf("jwt header 'alg' field not found")
+		return nil, fmt.Errorf("jwt header 'alg' field not found")
 	}
 
-	// Obtain JWKS from the middleware cache
-	mw.mutex.Lock()
-	jwks := mw.jwks
-	mw.mutex.Unlock()
+	// Choose the issuer by the claimed one. The signature checked later proves the claim
+	unverifiedClaims := jwt.MapClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(token, unverifiedClaims)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	tokenIssuer, _ := unverifiedClaims.GetIssuer()
 
-	// Look for the published key with the same Kid as the token
-	var matchingKey *JWK
-	for _, key := range jwks.Keys {
-		if key.Kid == kid && (key.Use == "" || key.Use == "sig") {
-			matchingKey = &key
-			break
-		}
+	issuer := mw.selectTrustedIssuer(tokenIssuer)
+	if issuer == nil {
+		return nil, fmt.Errorf("%w: '%s'", errInvalidIssuer, tokenIssuer)
 	}
 
-	if matchingKey == nil {
-		return false, fmt.Errorf("no matching 'kid' in JWKS")
+	allowedAlgorithms := issuer.allowedAlgorithms()
+	if len(allowedAlgorithms) > 0 && !slices.Contains(allowedAlgorithms, alg) {
+		return nil, fmt.Errorf("%w: '%s'", errAlgorithmNotAllowed, alg)
 	}
 
-	// Algorithm must match
-	if matchingKey.Alg != "" && matchingKey.Alg != alg {
-		return false, fmt.Errorf("algorithm missmatch")
+	// Symmetric keys published in a JWKS are known by anyone, so they need to be trusted explicitly
+	if strings.HasPrefix(alg, "HS") && !issuer.config.allowSymmetricKeys {
+		return nil, fmt.Errorf("%w: '%s' requires symmetric keys to be allowed", errAlgorithmNotAllowed, alg)
 	}
 
-	// Convert JWK to a public key of corresponding type (RSA, EC, etc.)
-	publicKey, err := jwkToKey(matchingKey)
+	// Look for the published key with the same Kid as the token
+	matchingKey, err := issuer.lookupKey(ctx, kid)
 	if err != nil {
-		return false, fmt.Errorf("error converting JWK to public key")
+		return nil, err
+	}
+
+	// Algorithm must match
+	if matchingKey.jwk.Alg != "" && matchingKey.jwk.Alg != alg {
+		return nil, fmt.Errorf("algorithm missmatch")
 	}
+	publicKey := matchingKey.publicKey
 
-	// Validate the token
+	// Issuer settings take precedence over the top-level ones
+	localConfig := mw.dependencies.AppCtx.Config().Middleware.JWT.Validation.Local
+	localConfig.Issuers = issuer.expectedIssuers()
+	localConfig.Audiences = issuer.config.audiences
+
+	// Validate the token. Time based claims (exp, nbf, iat) are checked by the library, tolerating some clock skew
 	parsedToken, err := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
 		//
 		expectedMethod, localErr := getSigningMethod(alg)
@@ -137,13 +133,101 @@ func (mw *JWTValidationMiddleware) isTokenValid(token string) (bool, error) {
 		}
 
 		return publicKey, nil
-	})
+	}, jwt.WithLeeway(localConfig.Leeway), jwt.WithIssuedAt())
 
 	if err != nil || !parsedToken.Valid {
-		return false, fmt.Errorf("invalid token: %v", err)
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	claims, ok := parsedToken.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, fmt.Errorf("invalid token: %w", errMalformedToken)
 	}
 
-	return true, nil
+	err = checkStandardClaims(claims, localConfig, mw.dependencies.AppCtx.Config().OAuthProtectedResource.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	return issuer, nil
+}
+
+// checkStandardClaims verifies the issuer, audience, required claims and age of a token with a valid signature.
+// Audiences default to the protected resource identifier, as tokens must be issued for this server
+// Ref: https://datatracker.ietf.org/doc/html/rfc9068#section-4
+func checkStandardClaims(claims jwt.MapClaims, localConfig api.JWTValidationLocalConfig, resource string) error {
+
+	if len(localConfig.Issuers) > 0 {
+		issuer, _ := claims.GetIssuer()
+		if !slices.Contains(localConfig.Issuers, issuer) {
+			return fmt.Errorf("%w: '%s'", errInvalidIssuer, issuer)
+		}
+	}
+
+	expectedAudiences := localConfig.Audiences
+	if len(expectedAudiences) == 0 && resource != "" {
+		expectedAudiences = []string{resource}
+	}
+
+	if len(expectedAudiences) > 0 {
+		audiences, err := claims.GetAudience()
+		if err != nil {
+			return fmt.Errorf("%w: %s", errInvalidAudience, err.Error())
+		}
+
+		if !slices.ContainsFunc(audiences, func(audience string) bool {
+			return slices.Contains(expectedAudiences, audience)
+		}) {
+			return errInvalidAudience
+		}
+	}
+
+	for _, claim := range localConfig.RequiredClaims {
+		if _, ok := claims[claim]; !ok {
+			return fmt.Errorf("%w: '%s'", errMissingClaim, claim)
+		}
+	}
+
+	if localConfig.MaxTokenAge > 0 {
+		issuedAt, err := claims.GetIssuedAt()
+		if err != nil || issuedAt == nil {
+			return fmt.Errorf("%w: 'iat'", errMissingClaim)
+		}
+
+		if time.Since(issuedAt.Time) > localConfig.MaxTokenAge+localConfig.Leeway {
+			return errTokenTooOld
+		}
+	}
+
+	return nil
+}
+
+// getTokenDenialReason classifies the errors returned by the token validation into denial reasons
+func getTokenDenialReason(err error) string {
+	switch {
+	case errors.Is(err, errUnknownKid):
+		return denialReasonUnknownKid
+	case errors.Is(err, errJWKSNotLoaded), errors.Is(err, errJWKSUnavailable):
+		return denialReasonJWKSUnavailable
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return denialReasonExpired
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return denialReasonNotYetValid
+	case errors.Is(err, errInvalidIssuer):
+		return denialReasonInvalidIssuer
+	case errors.Is(err, errInvalidAudience):
+		return denialReasonInvalidAudience
+	case errors.Is(err, errMissingClaim):
+		return denialReasonMissingClaim
+	case errors.Is(err, errTokenTooOld):
+		return denialReasonTokenTooOld
+	case errors.Is(err, errAlgorithmNotAllowed):
+		return denialReasonAlgorithmNotAllowed
+	case errors.Is(err, jwt.ErrTokenMalformed), errors.Is(err, errMalformedToken):
+		return denialReasonMalformedToken
+	default:
+		return denialReasonInvalidToken
+	}
 }
 
 // parseJWTHeader extracts the header of a JWT without verifying the signature
@@ -152,7 +236,7 @@ func parseJWTHeader(tokenString string) (map[string]interface{}, error) {
 	//
 	parts := strings.Split(tokenString, ".")
 	if len(parts) != 3 {
-		return nil, fmt.Errorf("malformed token: It must be like header.payload.signature")
+		return nil, errMalformedToken
 	}
 
 	// Extract the header (first part)
@@ -170,13 +254,52 @@ func parseJWTHeader(tokenString string) (map[string]interface{}, error) {
 	return header, nil
 }
 
-// jwkToKey calculate corresponding real key (RSA, EC, etc.) from params present in the JWK
+// checkCertificateBinding verifies the JWT is bound to the verified client certificate
+// presented during the TLS handshake, comparing it with the 'cnf.x5t#S256' claim
+// Ref: https://datatracker.ietf.org/doc/html/rfc8705#section-3
+func checkCertificateBinding(req *http.Request, payload map[string]any) error {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.PeerCertificates) == 0 {
+		return fmt.Errorf("verified client certificate not found")
+	}
+
+	confirmation, ok := payload["cnf"].(map[string]any)
+	if !ok {
+		return fmt.Errorf("jwt payload 'cnf' field not found")
+	}
+
+	expectedThumbprint, ok := confirmation["x5t#S256"].(string)
+	if !ok {
+		return fmt.Errorf("jwt payload 'cnf.x5t#S256' field not found")
+	}
+
+	certificateHash := sha256.Sum256(req.TLS.PeerCertificates[0].Raw)
+	thumbprint := base64.RawURLEncoding.EncodeToString(certificateHash[:])
+
+	if subtle.ConstantTimeCompare([]byte(thumbprint), []byte(expectedThumbprint)) != 1 {
+		return fmt.Errorf("client certificate does not match the token binding")
+	}
+
+	return nil
+}
+
+// jwkToKey calculate corresponding real key (RSA, EC, etc.) from params present in the JWK.
+// Keys published as certificates ('x5c') are taken from the first certificate
 func jwkToKey(jwk *JWK) (interface{}, error) {
+	if len(jwk.X5c) > 0 {
+		return jwkCertificateToKey(jwk)
+	}
+	return jwkParamsToKey(jwk)
+}
+
+// jwkParamsToKey calculate the real key from the params of its type present in the JWK
+func jwkParamsToKey(jwk *JWK) (interface{}, error) {
 	switch jwk.Kty {
 	case "RSA":
 		return jwkToRSAPublicKey(jwk)
 	case "EC":
 		return jwkToECPublicKey(jwk)
+	case "OKP":
+		return jwkToOKPPublicKey(jwk)
 	case "oct": // Symmetric keys
 		return jwkToSymmetricKey(jwk)
 	default:
@@ -252,6 +375,29 @@ func jwkToECPublicKey(jwk *JWK) (*ecdsa.PublicKey, error) {
 	}, nil
 }
 
+// jwkToOKPPublicKey converts a JWK into a public EdDSA key. Only Ed25519 curve is supported
+// Ref: https://datatracker.ietf.org/doc/html/rfc8037#section-2
+func jwkToOKPPublicKey(jwk *JWK) (ed25519.PublicKey, error) {
+	if jwk.X == "" || jwk.Crv == "" {
+		return nil, fmt.Errorf("incomplete OKP key data")
+	}
+
+	if jwk.Crv != "Ed25519" {
+		return nil, fmt.Errorf("unsupported curve: %s", jwk.Crv)
+	}
+
+	xBytes, err := base64.RawURLEncoding.DecodeString(jwk.X)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding public key: %v", err)
+	}
+
+	if len(xBytes) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Ed25519 public key size: %d", len(xBytes))
+	}
+
+	return ed25519.PublicKey(xBytes), nil
+}
+
 // jwkToSymmetricKey converts a JWK into a simetric key (for HMAC)
 func jwkToSymmetricKey(jwk *JWK) ([]byte, error) {
 	if jwk.K == "" {
@@ -281,6 +427,14 @@ func getSigningMethod(alg string) (jwt.SigningMethod, error) {
 		return jwt.SigningMethodES384, nil
 	case "ES512":
 		return jwt.SigningMethodES512, nil
+	case "PS256":
+		return jwt.SigningMethodPS256, nil
+	case "PS384":
+		return jwt.SigningMethodPS384, nil
+	case "PS512":
+		return jwt.SigningMethodPS512, nil
+	case "EdDSA":
+		return jwt.SigningMethodEdDSA, nil
 	case "HS256":
 		return jwt.SigningMethodHS256, nil
 	case "HS384":
@@ -30,7 +30,7 @@ func (mw *AccessLogsMiddleware) Middleware(next http.Handler) http.Handler {
 
 		filteredHeaders := req.Header.Clone()
 		// Redact selected headers
-		for _, redactedHeader := range mw.dependencies.AppCtx.Config.Middleware.AccessLogs.RedactedHeaders {
+		for _, redactedHeader := range mw.dependencies.AppCtx.Config().Middleware.AccessLogs.RedactedHeaders {
 			tmpHeader := filteredHeaders.Get(redactedHeader)
 
 			if len(tmpHeader) >= 10 {
@@ -41,7 +41,7 @@ func (mw *AccessLogsMiddleware) Middleware(next http.Handler) http.Handler {
 		}
 
 		// Exclude selected headers
-		for _, excludedHeader := range mw.dependencies.AppCtx.Config.Middleware.AccessLogs.ExcludedHeaders {
+		for _, excludedHeader := range mw.dependencies.AppCtx.Config().Middleware.AccessLogs.ExcludedHeaders {
 			filteredHeaders.Del(excludedHeader)
 		}
 
@@ -0,0 +1,115 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	//
+	"mcp-go/internal/globals"
+	"mcp-go/internal/metrics"
+
+	//
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+type MetricsMiddlewareDependencies struct {
+	AppCtx *globals.ApplicationContext
+}
+
+// MetricsMiddleware records count and latency of HTTP requests
+type MetricsMiddleware struct {
+	dependencies MetricsMiddlewareDependencies
+}
+
+func NewMetricsMiddleware(deps MetricsMiddlewareDependencies) *MetricsMiddleware {
+	return &MetricsMiddleware{
+		dependencies: deps,
+	}
+}
+
+func (mw *MetricsMiddleware) Middleware(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+
+		recorder := &statusRecorder{ResponseWriter: rw, statusCode: http.StatusOK}
+
+		start := time.Now()
+		next.ServeHTTP(recorder, req)
+		duration := time.Since(start)
+
+		// Use the registered pattern instead of the raw path to keep cardinality bounded
+		path := req.Pattern
+		if path == "" {
+			path = req.URL.Path
+		}
+
+		labels := []string{path, req.Method, strconv.Itoa(recorder.statusCode)}
+		metrics.HTTPRequestsTotal.WithLabelValues(labels...).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(labels...).Observe(duration.Seconds())
+	})
+}
+
+// statusRecorder wraps a http.ResponseWriter to capture the status code sent to the client
+type statusRecorder struct {
+	http.ResponseWriter
+	statusCode int
+}
+
+func (r *statusRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Flush is needed by streaming transports (SSE, StreamableHTTP)
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the original writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+type ToolMetricsMiddlewareDependencies struct {
+	AppCtx *globals.ApplicationContext
+}
+
+// ToolMetricsMiddleware records count, errors and latency of MCP tool calls
+type ToolMetricsMiddleware struct {
+	dependencies ToolMetricsMiddlewareDependencies
+}
+
+func NewToolMetricsMiddleware(deps ToolMetricsMiddlewareDependencies) *ToolMetricsMiddleware {
+	return &ToolMetricsMiddleware{
+		dependencies: deps,
+	}
+}
+
+func (mw *ToolMetricsMiddleware) Name() string {
+	return "metrics"
+}
+
+func (mw *ToolMetricsMiddleware) Middleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		toolName := request.Params.Name
+
+		start := time.Now()
+		result, err := next(ctx, request)
+		duration := time.Since(start)
+
+		metrics.ToolCallsTotal.WithLabelValues(toolName).Inc()
+		metrics.ToolCallDuration.WithLabelValues(toolName).Observe(duration.Seconds())
+
+		if err != nil || (result != nil && result.IsError) {
+			metrics.ToolCallErrorsTotal.WithLabelValues(toolName).Inc()
+		}
+
+		return result, err
+	}
+}
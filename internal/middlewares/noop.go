@@ -20,7 +20,11 @@ func NewNoopMiddleware(dependencies NoopMiddlewareDependencies) *NoopMiddleware
 	}
 }
 
-func (mw *NoopMiddleware) ToolMiddleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+func (mw *NoopMiddleware) Name() string {
+	return "noop"
+}
+
+func (mw *NoopMiddleware) Middleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
 
 	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 		return next(ctx, request)
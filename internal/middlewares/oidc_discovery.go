@@ -0,0 +1,111 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultDiscoveryInterval is the time between two discoveries of the issuer metadata
+	defaultDiscoveryInterval = 1 * time.Hour
+
+	// discoveryMaxBodySize is the maximum size of the metadata accepted from the issuer
+	discoveryMaxBodySize = 1 << 20
+)
+
+// issuerMetadata represents the fields used from the metadata published by an issuer.
+// Ref: https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderMetadata
+// Ref: https://datatracker.ietf.org/doc/html/rfc8414#section-2
+type issuerMetadata struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSUri                          string   `json:"jwks_uri"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported,omitempty"`
+
+	// issuerUri is the URI the metadata was discovered from
+	issuerUri string
+}
+
+// getIssuerMetadataUrls returns the URLs where the issuer may publish its metadata, in order of preference:
+// OpenID Connect discovery first, then OAuth 2.0 Authorization Server Metadata
+func getIssuerMetadataUrls(issuerUri string) ([]string, error) {
+	parsedIssuer, err := url.Parse(issuerUri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid issuer URI: %s", err.Error())
+	}
+
+	issuerPath := strings.TrimSuffix(parsedIssuer.Path, "/")
+
+	openIDUrl := *parsedIssuer
+	openIDUrl.Path = issuerPath + "/.well-known/openid-configuration"
+
+	// RFC 8414 inserts the well-known segment between the host and the path of the issuer
+	oauthUrl := *parsedIssuer
+	oauthUrl.Path = "/.well-known/oauth-authorization-server" + issuerPath
+
+	return []string{openIDUrl.String(), oauthUrl.String()}, nil
+}
+
+// discoverIssuerMetadata retrieves the metadata of an issuer, checking it belongs to the issuer
+func discoverIssuerMetadata(ctx context.Context, client *http.Client, issuerUri string) (*issuerMetadata, error) {
+	metadataUrls, err := getIssuerMetadataUrls(issuerUri)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, metadataUrl := range metadataUrls {
+		metadata, err := fetchIssuerMetadata(ctx, client, metadataUrl)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		// The issuer in the metadata must be the one requested, or tokens would be trusted for the wrong issuer
+		// Ref: https://openid.net/specs/openid-connect-discovery-1_0.html#ProviderConfigurationValidation
+		if strings.TrimSuffix(metadata.Issuer, "/") != strings.TrimSuffix(issuerUri, "/") {
+			return nil, fmt.Errorf("metadata from '%s' belongs to a different issuer: '%s'", metadataUrl, metadata.Issuer)
+		}
+
+		if metadata.JWKSUri == "" {
+			return nil, fmt.Errorf("metadata from '%s' has no 'jwks_uri'", metadataUrl)
+		}
+
+		metadata.issuerUri = issuerUri
+		return metadata, nil
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// fetchIssuerMetadata performs the request for the issuer metadata to one URL
+func fetchIssuerMetadata(ctx context.Context, client *http.Client, metadataUrl string) (*issuerMetadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metadataUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating request for '%s': %s", metadataUrl, err.Error())
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting '%s': %s", metadataUrl, err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code getting '%s': %d", metadataUrl, resp.StatusCode)
+	}
+
+	metadata := &issuerMetadata{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, discoveryMaxBodySize)).Decode(metadata); err != nil {
+		return nil, fmt.Errorf("failed decoding '%s': %s", metadataUrl, err.Error())
+	}
+
+	return metadata, nil
+}
@@ -0,0 +1,410 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/rsa"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	//
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// isJWKSCacheRunning returns whether the cache keeps refreshing its keys in background
+func isJWKSCacheRunning(c *jwksCache) bool {
+	c.refreshMutex.Lock()
+	defer c.refreshMutex.Unlock()
+	return c.cancel != nil
+}
+
+func TestDiscoverIssuerMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		metadata func(issuerUri string) map[string]any
+		wantErr  string
+	}{
+		{
+			name: "OpenID Connect metadata is discovered",
+			path: "/realms/mcp/.well-known/openid-configuration",
+			metadata: func(issuerUri string) map[string]any {
+				return map[string]any{"issuer": issuerUri, "jwks_uri": issuerUri + "/certs"}
+			},
+		},
+		{
+			name: "RFC 8414 metadata is discovered inserting the well-known segment before the path",
+			path: "/.well-known/oauth-authorization-server/realms/mcp",
+			metadata: func(issuerUri string) map[string]any {
+				return map[string]any{"issuer": issuerUri, "jwks_uri": issuerUri + "/certs"}
+			},
+		},
+		{
+			name: "metadata of another issuer is rejected",
+			path: "/realms/mcp/.well-known/openid-configuration",
+			metadata: func(issuerUri string) map[string]any {
+				return map[string]any{"issuer": "https://attacker.example.com", "jwks_uri": issuerUri + "/certs"}
+			},
+			wantErr: "belongs to a different issuer",
+		},
+		{
+			name: "metadata without JWKS is rejected",
+			path: "/realms/mcp/.well-known/openid-configuration",
+			metadata: func(issuerUri string) map[string]any {
+				return map[string]any{"issuer": issuerUri}
+			},
+			wantErr: "has no 'jwks_uri'",
+		},
+		{
+			name: "issuer without metadata is rejected",
+			path: "/not-published",
+			metadata: func(issuerUri string) map[string]any {
+				return map[string]any{}
+			},
+			wantErr: "unexpected status code",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestIssuerServer(t, tt.path, nil)
+			issuerUri := server.URL + "/realms/mcp"
+			server.setMetadata(tt.metadata(issuerUri))
+
+			metadata, err := discoverIssuerMetadata(context.Background(), server.Client(), issuerUri)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("discover error = %v, want one containing '%s'", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("discover error = %s, want none", err.Error())
+			}
+			if metadata.JWKSUri != issuerUri+"/certs" {
+				t.Errorf("jwks_uri = %s, want %s", metadata.JWKSUri, issuerUri+"/certs")
+			}
+		})
+	}
+}
+
+func TestJWTValidationMiddlewareDiscovery(t *testing.T) {
+	key, jwk := newTestRSAKey(t, "first")
+	noAlgKey, noAlgJWK := newTestRSAKey(t, "no-alg")
+	noAlgJWK.Alg = ""
+	jwksServer := newTestJWKSServer(t, jwk, noAlgJWK)
+
+	issuerServer := newTestIssuerServer(t, "/.well-known/openid-configuration", nil)
+	issuerServer.setMetadata(map[string]any{
+		"issuer":   issuerServer.URL,
+		"jwks_uri": jwksServer.URL,
+
+		"id_token_signing_alg_values_supported": []string{"RS256", "PS256"},
+	})
+
+	newMiddleware := func(algorithms []string) (*JWTValidationMiddleware, *testDenialLog) {
+		config := newTestJWTConfig("")
+		config.Middleware.JWT.Validation.Local.IssuerUri = issuerServer.URL
+		config.Middleware.JWT.Validation.Local.Issuers = nil
+		config.Middleware.JWT.Validation.Local.Algorithms = algorithms
+		return newTestJWTValidationMiddleware(t, config)
+	}
+
+	tests := []struct {
+		name          string
+		algorithms    []string
+		issuer        string
+		signingMethod jwt.SigningMethod
+		signingKey    *rsa.PrivateKey
+		kid           string
+		wantStatus    int
+		wantReason    string
+	}{
+		{
+			name:          "token from the discovered issuer is accepted",
+			issuer:        issuerServer.URL,
+			signingMethod: jwt.SigningMethodRS256,
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "token from another issuer is rejected",
+			issuer:        "https://attacker.example.com",
+			signingMethod: jwt.SigningMethodRS256,
+			wantStatus:    http.StatusUnauthorized,
+			wantReason:    denialReasonInvalidIssuer,
+		},
+		{
+			name:          "algorithm advertised by the issuer but not matching the key is rejected",
+			issuer:        issuerServer.URL,
+			signingMethod: jwt.SigningMethodPS256,
+			wantStatus:    http.StatusUnauthorized,
+			wantReason:    denialReasonInvalidToken,
+		},
+		{
+			name:          "algorithm advertised by the issuer is accepted with a key without algorithm",
+			issuer:        issuerServer.URL,
+			signingMethod: jwt.SigningMethodPS256,
+			signingKey:    noAlgKey,
+			kid:           "no-alg",
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "algorithm not advertised by the issuer is rejected",
+			issuer:        issuerServer.URL,
+			signingMethod: jwt.SigningMethodRS512,
+			signingKey:    noAlgKey,
+			kid:           "no-alg",
+			wantStatus:    http.StatusUnauthorized,
+			wantReason:    denialReasonAlgorithmNotAllowed,
+		},
+		{
+			name:          "configured algorithms take precedence over the discovered ones",
+			algorithms:    []string{"RS512"},
+			issuer:        issuerServer.URL,
+			signingMethod: jwt.SigningMethodRS512,
+			signingKey:    noAlgKey,
+			kid:           "no-alg",
+			wantStatus:    http.StatusOK,
+		},
+		{
+			name:          "algorithm advertised by the issuer is rejected when not configured",
+			algorithms:    []string{"RS512"},
+			issuer:        issuerServer.URL,
+			signingMethod: jwt.SigningMethodPS256,
+			signingKey:    noAlgKey,
+			kid:           "no-alg",
+			wantStatus:    http.StatusUnauthorized,
+			wantReason:    denialReasonAlgorithmNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mw, denialLog := newMiddleware(tt.algorithms)
+
+			claims := newTestClaims()
+			claims["iss"] = tt.issuer
+
+			signingKey, kid := key, "first"
+			if tt.signingKey != nil {
+				signingKey, kid = tt.signingKey, tt.kid
+			}
+
+			recorder := serveTestRequest(mw, newTestRequest(signTestTokenWithMethod(t, tt.signingMethod, signingKey, kid, claims)))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+
+			if tt.wantReason != "" {
+				if got := denialLog.last(); got != tt.wantReason {
+					t.Errorf("denial reason = %s, want %s", got, tt.wantReason)
+				}
+			}
+		})
+	}
+}
+
+func TestJWTValidationMiddlewareDiscoveryUnavailable(t *testing.T) {
+	key, _ := newTestRSAKey(t, "first")
+
+	issuerServer := newTestIssuerServer(t, "/not-published", nil)
+
+	config := newTestJWTConfig("")
+	config.Middleware.JWT.Validation.Local.IssuerUri = issuerServer.URL
+	config.Middleware.JWT.Validation.Local.Issuers = nil
+	mw, _ := newTestJWTValidationMiddleware(t, config)
+
+	claims := newTestClaims()
+	claims["iss"] = issuerServer.URL
+
+	// Without metadata there are no keys to check the token with, so requests can not be served until it is discovered
+	recorder := serveTestRequest(mw, newTestRequest(signTestToken(t, key, "first", claims)))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d (body: %s)", recorder.Code, http.StatusServiceUnavailable, recorder.Body.String())
+	}
+}
+
+func TestJWTValidationMiddlewareDiscoveryFailureKeepsIssuer(t *testing.T) {
+	key, jwk := newTestRSAKey(t, "first")
+	jwksServer := newTestJWKSServer(t, jwk)
+
+	issuerServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(issuerServer.Close)
+
+	// Keys do not depend on discovery, so tokens are validated while it keeps failing
+	config := newTestJWTConfig(jwksServer.URL)
+	config.Middleware.JWT.Validation.Local.IssuerUri = issuerServer.URL + "/"
+	config.Middleware.JWT.Validation.Local.Issuers = nil
+	mw, denialLog := newTestJWTValidationMiddleware(t, config)
+
+	tests := []struct {
+		name       string
+		issuer     string
+		wantStatus int
+		wantReason string
+	}{
+		{
+			name:       "token from the configured issuer is accepted",
+			issuer:     issuerServer.URL,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "token from the configured issuer with trailing slash is accepted",
+			issuer:     issuerServer.URL + "/",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "token from another issuer is rejected",
+			issuer:     "https://attacker.example.com",
+			wantStatus: http.StatusUnauthorized,
+			wantReason: denialReasonInvalidIssuer,
+		},
+		{
+			name:       "token without issuer is rejected",
+			wantStatus: http.StatusUnauthorized,
+			wantReason: denialReasonInvalidIssuer,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := newTestClaims()
+			claims["iss"] = tt.issuer
+			if tt.issuer == "" {
+				delete(claims, "iss")
+			}
+
+			recorder := serveTestRequest(mw, newTestRequest(signTestToken(t, key, "first", claims)))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if got := denialLog.last(); tt.wantReason != "" && got != tt.wantReason {
+				t.Errorf("denial reason = %s, want %s", got, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestJWTValidationMiddlewareDiscoveryMovesJWKS(t *testing.T) {
+	firstKey, firstJWK := newTestRSAKey(t, "first")
+	movedKey, movedJWK := newTestRSAKey(t, "moved")
+	firstServer := newTestJWKSServer(t, firstJWK)
+	movedServer := newTestJWKSServer(t, movedJWK)
+
+	issuerServer := newTestIssuerServer(t, "/.well-known/openid-configuration", nil)
+	issuerServer.setMetadata(map[string]any{"issuer": issuerServer.URL, "jwks_uri": firstServer.URL})
+
+	config := newTestJWTConfig("")
+	config.Middleware.JWT.Validation.Local.IssuerUri = issuerServer.URL
+	config.Middleware.JWT.Validation.Local.Issuers = nil
+	mw, _ := newTestJWTValidationMiddleware(t, config)
+
+	firstCache := mw.getTrustedIssuers()[0].jwksCache.Load()
+
+	issuerServer.setMetadata(map[string]any{"issuer": issuerServer.URL, "jwks_uri": movedServer.URL})
+	if err := mw.discoverIssuersMetadata(issuerServer.Client()); err != nil {
+		t.Fatalf("discovery error = %s, want none", err.Error())
+	}
+
+	claims := newTestClaims()
+	claims["iss"] = issuerServer.URL
+
+	if recorder := serveTestRequest(mw, newTestRequest(signTestToken(t, movedKey, "moved", claims))); recorder.Code != http.StatusOK {
+		t.Errorf("status with the moved key = %d, want %d", recorder.Code, http.StatusOK)
+	}
+	if recorder := serveTestRequest(mw, newTestRequest(signTestToken(t, firstKey, "first", claims))); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("status with the previous key = %d, want %d", recorder.Code, http.StatusUnauthorized)
+	}
+
+	if isJWKSCacheRunning(firstCache) {
+		t.Errorf("previous cache is running, want it stopped")
+	}
+	if movedCache := mw.getTrustedIssuers()[0].jwksCache.Load(); !isJWKSCacheRunning(movedCache) {
+		t.Errorf("moved cache is stopped, want it running")
+	}
+}
+
+func TestJWTValidationMiddlewareReloadDuringDiscovery(t *testing.T) {
+	_, firstJWK := newTestRSAKey(t, "first")
+	_, movedJWK := newTestRSAKey(t, "moved")
+	firstServer := newTestJWKSServer(t, firstJWK)
+	movedServer := newTestJWKSServer(t, movedJWK)
+
+	issuerServer := newTestIssuerServer(t, "/.well-known/openid-configuration", nil)
+	issuerServer.setMetadata(map[string]any{"issuer": issuerServer.URL, "jwks_uri": firstServer.URL})
+
+	config := newTestJWTConfig("")
+	config.Middleware.JWT.Validation.Local.IssuerUri = issuerServer.URL
+	config.Middleware.JWT.Validation.Local.Issuers = nil
+	mw, _ := newTestJWTValidationMiddleware(t, config)
+
+	// A reload prepares its issuers reusing the current cache, and discovery moves the JWKS before it is applied
+	preparedIssuers, err := mw.prepareTrustedIssuers(config, true)
+	if err != nil {
+		t.Fatalf("prepare error = %s, want none", err.Error())
+	}
+	reusedCache := preparedIssuers[0].jwksCache.Load()
+
+	issuerServer.setMetadata(map[string]any{"issuer": issuerServer.URL, "jwks_uri": movedServer.URL})
+	if err := mw.discoverIssuersMetadata(issuerServer.Client()); err != nil {
+		t.Fatalf("discovery error = %s, want none", err.Error())
+	}
+	if isJWKSCacheRunning(reusedCache) {
+		t.Fatalf("cache replaced by discovery is running, want it stopped")
+	}
+
+	mw.applyTrustedIssuers(config, preparedIssuers)
+	if !isJWKSCacheRunning(reusedCache) {
+		t.Errorf("cache in use after the reload is stopped, want it running")
+	}
+}
+
+func TestJWTValidationMiddlewareDiscoveryDiscardedByReload(t *testing.T) {
+	_, firstJWK := newTestRSAKey(t, "first")
+	_, movedJWK := newTestRSAKey(t, "moved")
+	firstServer := newTestJWKSServer(t, firstJWK)
+	movedServer := newTestJWKSServer(t, movedJWK)
+
+	issuerServer := newTestIssuerServer(t, "/.well-known/openid-configuration", nil)
+	issuerServer.setMetadata(map[string]any{"issuer": issuerServer.URL, "jwks_uri": firstServer.URL})
+
+	config := newTestJWTConfig("")
+	config.Middleware.JWT.Validation.Local.IssuerUri = issuerServer.URL
+	config.Middleware.JWT.Validation.Local.Issuers = nil
+	mw, _ := newTestJWTValidationMiddleware(t, config)
+
+	preparedIssuers, err := mw.prepareTrustedIssuers(config, true)
+	if err != nil {
+		t.Fatalf("prepare error = %s, want none", err.Error())
+	}
+
+	// The reload is applied while discovery waits for the issuer
+	issuerServer.mutex.Lock()
+	issuerServer.metadata = map[string]any{"issuer": issuerServer.URL, "jwks_uri": movedServer.URL}
+	issuerServer.delay = 200 * time.Millisecond
+	issuerServer.mutex.Unlock()
+
+	discovered := make(chan error)
+	go func() {
+		discovered <- mw.discoverIssuersMetadata(issuerServer.Client())
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	mw.applyTrustedIssuers(config, preparedIssuers)
+	if err := <-discovered; err != nil {
+		t.Fatalf("discovery error = %s, want none", err.Error())
+	}
+
+	issuer := mw.getTrustedIssuers()[0]
+	if issuer != preparedIssuers[0] {
+		t.Fatalf("issuer in use is not the reloaded one")
+	}
+	if cache := issuer.jwksCache.Load(); cache.config.uri != firstServer.URL || !isJWKSCacheRunning(cache) {
+		t.Errorf("cache in use is %s (running: %t), want %s running", cache.config.uri, isJWKSCacheRunning(cache), firstServer.URL)
+	}
+}
@@ -0,0 +1,121 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"os/user"
+
+	//
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// Methods used to authenticate a principal
+	AuthMethodJWT           = "jwt"
+	AuthMethodIntrospection = "introspection"
+	AuthMethodAPIKey        = "api_key"
+	AuthMethodForwarded     = "forwarded"
+	AuthMethodStdio         = "stdio"
+)
+
+// principalContextKey is the key of the principal in the context of requests and tool calls
+type principalContextKey struct{}
+
+// Principal represents the authenticated caller of a request.
+// It is built once by the JWT validation middleware, and read by tools and tool middlewares from the context
+type Principal struct {
+	Subject    string
+	Issuer     string
+	Groups     []string
+	Scopes     []string
+	Claims     map[string]any
+	AuthMethod string
+}
+
+// newPrincipal builds the principal from the claims of its credentials
+func newPrincipal(claims map[string]any, authMethod string) *Principal {
+	if claims == nil {
+		claims = map[string]any{}
+	}
+
+	principal := &Principal{
+		Groups:     getClaimValues(claims, "groups"),
+		Scopes:     getTokenScopes(claims),
+		Claims:     claims,
+		AuthMethod: authMethod,
+	}
+	principal.Subject, _ = claims["sub"].(string)
+	principal.Issuer, _ = claims["iss"].(string)
+
+	return principal
+}
+
+// WithPrincipal returns a copy of the context carrying the principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal carried by the context, if any
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return principal, ok && principal != nil
+}
+
+// HTTPContextFunc carries the principal into the context of MCP requests, for StreamableHTTP and SSE transports.
+// With strategy 'external' this middleware does not authenticate the request, so the principal is built
+// from the token forwarded by the proxy in front, which already validated it.
+// The forwarded header is ignored with any other strategy, as anyone can send it
+func (mw *JWTValidationMiddleware) HTTPContextFunc(ctx context.Context, req *http.Request) context.Context {
+	if principal, ok := PrincipalFromContext(req.Context()); ok {
+		return WithPrincipal(ctx, principal)
+	}
+
+	jwtConfig := mw.dependencies.AppCtx.Config().Middleware.JWT
+	if !jwtConfig.Enabled || jwtConfig.Validation.Strategy != "external" {
+		return ctx
+	}
+
+	forwardedHeader := jwtConfig.Validation.ForwardedHeader
+	if forwardedHeader == "" || req.Header.Get(forwardedHeader) == "" {
+		return ctx
+	}
+
+	claims := jwt.MapClaims{}
+	_, _, err := jwt.NewParser().ParseUnverified(req.Header.Get(forwardedHeader), claims)
+	if err != nil {
+		mw.dependencies.AppCtx.Logger.Debug("error decoding forwarded token", "error", err.Error())
+		return ctx
+	}
+
+	return WithPrincipal(ctx, newPrincipal(claims, AuthMethodForwarded))
+}
+
+// StdioContextFunc carries the principal into the context of MCP requests for stdio transport.
+// There are no credentials there, so the principal is the local user running the server
+func StdioContextFunc(ctx context.Context) context.Context {
+	claims := map[string]any{}
+	if currentUser, err := user.Current(); err == nil {
+		claims["sub"] = currentUser.Username
+	}
+
+	return WithPrincipal(ctx, newPrincipal(claims, AuthMethodStdio))
+}
+
+// getClaimValues returns the values of a claim that can be a list or a single string
+func getClaimValues(claims map[string]any, claim string) []string {
+	switch value := claims[claim].(type) {
+	case string:
+		return []string{value}
+	case []any:
+		var values []string
+		for _, item := range value {
+			if itemString, ok := item.(string); ok {
+				values = append(values, itemString)
+			}
+		}
+		return values
+	case []string:
+		return value
+	}
+	return nil
+}
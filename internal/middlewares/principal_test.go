@@ -0,0 +1,129 @@
+package middlewares
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	//
+	"mcp-go/api"
+
+	//
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestNewPrincipal(t *testing.T) {
+	principal := newPrincipal(map[string]any{
+		"sub":    "alice",
+		"iss":    testIssuer,
+		"groups": []any{"dba", 42, "developers"},
+		"scope":  "mcp:read mcp:write",
+	}, AuthMethodJWT)
+
+	if principal.Subject != "alice" || principal.Issuer != testIssuer || principal.AuthMethod != AuthMethodJWT {
+		t.Errorf("unexpected principal: %+v", principal)
+	}
+	if !slices.Equal(principal.Groups, []string{"dba", "developers"}) {
+		t.Errorf("groups = %v, want [dba developers]", principal.Groups)
+	}
+	if !slices.Equal(principal.Scopes, []string{"mcp:read", "mcp:write"}) {
+		t.Errorf("scopes = %v, want [mcp:read mcp:write]", principal.Scopes)
+	}
+
+	if principal := newPrincipal(nil, AuthMethodStdio); principal.Claims == nil || principal.Subject != "" {
+		t.Errorf("unexpected principal without claims: %+v", principal)
+	}
+}
+
+func TestJWTValidationMiddlewareHTTPContextFunc(t *testing.T) {
+	key, jwk := newTestRSAKey(t, "first")
+	server := newTestJWKSServer(t, jwk)
+
+	externalConfig := newTestJWTConfig(server.URL)
+	externalConfig.Middleware.JWT.Validation.Strategy = "external"
+	mw, _ := newTestJWTValidationMiddleware(t, externalConfig)
+
+	t.Run("principal of the request is carried", func(t *testing.T) {
+		req := newTestRequest("")
+		req = req.WithContext(WithPrincipal(req.Context(), newPrincipal(map[string]any{"sub": "alice"}, AuthMethodJWT)))
+
+		principal, ok := PrincipalFromContext(mw.HTTPContextFunc(context.Background(), req))
+		if !ok || principal.Subject != "alice" || principal.AuthMethod != AuthMethodJWT {
+			t.Errorf("principal = %+v, want the one of the request", principal)
+		}
+	})
+
+	t.Run("principal is built from the forwarded token with strategy external", func(t *testing.T) {
+		req := newTestRequest("")
+		req.Header.Set("X-Validated-Jwt", signTestToken(t, key, "first", jwt.MapClaims{"sub": "bob"}))
+
+		principal, ok := PrincipalFromContext(mw.HTTPContextFunc(context.Background(), req))
+		if !ok || principal.Subject != "bob" || principal.AuthMethod != AuthMethodForwarded {
+			t.Errorf("principal = %+v, want the one of the forwarded token", principal)
+		}
+	})
+
+	t.Run("no principal without credentials", func(t *testing.T) {
+		if principal, ok := PrincipalFromContext(mw.HTTPContextFunc(context.Background(), newTestRequest(""))); ok {
+			t.Errorf("principal = %+v, want none", principal)
+		}
+	})
+}
+
+func TestJWTValidationMiddlewareHTTPContextFuncForgedHeader(t *testing.T) {
+	_, jwk := newTestRSAKey(t, "first")
+	server := newTestJWKSServer(t, jwk)
+
+	// The forwarded token is never verified, so anyone reaching the server can forge it
+	forgedToken := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{"sub": "admin", "scope": "mcp:admin"})
+	forgedHeader, err := forgedToken.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatalf("failed forging token: %s", err.Error())
+	}
+
+	tests := []struct {
+		name      string
+		configure func(t *testing.T, config *api.Configuration)
+	}{
+		{
+			name: "JWT validation disabled",
+			configure: func(t *testing.T, config *api.Configuration) {
+				config.Middleware.JWT.Enabled = false
+			},
+		},
+		{
+			name: "strategy local",
+		},
+		{
+			name: "strategy introspection",
+			configure: func(t *testing.T, config *api.Configuration) {
+				config.Middleware.JWT.Validation.Strategy = "introspection"
+				config.Middleware.JWT.Validation.Introspection = newTestIntrospectionConfig(server.URL)
+			},
+		},
+		{
+			name: "strategy api_key",
+			configure: func(t *testing.T, config *api.Configuration) {
+				config.Middleware.JWT.Validation.Strategy = "api_key"
+				config.Middleware.JWT.Validation.APIKey = newTestAPIKeyConfig(t)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newTestJWTConfig(server.URL)
+			if tt.configure != nil {
+				tt.configure(t, config)
+			}
+			mw, _ := newTestJWTValidationMiddleware(t, config)
+
+			req := newTestRequest("")
+			req.Header.Set("X-Validated-Jwt", forgedHeader)
+
+			if principal, ok := PrincipalFromContext(mw.HTTPContextFunc(context.Background(), req)); ok {
+				t.Errorf("principal = %+v, want none", principal)
+			}
+		})
+	}
+}
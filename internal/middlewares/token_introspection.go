@@ -0,0 +1,323 @@
+package middlewares
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	//
+	"mcp-go/api"
+	"mcp-go/internal/metrics"
+)
+
+const (
+	// defaultIntrospectionTimeout is the time given to each request to the introspection endpoint
+	defaultIntrospectionTimeout = 5 * time.Second
+
+	// defaultIntrospectionCacheTTL is the maximum time an introspection result is reused
+	defaultIntrospectionCacheTTL = 1 * time.Minute
+
+	// defaultIntrospectionCacheMaxEntries is the maximum number of results of active tokens kept
+	defaultIntrospectionCacheMaxEntries = 10000
+
+	// defaultIntrospectionNegativeCacheTTL is the maximum time the result of an inactive token is reused.
+	// It is short, as tokens are not expected to be presented again once rejected
+	defaultIntrospectionNegativeCacheTTL = 5 * time.Second
+
+	// introspectionNegativeCacheMaxEntries is the maximum number of results of inactive tokens kept,
+	// so random tokens can not push the active ones out of the cache
+	introspectionNegativeCacheMaxEntries = 1000
+
+	// introspectionMaxBodySize is the maximum size of the response accepted from the introspection endpoint
+	introspectionMaxBodySize = 1 << 20
+)
+
+var (
+	errTokenInactive = errors.New("token is not active")
+)
+
+// introspectionResult represents a response of the introspection endpoint, kept until it expires
+type introspectionResult struct {
+	tokenHash [sha256.Size]byte
+	response  map[string]any
+	expiresAt time.Time
+}
+
+// introspectionCall represents a request to the introspection endpoint in progress.
+// Concurrent requests carrying the same token wait for it, instead of asking again
+type introspectionCall struct {
+	done     chan struct{}
+	response map[string]any
+	err      error
+}
+
+// introspectionCache keeps results up to a number of entries, evicting the least recently used ones
+type introspectionCache struct {
+	maxEntries int
+	entries    map[[sha256.Size]byte]*list.Element
+	order      *list.List
+}
+
+func newIntrospectionCache(maxEntries int) *introspectionCache {
+	return &introspectionCache{
+		maxEntries: maxEntries,
+		entries:    map[[sha256.Size]byte]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+// get returns the result for a token, or nil when there is none or it expired
+func (c *introspectionCache) get(tokenHash [sha256.Size]byte) *introspectionResult {
+	element, found := c.entries[tokenHash]
+	if !found {
+		return nil
+	}
+
+	result := element.Value.(*introspectionResult)
+	if time.Now().After(result.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, tokenHash)
+		return nil
+	}
+
+	c.order.MoveToFront(element)
+	return result
+}
+
+// add keeps a result, evicting the least recently used one when the cache is full
+func (c *introspectionCache) add(result *introspectionResult) {
+	if element, found := c.entries[result.tokenHash]; found {
+		element.Value = result
+		c.order.MoveToFront(element)
+		return
+	}
+
+	if c.order.Len() >= c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*introspectionResult).tokenHash)
+	}
+
+	c.entries[result.tokenHash] = c.order.PushFront(result)
+}
+
+// tokenIntrospector validates opaque tokens asking the authorization server about them.
+// Results are cached by token hash, so the endpoint is not requested on every call
+// Ref: https://datatracker.ietf.org/doc/html/rfc7662
+type tokenIntrospector struct {
+	client *http.Client
+
+	cacheMutex    sync.Mutex
+	cache         *introspectionCache
+	negativeCache *introspectionCache
+	calls         map[[sha256.Size]byte]*introspectionCall
+
+	// config is the one the cached results were obtained with. Cache is dropped when it changes
+	config api.JWTValidationIntrospectionConfig
+}
+
+func newTokenIntrospector() *tokenIntrospector {
+	ti := &tokenIntrospector{
+		client: &http.Client{},
+		calls:  map[[sha256.Size]byte]*introspectionCall{},
+	}
+	ti.resetCache(api.JWTValidationIntrospectionConfig{})
+	return ti
+}
+
+// resetCache drops the cached results, sizing the cache for the given config.
+// It must be called with the cache mutex held
+func (ti *tokenIntrospector) resetCache(config api.JWTValidationIntrospectionConfig) {
+	maxEntries := config.CacheMaxEntries
+	if maxEntries <= 0 {
+		maxEntries = defaultIntrospectionCacheMaxEntries
+	}
+
+	ti.config = config
+	ti.cache = newIntrospectionCache(maxEntries)
+	ti.negativeCache = newIntrospectionCache(min(maxEntries, introspectionNegativeCacheMaxEntries))
+}
+
+// introspect returns the introspection response of an active token, from the cache when possible
+func (ti *tokenIntrospector) introspect(ctx context.Context, config api.JWTValidationIntrospectionConfig, token string) (map[string]any, error) {
+	tokenHash := sha256.Sum256([]byte(token))
+
+	ti.cacheMutex.Lock()
+	if !equalIntrospectionConfig(ti.config, config) {
+		ti.resetCache(config)
+	}
+
+	cachedResult := ti.cache.get(tokenHash)
+	if cachedResult == nil {
+		cachedResult = ti.negativeCache.get(tokenHash)
+	}
+	if cachedResult != nil {
+		ti.cacheMutex.Unlock()
+		metrics.ObserveTokenIntrospection("cached")
+		return getActiveIntrospectionResponse(cachedResult.response)
+	}
+
+	call, inFlight := ti.calls[tokenHash]
+	if !inFlight {
+		call = &introspectionCall{done: make(chan struct{})}
+		ti.calls[tokenHash] = call
+		go ti.resolve(ctx, config, token, tokenHash, call)
+	}
+	ti.cacheMutex.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-call.done:
+	}
+
+	if call.err != nil {
+		return nil, call.err
+	}
+	return getActiveIntrospectionResponse(call.response)
+}
+
+// resolve asks the introspection endpoint about a token, caching the result for everyone waiting for it.
+// The request outlives the context of whoever started it, as others may be waiting too, so it is only
+// bounded by the configured timeout
+func (ti *tokenIntrospector) resolve(ctx context.Context, config api.JWTValidationIntrospectionConfig, token string, tokenHash [sha256.Size]byte, call *introspectionCall) {
+	response, err := ti.request(context.WithoutCancel(ctx), config, token)
+
+	switch {
+	case err != nil:
+		metrics.ObserveTokenIntrospection("failure")
+	case response["active"] != true:
+		metrics.ObserveTokenIntrospection("inactive")
+	default:
+		metrics.ObserveTokenIntrospection("active")
+	}
+
+	ti.cacheMutex.Lock()
+	if ti.calls[tokenHash] == call {
+		delete(ti.calls, tokenHash)
+	}
+
+	// Results obtained with a replaced config are not kept
+	if err == nil && equalIntrospectionConfig(ti.config, config) {
+		ti.store(config, tokenHash, response)
+	}
+	ti.cacheMutex.Unlock()
+
+	call.response, call.err = response, err
+	close(call.done)
+}
+
+// store caches a result. Results of active tokens are reused up to the configured TTL, but never beyond
+// the expiration of the token. Results of inactive or expired ones are reused for a short while, in a smaller cache.
+// It must be called with the cache mutex held
+func (ti *tokenIntrospector) store(config api.JWTValidationIntrospectionConfig, tokenHash [sha256.Size]byte, response map[string]any) {
+	cacheTTL := config.CacheTTL
+	if cacheTTL <= 0 {
+		cacheTTL = defaultIntrospectionCacheTTL
+	}
+
+	negativeCacheTTL := config.NegativeCacheTTL
+	if negativeCacheTTL <= 0 {
+		negativeCacheTTL = defaultIntrospectionNegativeCacheTTL
+	}
+
+	if _, err := getActiveIntrospectionResponse(response); err != nil {
+		ti.negativeCache.add(&introspectionResult{
+			tokenHash: tokenHash,
+			response:  response,
+			expiresAt: time.Now().Add(min(negativeCacheTTL, cacheTTL)),
+		})
+		return
+	}
+
+	expiresAt := time.Now().Add(cacheTTL)
+	if exp, ok := response["exp"].(float64); ok && time.Unix(int64(exp), 0).Before(expiresAt) {
+		expiresAt = time.Unix(int64(exp), 0)
+	}
+
+	ti.cache.add(&introspectionResult{
+		tokenHash: tokenHash,
+		response:  response,
+		expiresAt: expiresAt,
+	})
+}
+
+// request performs the request to the introspection endpoint, authenticating with the client credentials
+func (ti *tokenIntrospector) request(ctx context.Context, config api.JWTValidationIntrospectionConfig, token string) (map[string]any, error) {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = defaultIntrospectionTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("token_type_hint", "access_token")
+
+	if config.AuthMethod == "client_secret_post" {
+		form.Set("client_id", config.ClientID)
+		form.Set("client_secret", config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.Endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed creating introspection request: %s", err.Error())
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	// Credentials are form-encoded before going into the header
+	// Ref: https://datatracker.ietf.org/doc/html/rfc6749#section-2.3.1
+	if config.AuthMethod != "client_secret_post" {
+		req.SetBasicAuth(url.QueryEscape(config.ClientID), url.QueryEscape(config.ClientSecret))
+	}
+
+	resp, err := ti.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed requesting introspection endpoint: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code from introspection endpoint: %d", resp.StatusCode)
+	}
+
+	response := map[string]any{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, introspectionMaxBodySize)).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed decoding introspection response: %s", err.Error())
+	}
+
+	return response, nil
+}
+
+// getActiveIntrospectionResponse returns the response when it describes an active token.
+// Expiration is checked too, as results may be cached for a while
+func getActiveIntrospectionResponse(response map[string]any) (map[string]any, error) {
+	if response["active"] != true {
+		return nil, errTokenInactive
+	}
+
+	if exp, ok := response["exp"].(float64); ok && time.Now().After(time.Unix(int64(exp), 0)) {
+		return nil, errTokenInactive
+	}
+
+	return response, nil
+}
+
+// equalIntrospectionConfig compares the settings affecting the results of the introspection endpoint,
+// and the size of their cache
+func equalIntrospectionConfig(a, b api.JWTValidationIntrospectionConfig) bool {
+	return a.Endpoint == b.Endpoint && a.ClientID == b.ClientID && a.ClientSecret == b.ClientSecret &&
+		a.CacheMaxEntries == b.CacheMaxEntries
+}
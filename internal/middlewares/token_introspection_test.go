@@ -0,0 +1,308 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	//
+	"mcp-go/api"
+)
+
+func TestTokenIntrospectorIntrospect(t *testing.T) {
+	responses := map[string]map[string]any{
+		"active": {
+			"active": true,
+			"sub":    "alice",
+			"exp":    float64(time.Now().Add(time.Hour).Unix()),
+		},
+		"expired": {
+			"active": true,
+			"sub":    "bob",
+			"exp":    float64(time.Now().Add(-time.Minute).Unix()),
+		},
+	}
+
+	tests := []struct {
+		name         string
+		configure    func(config *api.JWTValidationIntrospectionConfig, server *testIntrospectionServer)
+		token        string
+		wantErr      error
+		wantAnyErr   bool
+		wantSubject  string
+		wantRequests int32
+	}{
+		{
+			name:         "active token is cached",
+			token:        "active",
+			wantSubject:  "alice",
+			wantRequests: 1,
+		},
+		{
+			name:  "credentials are sent in the form with client_secret_post",
+			token: "active",
+			configure: func(config *api.JWTValidationIntrospectionConfig, _ *testIntrospectionServer) {
+				config.AuthMethod = "client_secret_post"
+			},
+			wantSubject:  "alice",
+			wantRequests: 1,
+		},
+		{
+			name:         "inactive token is rejected and cached for a short while",
+			token:        "unknown",
+			wantErr:      errTokenInactive,
+			wantRequests: 1,
+		},
+		{
+			name:  "inactive token is requested again once its negative entry expires",
+			token: "unknown",
+			configure: func(config *api.JWTValidationIntrospectionConfig, _ *testIntrospectionServer) {
+				config.NegativeCacheTTL = time.Nanosecond
+			},
+			wantErr:      errTokenInactive,
+			wantRequests: 2,
+		},
+		{
+			name:         "active token past its expiration is rejected",
+			token:        "expired",
+			wantErr:      errTokenInactive,
+			wantRequests: 1,
+		},
+		{
+			name:  "endpoint failures are not cached nor taken as inactive tokens",
+			token: "active",
+			configure: func(_ *api.JWTValidationIntrospectionConfig, server *testIntrospectionServer) {
+				server.status = http.StatusServiceUnavailable
+			},
+			wantAnyErr:   true,
+			wantRequests: 2,
+		},
+		{
+			name:  "wrong credentials are an endpoint failure",
+			token: "active",
+			configure: func(config *api.JWTValidationIntrospectionConfig, _ *testIntrospectionServer) {
+				config.ClientSecret = "wrong"
+			},
+			wantAnyErr:   true,
+			wantRequests: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestIntrospectionServer(t, responses)
+			config := newTestIntrospectionConfig(server.URL)
+			if tt.configure != nil {
+				tt.configure(&config, server)
+			}
+
+			introspector := newTokenIntrospector()
+
+			// Second call is answered from the cache, when the result is kept
+			for range 2 {
+				response, err := introspector.introspect(context.Background(), config, tt.token)
+
+				switch {
+				case tt.wantAnyErr:
+					if err == nil || errors.Is(err, errTokenInactive) {
+						t.Fatalf("introspect error = %v, want an endpoint failure", err)
+					}
+				case !errors.Is(err, tt.wantErr):
+					t.Fatalf("introspect error = %v, want %v", err, tt.wantErr)
+				case err == nil && response["sub"] != tt.wantSubject:
+					t.Errorf("introspect sub = %v, want %s", response["sub"], tt.wantSubject)
+				}
+			}
+
+			if got := server.requests.Load(); got != tt.wantRequests {
+				t.Errorf("requests to the endpoint = %d, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestTokenIntrospectorCacheIsBounded(t *testing.T) {
+	responses := map[string]map[string]any{
+		"first":  {"active": true, "sub": "first"},
+		"second": {"active": true, "sub": "second"},
+		"third":  {"active": true, "sub": "third"},
+	}
+
+	server := newTestIntrospectionServer(t, responses)
+	config := newTestIntrospectionConfig(server.URL)
+	config.CacheMaxEntries = 2
+
+	introspector := newTokenIntrospector()
+
+	// 'first' is the least recently used one when 'third' arrives, so it is evicted
+	for _, token := range []string{"first", "second", "third", "third", "second", "first"} {
+		if _, err := introspector.introspect(context.Background(), config, token); err != nil {
+			t.Fatalf("introspect error = %s, want none", err.Error())
+		}
+	}
+
+	if got := server.requests.Load(); got != 4 {
+		t.Errorf("requests to the endpoint = %d, want 4", got)
+	}
+
+	if got := introspector.cache.order.Len(); got != 2 {
+		t.Errorf("cached results = %d, want 2", got)
+	}
+}
+
+func TestTokenIntrospectorNegativeCacheIsBounded(t *testing.T) {
+	server := newTestIntrospectionServer(t, nil)
+	config := newTestIntrospectionConfig(server.URL)
+
+	introspector := newTokenIntrospector()
+
+	for i := range introspectionNegativeCacheMaxEntries + 10 {
+		token := "random-" + strconv.Itoa(i)
+		if _, err := introspector.introspect(context.Background(), config, token); !errors.Is(err, errTokenInactive) {
+			t.Fatalf("introspect error = %v, want %v", err, errTokenInactive)
+		}
+	}
+
+	if got := introspector.negativeCache.order.Len(); got != introspectionNegativeCacheMaxEntries {
+		t.Errorf("cached inactive results = %d, want %d", got, introspectionNegativeCacheMaxEntries)
+	}
+	if got := introspector.cache.order.Len(); got != 0 {
+		t.Errorf("cached active results = %d, want 0", got)
+	}
+}
+
+func TestTokenIntrospectorConcurrentLookups(t *testing.T) {
+	responses := map[string]map[string]any{
+		"active": {"active": true, "sub": "alice"},
+	}
+
+	server := newTestIntrospectionServer(t, responses)
+	server.delay = 100 * time.Millisecond
+	config := newTestIntrospectionConfig(server.URL)
+
+	introspector := newTokenIntrospector()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 20)
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := introspector.introspect(context.Background(), config, "active")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("introspect error = %s, want none", err.Error())
+		}
+	}
+
+	if got := server.requests.Load(); got != 1 {
+		t.Errorf("requests to the endpoint = %d, want 1", got)
+	}
+}
+
+func TestTokenIntrospectorConfigChangeDropsCache(t *testing.T) {
+	responses := map[string]map[string]any{
+		"active": {"active": true, "sub": "alice"},
+	}
+
+	server := newTestIntrospectionServer(t, responses)
+	config := newTestIntrospectionConfig(server.URL)
+
+	introspector := newTokenIntrospector()
+	if _, err := introspector.introspect(context.Background(), config, "active"); err != nil {
+		t.Fatalf("introspect error = %s, want none", err.Error())
+	}
+
+	// Revoked client credentials must not keep serving cached results
+	config.ClientSecret = "rotated"
+	if _, err := introspector.introspect(context.Background(), config, "active"); err == nil {
+		t.Fatalf("introspect error = nil, want an endpoint failure")
+	}
+
+	if got := server.requests.Load(); got != 2 {
+		t.Errorf("requests to the endpoint = %d, want 2", got)
+	}
+}
+
+func TestJWTValidationMiddlewareIntrospection(t *testing.T) {
+	responses := map[string]map[string]any{
+		"alice-token": {"active": true, "sub": "alice", "scope": "mcp:read"},
+		"bob-token":   {"active": true, "sub": "bob", "scope": "mcp:read"},
+	}
+
+	tests := []struct {
+		name       string
+		token      string
+		status     int
+		wantStatus int
+		wantError  string
+	}{
+		{
+			name:       "active token is accepted with its principal",
+			token:      "alice-token",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "inactive token is rejected as an invalid token",
+			token:      "unknown-token",
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "invalid_token",
+		},
+		{
+			name:       "allow conditions apply to the introspection response",
+			token:      "bob-token",
+			wantStatus: http.StatusForbidden,
+			wantError:  "insufficient_scope",
+		},
+		{
+			name:       "endpoint outage is answered as unavailable",
+			token:      "alice-token",
+			status:     http.StatusInternalServerError,
+			wantStatus: http.StatusServiceUnavailable,
+			wantError:  "temporarily_unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestIntrospectionServer(t, responses)
+			if tt.status != 0 {
+				server.status = tt.status
+			}
+
+			config := newTestJWTConfig("")
+			config.Middleware.JWT.Validation.Strategy = "introspection"
+			config.Middleware.JWT.Validation.Introspection = newTestIntrospectionConfig(server.URL)
+			config.Middleware.JWT.Validation.Introspection.AllowConditions = []api.JWTValidationAllowCondition{
+				{Expression: `payload.sub == "alice"`},
+			}
+			mw, _ := newTestJWTValidationMiddleware(t, config)
+
+			recorder := serveTestRequest(mw, newTestRequest(tt.token))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+
+			if tt.wantError == "" {
+				if got := recorder.Body.String(); got != "alice" {
+					t.Errorf("principal subject = %s, want alice", got)
+				}
+				return
+			}
+
+			if got := getDenialError(t, recorder); got != tt.wantError {
+				t.Errorf("error = %s, want %s", got, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	//
+	"mcp-go/api"
+	"mcp-go/internal/globals"
+
+	//
+	"github.com/google/cel-go/cel"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+type ToolAuthorizationMiddlewareDependencies struct {
+	AppCtx *globals.ApplicationContext
+}
+
+// ToolAuthorizationMiddleware checks the scopes and the policies in 'middleware.tool_authorization' before calling a tool.
+// Unlike the policies of the JWT validation, they can read the arguments of the call
+type ToolAuthorizationMiddleware struct {
+	dependencies ToolAuthorizationMiddlewareDependencies
+
+	// Carried stuff
+	policies      []*compiledPolicy
+	policiesMutex sync.RWMutex
+}
+
+func NewToolAuthorizationMiddleware(deps ToolAuthorizationMiddlewareDependencies) (*ToolAuthorizationMiddleware, error) {
+	mw := &ToolAuthorizationMiddleware{
+		dependencies: deps,
+	}
+
+	// Precompile the policies to fail-fast
+	policies, err := compileToolPolicies(mw.dependencies.AppCtx.Config().Middleware.ToolAuthorization.Policies)
+	if err != nil {
+		return nil, err
+	}
+	mw.policies = policies
+
+	mw.dependencies.AppCtx.RegisterConfigReloadHook("tool authorization middleware", mw.reloadConfig)
+
+	return mw, nil
+}
+
+// reloadConfig recompiles the policies of a new config, which is rejected when they are broken
+func (mw *ToolAuthorizationMiddleware) reloadConfig(newConfig *api.Configuration) (func(), error) {
+	policies, err := compileToolPolicies(newConfig.Middleware.ToolAuthorization.Policies)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		mw.policiesMutex.Lock()
+		mw.policies = policies
+		mw.policiesMutex.Unlock()
+	}, nil
+}
+
+// compileToolPolicies compiles the policies checked before calling a tool. Variables:
+//   - payload: claims of the principal. Empty when there is none
+//   - tool: name of the called tool
+//   - arguments: arguments of the call, as decoded from JSON
+//   - now: current time, as a timestamp
+func compileToolPolicies(policies []api.Policy) ([]*compiledPolicy, error) {
+	env, err := cel.NewEnv(
+		cel.Variable("payload", cel.DynType),
+		cel.Variable("tool", cel.StringType),
+		cel.Variable("arguments", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("now", cel.TimestampType),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("CEL environment creation error: %s", err.Error())
+	}
+	return compilePolicies(env, policies)
+}
+
+func (mw *ToolAuthorizationMiddleware) Name() string {
+	return "authorization"
+}
+
+func (mw *ToolAuthorizationMiddleware) Middleware(next server.ToolHandlerFunc) server.ToolHandlerFunc {
+
+	return func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+		config := mw.dependencies.AppCtx.Config()
+
+		mw.policiesMutex.RLock()
+		policies := mw.policies
+		mw.policiesMutex.RUnlock()
+
+		if len(policies) == 0 && !isToolScopesEnforced(config) {
+			return next(ctx, request)
+		}
+
+		principal, ok := PrincipalFromContext(ctx)
+		if !ok {
+			principal = newPrincipal(nil, "")
+		}
+		tokenPayload := principal.Claims
+
+		// Tools are hidden from callers without their scopes, but clients may call them anyway
+		if isToolScopesEnforced(config) {
+			missingScopes := getMissingScopes(config, request.Params.Name, principal.Scopes)
+			if len(missingScopes) > 0 {
+				mw.dependencies.AppCtx.Logger.Info("tool call denied for insufficient scope",
+					"subject", principal.Subject, "tool", request.Params.Name, "missing_scopes", missingScopes)
+				return mcp.NewToolResultError(fmt.Sprintf("Insufficient scope: calling tool '%s' requires the scopes '%s'",
+					request.Params.Name, strings.Join(missingScopes, " "))), nil
+			}
+		}
+
+		arguments := request.GetArguments()
+		if arguments == nil {
+			arguments = map[string]any{}
+		}
+
+		activation := map[string]any{
+			"payload":   tokenPayload,
+			"tool":      request.Params.Name,
+			"arguments": arguments,
+			"now":       time.Now(),
+		}
+
+		for _, policy := range policies {
+			out, _, err := policy.program.Eval(activation)
+			if err != nil {
+				mw.dependencies.AppCtx.Logger.Error("CEL tool policy evaluation error", "policy", policy.config.Name, "error", err.Error())
+
+				// Policies in dry-run never reject calls, not even when they are broken
+				if policy.config.DryRun {
+					continue
+				}
+				return mcp.NewToolResultError("Access denied: the authorization policies could not be evaluated"), nil
+			}
+
+			if !policy.isDenied(out.Value()) {
+				continue
+			}
+
+			if policy.config.DryRun {
+				mw.dependencies.AppCtx.Logger.Info("tool policy in dry-run would deny the call",
+					"policy", policy.config.Name, "subject", principal.Subject, "tool", request.Params.Name)
+				continue
+			}
+
+			mw.dependencies.AppCtx.Logger.Info("tool policy denied the call",
+				"policy", policy.config.Name, "subject", principal.Subject, "tool", request.Params.Name)
+
+			message := policy.config.Message
+			if message == "" {
+				message = fmt.Sprintf("calling tool '%s' is not allowed", request.Params.Name)
+			}
+			return mcp.NewToolResultError("Access denied: " + message), nil
+		}
+
+		return next(ctx, request)
+	}
+}
@@ -0,0 +1,208 @@
+package middlewares
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	//
+	"mcp-go/api"
+	"mcp-go/internal/globals"
+
+	//
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// newTestToolCallRequest returns a call to the tool with the arguments
+func newTestToolCallRequest(tool string, arguments map[string]any) mcp.CallToolRequest {
+	request := mcp.CallToolRequest{}
+	request.Params.Name = tool
+	request.Params.Arguments = arguments
+	return request
+}
+
+// getTestToolResultText returns the text of a tool result, joining its contents
+func getTestToolResultText(result *mcp.CallToolResult) string {
+	var texts []string
+	for _, content := range result.Content {
+		if textContent, ok := content.(mcp.TextContent); ok {
+			texts = append(texts, textContent.Text)
+		}
+	}
+	return strings.Join(texts, " ")
+}
+
+func TestToolAuthorizationMiddlewarePolicies(t *testing.T) {
+	policies := []api.Policy{
+		{
+			Name:       "only-dba-queries-prod",
+			Effect:     "deny",
+			Expression: `tool == "database_query" && arguments.connection_name == "prod" && !(has(payload.groups) && "dba" in payload.groups)`,
+			Message:    "Only DBAs can query the 'prod' connection",
+		},
+		{
+			Name:       "no-jwt-generation-outside-development",
+			Effect:     "deny",
+			Expression: `tool == "generate_jwt" && "production" != "development"`,
+			Message:    "Generating tokens is only available in development",
+		},
+		{
+			Name:       "own-tenant-only",
+			Effect:     "allow",
+			Expression: `!has(arguments.tenant) || (has(payload.tenant) && arguments.tenant == payload.tenant)`,
+		},
+		{
+			Name:       "no-drop-dry-run",
+			Effect:     "deny",
+			Expression: `has(arguments.query) && arguments.query.startsWith("DROP")`,
+			DryRun:     true,
+		},
+	}
+
+	tests := []struct {
+		name        string
+		policies    []api.Policy
+		tool        string
+		arguments   map[string]any
+		claims      map[string]any
+		wantDenied  string
+		wantAllowed bool
+	}{
+		{
+			name:        "DBA can query the prod connection",
+			tool:        "database_query",
+			arguments:   map[string]any{"connection_name": "prod"},
+			claims:      map[string]any{"sub": "alice", "groups": []string{"dba"}},
+			wantAllowed: true,
+		},
+		{
+			name:       "other callers can not query the prod connection",
+			tool:       "database_query",
+			arguments:  map[string]any{"connection_name": "prod"},
+			claims:     map[string]any{"sub": "bob", "groups": []string{"developers"}},
+			wantDenied: "Access denied: Only DBAs can query the 'prod' connection",
+		},
+		{
+			name:        "other callers can query other connections",
+			tool:        "database_query",
+			arguments:   map[string]any{"connection_name": "staging"},
+			claims:      map[string]any{"sub": "bob"},
+			wantAllowed: true,
+		},
+		{
+			name:       "callers without principal can not query the prod connection",
+			tool:       "database_query",
+			arguments:  map[string]any{"connection_name": "prod"},
+			wantDenied: "Access denied: Only DBAs can query the 'prod' connection",
+		},
+		{
+			name:       "generating tokens is denied outside development",
+			tool:       "generate_jwt",
+			claims:     map[string]any{"sub": "alice", "groups": []string{"dba"}},
+			wantDenied: "Access denied: Generating tokens is only available in development",
+		},
+		{
+			name:        "allow policy over arguments and claims is met",
+			tool:        "hello",
+			arguments:   map[string]any{"tenant": "acme"},
+			claims:      map[string]any{"sub": "alice", "tenant": "acme"},
+			wantAllowed: true,
+		},
+		{
+			name:       "allow policy over arguments and claims is not met",
+			tool:       "hello",
+			arguments:  map[string]any{"tenant": "acme"},
+			claims:     map[string]any{"sub": "bob", "tenant": "other"},
+			wantDenied: "Access denied: calling tool 'hello' is not allowed",
+		},
+		{
+			name:        "policy in dry-run does not deny",
+			tool:        "database_query",
+			arguments:   map[string]any{"connection_name": "staging", "query": "DROP TABLE users"},
+			claims:      map[string]any{"sub": "bob"},
+			wantAllowed: true,
+		},
+		{
+			name: "broken policy denies the call",
+			policies: []api.Policy{
+				{Name: "broken", Effect: "deny", Expression: `arguments.missing == "value"`},
+			},
+			tool:       "hello",
+			claims:     map[string]any{"sub": "alice"},
+			wantDenied: "Access denied: the authorization policies could not be evaluated",
+		},
+		{
+			name: "broken policy in dry-run does not deny",
+			policies: []api.Policy{
+				{Name: "broken", Effect: "deny", Expression: `arguments.missing == "value"`, DryRun: true},
+			},
+			tool:        "hello",
+			claims:      map[string]any{"sub": "alice"},
+			wantAllowed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &api.Configuration{}
+			config.Middleware.ToolAuthorization.Policies = policies
+			if tt.policies != nil {
+				config.Middleware.ToolAuthorization.Policies = tt.policies
+			}
+			mw := newTestToolAuthorizationMiddleware(t, config)
+
+			called := false
+			next := func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				called = true
+				return mcp.NewToolResultText("done"), nil
+			}
+
+			result, err := mw.Middleware(next)(newTestPrincipalContext(tt.claims), newTestToolCallRequest(tt.tool, tt.arguments))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+
+			if called != tt.wantAllowed {
+				t.Fatalf("tool called = %t, want %t (result: %s)", called, tt.wantAllowed, getTestToolResultText(result))
+			}
+			if tt.wantAllowed {
+				return
+			}
+
+			if !result.IsError {
+				t.Errorf("result is not an error, want one")
+			}
+			if got := getTestToolResultText(result); got != tt.wantDenied {
+				t.Errorf("result = %s, want %s", got, tt.wantDenied)
+			}
+		})
+	}
+}
+
+func TestToolAuthorizationMiddlewareBrokenPolicies(t *testing.T) {
+	brokenPolicies := []api.Policy{
+		{Name: "broken", Effect: "deny", Expression: `tool ==`},
+	}
+
+	t.Run("broken policies are rejected at startup", func(t *testing.T) {
+		config := &api.Configuration{}
+		config.Middleware.ToolAuthorization.Policies = brokenPolicies
+
+		appCtx := globals.NewApplicationContextFromConfig(context.Background(), slog.New(slog.DiscardHandler), config)
+		_, err := NewToolAuthorizationMiddleware(ToolAuthorizationMiddlewareDependencies{AppCtx: appCtx})
+		if err == nil || !strings.Contains(err.Error(), "CEL expression of policy 'broken' compilation exited with error") {
+			t.Errorf("error = %v, want a compilation error of the policy", err)
+		}
+	})
+
+	t.Run("broken policies are rejected on reload", func(t *testing.T) {
+		mw := newTestToolAuthorizationMiddleware(t, &api.Configuration{})
+
+		newConfig := &api.Configuration{}
+		newConfig.Middleware.ToolAuthorization.Policies = brokenPolicies
+		if _, err := mw.reloadConfig(newConfig); err == nil {
+			t.Errorf("reload error = nil, want a compilation error of the policy")
+		}
+	})
+}
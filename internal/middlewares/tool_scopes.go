@@ -0,0 +1,81 @@
+package middlewares
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	//
+	"mcp-go/api"
+
+	//
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// FilterTools hides the tools the caller lacks the scopes for, so clients only list the tools they can call
+func (mw *ToolAuthorizationMiddleware) FilterTools(ctx context.Context, tools []mcp.Tool) []mcp.Tool {
+	config := mw.dependencies.AppCtx.Config()
+	if !isToolScopesEnforced(config) {
+		return tools
+	}
+
+	var grantedScopes []string
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		grantedScopes = principal.Scopes
+	}
+
+	allowedTools := []mcp.Tool{}
+	for _, tool := range tools {
+		if len(getMissingScopes(config, tool.Name, grantedScopes)) == 0 {
+			allowedTools = append(allowedTools, tool)
+		}
+	}
+	return allowedTools
+}
+
+// isToolScopesEnforced returns whether the scopes of the tools are checked.
+// They are not with stdio transport (the default one, when no type is set), as there is no token there
+func isToolScopesEnforced(config *api.Configuration) bool {
+	transportType := config.Server.Transport.Type
+	if transportType == "" {
+		transportType = "stdio"
+	}
+	return len(config.Middleware.ToolAuthorization.ToolScopes) > 0 && transportType != "stdio"
+}
+
+// getMissingScopes returns the scopes required by a tool that were not granted to the caller.
+// Tools without configured scopes require none
+func getMissingScopes(config *api.Configuration, toolName string, grantedScopes []string) []string {
+	var missingScopes []string
+	for _, toolScopes := range config.Middleware.ToolAuthorization.ToolScopes {
+		if toolScopes.Tool != toolName {
+			continue
+		}
+
+		for _, scope := range toolScopes.Scopes {
+			if !slices.Contains(grantedScopes, scope) {
+				missingScopes = append(missingScopes, scope)
+			}
+		}
+	}
+	return missingScopes
+}
+
+// getTokenScopes returns the scopes granted to the caller, from the 'scope' claim (space separated, RFC 8693)
+// or the 'scp' claim (list or space separated), used by some authorization servers instead
+func getTokenScopes(tokenClaims map[string]any) []string {
+	var scopes []string
+	for _, claim := range []string{"scope", "scp"} {
+		switch value := tokenClaims[claim].(type) {
+		case string:
+			scopes = append(scopes, strings.Fields(value)...)
+		case []any:
+			for _, item := range value {
+				if scope, ok := item.(string); ok {
+					scopes = append(scopes, scope)
+				}
+			}
+		}
+	}
+	return scopes
+}
@@ -0,0 +1,219 @@
+package middlewares
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	//
+	"mcp-go/api"
+
+	//
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+func TestGetTokenScopes(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims map[string]any
+		want   []string
+	}{
+		{
+			name:   "space separated 'scope' claim",
+			claims: map[string]any{"scope": "mcp:read  mcp:write"},
+			want:   []string{"mcp:read", "mcp:write"},
+		},
+		{
+			name:   "list 'scp' claim",
+			claims: map[string]any{"scp": []any{"mcp:read", 42, "mcp:write"}},
+			want:   []string{"mcp:read", "mcp:write"},
+		},
+		{
+			name:   "space separated 'scp' claim",
+			claims: map[string]any{"scp": "mcp:read mcp:write"},
+			want:   []string{"mcp:read", "mcp:write"},
+		},
+		{
+			name:   "both claims are merged",
+			claims: map[string]any{"scope": "mcp:read", "scp": []any{"mcp:write"}},
+			want:   []string{"mcp:read", "mcp:write"},
+		},
+		{
+			name:   "no scopes",
+			claims: map[string]any{"sub": "alice"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getTokenScopes(tt.claims); !slices.Equal(got, tt.want) {
+				t.Errorf("getTokenScopes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToolAuthorizationMiddlewareFilterTools(t *testing.T) {
+	tools := []mcp.Tool{
+		mcp.NewTool("hello"),
+		mcp.NewTool("delete"),
+		mcp.NewTool("whoami"),
+	}
+
+	tests := []struct {
+		name      string
+		configure func(config *api.Configuration)
+		claims    map[string]any
+		want      []string
+	}{
+		{
+			name:   "tools are listed to callers granted all their scopes",
+			claims: map[string]any{"scope": "mcp:read mcp:admin"},
+			want:   []string{"hello", "delete", "whoami"},
+		},
+		{
+			name:   "tools are hidden from callers lacking any of their scopes",
+			claims: map[string]any{"scope": "mcp:read"},
+			want:   []string{"hello", "whoami"},
+		},
+		{
+			name: "only tools without scopes are listed without principal",
+			want: []string{"whoami"},
+		},
+		{
+			name: "scopes are not enforced with stdio transport",
+			configure: func(config *api.Configuration) {
+				config.Server.Transport.Type = "stdio"
+			},
+			want: []string{"hello", "delete", "whoami"},
+		},
+		{
+			name: "scopes are not enforced without transport type, as stdio is the default",
+			configure: func(config *api.Configuration) {
+				config.Server.Transport.Type = ""
+			},
+			want: []string{"hello", "delete", "whoami"},
+		},
+		{
+			name: "tools are not filtered without configured scopes",
+			configure: func(config *api.Configuration) {
+				config.Middleware.ToolAuthorization.ToolScopes = nil
+			},
+			want: []string{"hello", "delete", "whoami"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newTestToolScopesConfig()
+			if tt.configure != nil {
+				tt.configure(config)
+			}
+			mw := newTestToolAuthorizationMiddleware(t, config)
+
+			var got []string
+			for _, tool := range mw.FilterTools(newTestPrincipalContext(tt.claims), tools) {
+				got = append(got, tool.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("FilterTools() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestToolAuthorizationMiddlewareScopes(t *testing.T) {
+	tests := []struct {
+		name        string
+		configure   func(config *api.Configuration)
+		claims      map[string]any
+		tool        string
+		wantDenied  bool
+		wantMessage string
+	}{
+		{
+			name:   "call is allowed with the required scopes",
+			claims: map[string]any{"scp": []any{"mcp:read"}},
+			tool:   "hello",
+		},
+		{
+			name:        "call is denied naming the missing scopes",
+			claims:      map[string]any{"scope": "mcp:read"},
+			tool:        "delete",
+			wantDenied:  true,
+			wantMessage: "requires the scopes 'mcp:admin'",
+		},
+		{
+			name:        "call is denied without principal",
+			tool:        "hello",
+			wantDenied:  true,
+			wantMessage: "requires the scopes 'mcp:read'",
+		},
+		{
+			name: "tool without scopes can be called by anyone",
+			tool: "whoami",
+		},
+		{
+			name: "scopes are not enforced with stdio transport",
+			configure: func(config *api.Configuration) {
+				config.Server.Transport.Type = "stdio"
+			},
+			tool: "delete",
+		},
+		{
+			name: "scopes are not enforced without transport type, as stdio is the default",
+			configure: func(config *api.Configuration) {
+				config.Server.Transport.Type = ""
+			},
+			tool: "delete",
+		},
+		{
+			name: "policies apply once the scopes are granted",
+			configure: func(config *api.Configuration) {
+				config.Middleware.ToolAuthorization.Policies = []api.Policy{
+					{Name: "no-delete", Expression: `tool == "delete"`, Effect: "deny", Message: "deleting is disabled"},
+				}
+			},
+			claims:      map[string]any{"scope": "mcp:read mcp:admin"},
+			tool:        "delete",
+			wantDenied:  true,
+			wantMessage: "Access denied: deleting is disabled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newTestToolScopesConfig()
+			if tt.configure != nil {
+				tt.configure(config)
+			}
+			mw := newTestToolAuthorizationMiddleware(t, config)
+
+			called := false
+			next := func(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+				called = true
+				return mcp.NewToolResultText("called"), nil
+			}
+
+			request := mcp.CallToolRequest{}
+			request.Params.Name = tt.tool
+
+			result, err := mw.Middleware(next)(newTestPrincipalContext(tt.claims), request)
+			if err != nil {
+				t.Fatalf("call error = %s, want none", err.Error())
+			}
+
+			if called == tt.wantDenied || result.IsError != tt.wantDenied {
+				t.Fatalf("tool called = %t, error result = %t, want denied = %t", called, result.IsError, tt.wantDenied)
+			}
+
+			if tt.wantDenied {
+				text, _ := result.Content[0].(mcp.TextContent)
+				if !strings.Contains(text.Text, tt.wantMessage) {
+					t.Errorf("result = %s, want it to contain %s", text.Text, tt.wantMessage)
+				}
+			}
+		})
+	}
+}
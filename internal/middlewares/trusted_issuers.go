@@ -0,0 +1,478 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	//
+	"mcp-go/api"
+
+	//
+	"github.com/google/cel-go/cel"
+)
+
+const (
+	// defaultTrustedIssuerName is the name of the issuer defined by the top-level settings of the local validation
+	defaultTrustedIssuerName = "default"
+)
+
+// trustedIssuerConfig represents the settings of an issuer whose tokens are accepted
+type trustedIssuerConfig struct {
+	name string
+
+	// issuers are the accepted 'iss' values. When empty, the discovered one is expected, or any without discovery
+	issuers []string
+
+	// discoveryUri is the issuer URI used to discover the metadata. Discovery is disabled when empty
+	discoveryUri string
+
+	jwksUri         string
+	audiences       []string
+	algorithms      []string
+	allowConditions []api.JWTValidationAllowCondition
+
+	// allowSymmetricKeys enables HS* algorithms, with 'oct' keys from the JWKS. They are rejected otherwise,
+	// as a symmetric key published in a JWKS is known by anyone able to read it
+	allowSymmetricKeys bool
+
+	// x5cCAFile is the CA bundle validating the 'x5c' chains of the keys. Chains are not validated when empty
+	x5cCAFile string
+
+	// Keys not fetched from a remote. The file is watched, while 'jwks' and 'publicKeys' are written in the config
+	jwksFile   string
+	jwks       string
+	publicKeys []api.JWTValidationPublicKey
+}
+
+// trustedIssuer represents the runtime state of an issuer whose tokens are accepted
+type trustedIssuer struct {
+	config      trustedIssuerConfig
+	celPrograms []*cel.Program
+
+	// staticKeys are the keys written in the config, indexed by 'kid'. They take precedence over the cached ones
+	staticKeys map[string]*jwksKey
+
+	metadata  atomic.Pointer[issuerMetadata]
+	jwksCache atomic.Pointer[jwksCache]
+}
+
+// getTrustedIssuersConfig returns the issuers configured for the local JWT validation.
+// Top-level settings (key sources, 'issuer_uri', 'issuers') define an issuer too, named 'default'
+func getTrustedIssuersConfig(localConfig api.JWTValidationLocalConfig) []trustedIssuerConfig {
+	var configs []trustedIssuerConfig
+
+	if localConfig.JWKSUri != "" || localConfig.IssuerUri != "" || localConfig.JWKSFile != "" ||
+		localConfig.JWKS != "" || len(localConfig.PublicKeys) > 0 {
+		configs = append(configs, trustedIssuerConfig{
+			name:         defaultTrustedIssuerName,
+			issuers:      localConfig.Issuers,
+			discoveryUri: localConfig.IssuerUri,
+			jwksUri:      localConfig.JWKSUri,
+			audiences:    localConfig.Audiences,
+			algorithms:   localConfig.Algorithms,
+
+			allowSymmetricKeys: localConfig.AllowSymmetricKeys,
+			x5cCAFile:          localConfig.X5CCAFile,
+
+			jwksFile:   localConfig.JWKSFile,
+			jwks:       localConfig.JWKS,
+			publicKeys: localConfig.PublicKeys,
+		})
+	}
+
+	for _, issuerConfig := range localConfig.TrustedIssuers {
+		config := trustedIssuerConfig{
+			name:            issuerConfig.Issuer,
+			issuers:         []string{issuerConfig.Issuer},
+			jwksUri:         issuerConfig.JWKSUri,
+			audiences:       issuerConfig.Audiences,
+			algorithms:      issuerConfig.Algorithms,
+			allowConditions: issuerConfig.AllowConditions,
+
+			allowSymmetricKeys: issuerConfig.AllowSymmetricKeys,
+			x5cCAFile:          issuerConfig.X5CCAFile,
+
+			jwksFile:   issuerConfig.JWKSFile,
+			jwks:       issuerConfig.JWKS,
+			publicKeys: issuerConfig.PublicKeys,
+		}
+
+		// Keys are discovered only when none of their sources is configured
+		if config.jwksUri == "" && config.jwksFile == "" && config.jwks == "" && len(config.publicKeys) == 0 {
+			config.discoveryUri = issuerConfig.Issuer
+		}
+
+		if len(config.audiences) == 0 {
+			config.audiences = localConfig.Audiences
+		}
+
+		if len(config.algorithms) == 0 {
+			config.algorithms = localConfig.Algorithms
+		}
+
+		if config.x5cCAFile == "" {
+			config.x5cCAFile = localConfig.X5CCAFile
+		}
+
+		configs = append(configs, config)
+	}
+
+	return configs
+}
+
+// expectedIssuers returns the accepted 'iss' values. The discovered issuer is expected when none is configured,
+// and the URI it is discovered from until its metadata arrives, so failed discoveries never widen the accepted ones.
+// It is empty only for issuers configured without 'issuers' nor 'issuer_uri', which accept any 'iss'
+func (ti *trustedIssuer) expectedIssuers() []string {
+	if len(ti.config.issuers) > 0 {
+		return ti.config.issuers
+	}
+
+	if metadata := ti.metadata.Load(); metadata != nil {
+		return []string{metadata.Issuer}
+	}
+
+	// Discovery accepts the metadata of the issuer with or without trailing slash, so both are expected
+	if ti.config.discoveryUri != "" {
+		issuerUri := strings.TrimSuffix(ti.config.discoveryUri, "/")
+		return []string{issuerUri, issuerUri + "/"}
+	}
+	return nil
+}
+
+// allowedAlgorithms returns the accepted 'alg' values. The discovered ones are used when none is configured:
+// they describe ID tokens, but issuers sign access tokens with the same keys, so they only narrow the accepted ones.
+// Without any of them, the 'alg' of each key is enforced
+func (ti *trustedIssuer) allowedAlgorithms() []string {
+	if len(ti.config.algorithms) > 0 {
+		return ti.config.algorithms
+	}
+
+	if metadata := ti.metadata.Load(); metadata != nil {
+		return metadata.IDTokenSigningAlgValuesSupported
+	}
+	return nil
+}
+
+// jwksUri returns the URI of the JWKS of the issuer, configured or discovered
+func (ti *trustedIssuer) jwksUri() string {
+	if ti.config.jwksUri != "" {
+		return ti.config.jwksUri
+	}
+
+	if metadata := ti.metadata.Load(); metadata != nil {
+		return metadata.JWKSUri
+	}
+	return ""
+}
+
+// getJWKSCacheConfig returns the settings of the JWKS cache of the issuer, from the shared ones
+func (ti *trustedIssuer) getJWKSCacheConfig(cacheSettings jwksCacheConfig) jwksCacheConfig {
+	cacheConfig := cacheSettings
+	cacheConfig.uri = ti.jwksUri()
+	cacheConfig.file = ti.config.jwksFile
+	cacheConfig.allowSymmetricKeys = ti.config.allowSymmetricKeys
+	cacheConfig.x5cCAFile = ti.config.x5cCAFile
+	return cacheConfig
+}
+
+// lookupKey returns the key matching the 'kid', from the keys written in the config or the cached ones
+func (ti *trustedIssuer) lookupKey(ctx context.Context, kid string) (*jwksKey, error) {
+	if key, ok := ti.staticKeys[kid]; ok {
+		return key, nil
+	}
+
+	cache := ti.jwksCache.Load()
+	if cache == nil {
+		if len(ti.staticKeys) > 0 {
+			return nil, errUnknownKid
+		}
+		return nil, errJWKSNotLoaded
+	}
+	return cache.lookup(ctx, kid)
+}
+
+// getTrustedIssuers returns the issuers currently accepted
+func (mw *JWTValidationMiddleware) getTrustedIssuers() []*trustedIssuer {
+	issuers := mw.trustedIssuers.Load()
+	if issuers == nil {
+		return nil
+	}
+	return *issuers
+}
+
+// selectTrustedIssuer returns the issuer in charge of a token, chosen by its unverified 'iss' claim.
+// Issuers accepting any 'iss', configured without 'issuers' nor 'issuer_uri', are only chosen when no other matches
+func (mw *JWTValidationMiddleware) selectTrustedIssuer(tokenIssuer string) *trustedIssuer {
+	var fallback *trustedIssuer
+
+	for _, issuer := range mw.getTrustedIssuers() {
+		expectedIssuers := issuer.expectedIssuers()
+		if len(expectedIssuers) == 0 {
+			if fallback == nil {
+				fallback = issuer
+			}
+			continue
+		}
+
+		if slices.Contains(expectedIssuers, tokenIssuer) {
+			return issuer
+		}
+	}
+
+	return fallback
+}
+
+// prepareTrustedIssuers builds the issuers required by a config: CEL expressions are compiled,
+// metadata is discovered and keys are loaded. State of unchanged issuers is reused.
+// When 'strict' is set, discovery failures are returned, otherwise they are retried in background
+func (mw *JWTValidationMiddleware) prepareTrustedIssuers(config *api.Configuration, strict bool) ([]*trustedIssuer, error) {
+	if !config.Middleware.JWT.Enabled || config.Middleware.JWT.Validation.Strategy != "local" {
+		return nil, nil
+	}
+
+	localConfig := config.Middleware.JWT.Validation.Local
+	cacheSettings := getJWKSCacheConfig(localConfig)
+	client := &http.Client{Timeout: jwksRequestTimeout}
+
+	currentIssuers := map[string]*trustedIssuer{}
+	for _, issuer := range mw.getTrustedIssuers() {
+		currentIssuers[issuer.config.name] = issuer
+	}
+
+	var issuers []*trustedIssuer
+	for _, issuerConfig := range getTrustedIssuersConfig(localConfig) {
+		celPrograms, err := compileAllowConditions(issuerConfig.allowConditions)
+		if err != nil {
+			return nil, fmt.Errorf("issuer '%s': %s", issuerConfig.name, err.Error())
+		}
+
+		staticKeys, err := parseStaticKeys(issuerConfig)
+		if err != nil {
+			return nil, fmt.Errorf("issuer '%s': %s", issuerConfig.name, err.Error())
+		}
+
+		issuer := &trustedIssuer{
+			config:      issuerConfig,
+			celPrograms: celPrograms,
+			staticKeys:  staticKeys,
+		}
+		previousIssuer := currentIssuers[issuerConfig.name]
+
+		// Discover the metadata, unless it was already discovered from the same place
+		if issuerConfig.discoveryUri != "" {
+			if previousIssuer != nil && previousIssuer.config.discoveryUri == issuerConfig.discoveryUri && previousIssuer.metadata.Load() != nil {
+				issuer.metadata.Store(previousIssuer.metadata.Load())
+			} else {
+				ctx, cancel := context.WithTimeout(mw.dependencies.AppCtx.Context, cacheSettings.startupTimeout)
+				metadata, err := discoverIssuerMetadata(ctx, client, issuerConfig.discoveryUri)
+				cancel()
+
+				switch {
+				case err != nil && strict:
+					return nil, fmt.Errorf("failed discovering metadata of issuer '%s': %s", issuerConfig.name, err.Error())
+				case err != nil:
+					mw.dependencies.AppCtx.Logger.Error("failed discovering issuer metadata on start, retrying in background",
+						"issuer", issuerConfig.name, "error", err.Error())
+				default:
+					issuer.metadata.Store(metadata)
+				}
+			}
+		}
+
+		// Load the keys, unless they are already cached with the same settings.
+		// Requests are not accepted until keys are loaded, so the first load is not delayed to the background
+		cacheConfig := issuer.getJWKSCacheConfig(cacheSettings)
+		if cacheConfig.uri != "" || cacheConfig.file != "" {
+			if previousIssuer != nil && previousIssuer.jwksCache.Load() != nil && previousIssuer.jwksCache.Load().config == cacheConfig {
+				issuer.jwksCache.Store(previousIssuer.jwksCache.Load())
+			} else {
+				issuer.jwksCache.Store(mw.loadJWKSCache(cacheConfig))
+			}
+		}
+
+		issuers = append(issuers, issuer)
+	}
+
+	return issuers, nil
+}
+
+// applyTrustedIssuers replaces the accepted issuers, starting their caches and stopping the unused ones
+func (mw *JWTValidationMiddleware) applyTrustedIssuers(config *api.Configuration, issuers []*trustedIssuer) {
+	mw.trustedIssuersMutex.Lock()
+	defer mw.trustedIssuersMutex.Unlock()
+
+	mw.swapTrustedIssuers(issuers)
+
+	if !config.Middleware.JWT.Enabled || config.Middleware.JWT.Validation.Strategy != "local" {
+		return
+	}
+
+	mw.jwksReadinessOnce.Do(func() {
+		mw.dependencies.AppCtx.RegisterReadinessCheck("jwks", mw.checkJWKSLoaded)
+	})
+
+	mw.discoveryOnce.Do(func() {
+		go mw.watchIssuersMetadata()
+	})
+}
+
+// swapTrustedIssuers replaces the accepted issuers, starting their caches and stopping the unused ones.
+// Caches stopped by a previous swap are started again when reused. It must be called with the issuers mutex held
+func (mw *JWTValidationMiddleware) swapTrustedIssuers(issuers []*trustedIssuer) {
+	previousIssuers := mw.getTrustedIssuers()
+	mw.trustedIssuers.Store(&issuers)
+
+	cachesInUse := map[*jwksCache]bool{}
+	for _, issuer := range issuers {
+		if cache := issuer.jwksCache.Load(); cache != nil {
+			cachesInUse[cache] = true
+			cache.start(mw.dependencies.AppCtx.Context)
+		}
+	}
+
+	for _, issuer := range previousIssuers {
+		if cache := issuer.jwksCache.Load(); cache != nil && !cachesInUse[cache] {
+			cache.stop()
+		}
+	}
+}
+
+// loadJWKSCache creates a JWKS cache, loading its keys for the first time
+func (mw *JWTValidationMiddleware) loadJWKSCache(cacheConfig jwksCacheConfig) *jwksCache {
+	cache := newJWKSCache(mw.dependencies.AppCtx.Logger, cacheConfig)
+	if err := cache.load(mw.dependencies.AppCtx.Context); err != nil {
+		mw.dependencies.AppCtx.Logger.Error("failed loading JWKS on start, retrying in background",
+			"uri", cacheConfig.uri, "file", cacheConfig.file, "error", err.Error())
+	}
+	return cache
+}
+
+// checkJWKSLoaded verifies the keys of every issuer were loaded at least once.
+// Issuers with keys written in the config are ready from the start
+func (mw *JWTValidationMiddleware) checkJWKSLoaded(ctx context.Context) error {
+	var errs []error
+	for _, issuer := range mw.getTrustedIssuers() {
+		if len(issuer.staticKeys) > 0 {
+			continue
+		}
+
+		cache := issuer.jwksCache.Load()
+		if cache == nil {
+			errs = append(errs, fmt.Errorf("issuer '%s': %s", issuer.config.name, errJWKSNotLoaded.Error()))
+			continue
+		}
+
+		if err := cache.loaded(); err != nil {
+			errs = append(errs, fmt.Errorf("issuer '%s': %s", issuer.config.name, err.Error()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// watchIssuersMetadata discovers the metadata of the issuers from time to time.
+// When an issuer moves its JWKS, a new cache is loaded for it
+func (mw *JWTValidationMiddleware) watchIssuersMetadata() {
+	client := &http.Client{Timeout: jwksRequestTimeout}
+
+	// Metadata discovered on start is fresh enough, unless some discovery failed
+	wait := mw.getDiscoveryInterval()
+	for _, issuer := range mw.getTrustedIssuers() {
+		if issuer.config.discoveryUri != "" && issuer.metadata.Load() == nil {
+			wait = jwksInitialBackoff
+		}
+	}
+
+	backoff := jwksInitialBackoff
+	for {
+		select {
+		case <-mw.dependencies.AppCtx.Context.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		discoveryInterval := mw.getDiscoveryInterval()
+		if err := mw.discoverIssuersMetadata(client); err != nil {
+			mw.dependencies.AppCtx.Logger.Error("failed discovering issuers metadata, keeping previous one",
+				"retry_in", backoff.String(), "error", err.Error())
+
+			wait = backoff
+			backoff = min(backoff*2, discoveryInterval)
+			continue
+		}
+
+		wait = discoveryInterval
+		backoff = jwksInitialBackoff
+	}
+}
+
+// discoverIssuersMetadata refreshes the metadata of every issuer relying on discovery.
+// Requests are done without holding the issuers mutex, and issuers are replaced at once afterwards by copies
+// with the new metadata and caches. When a config reload replaced them meanwhile, the copies are dropped
+func (mw *JWTValidationMiddleware) discoverIssuersMetadata(client *http.Client) error {
+	currentIssuers := mw.trustedIssuers.Load()
+	if currentIssuers == nil {
+		return nil
+	}
+
+	cacheSettings := getJWKSCacheConfig(mw.dependencies.AppCtx.Config().Middleware.JWT.Validation.Local)
+
+	var errs []error
+	issuers := make([]*trustedIssuer, 0, len(*currentIssuers))
+	for _, issuer := range *currentIssuers {
+		if issuer.config.discoveryUri == "" {
+			issuers = append(issuers, issuer)
+			continue
+		}
+
+		metadata, err := discoverIssuerMetadata(mw.dependencies.AppCtx.Context, client, issuer.config.discoveryUri)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("issuer '%s': %s", issuer.config.name, err.Error()))
+			issuers = append(issuers, issuer)
+			continue
+		}
+
+		discoveredIssuer := &trustedIssuer{
+			config:      issuer.config,
+			celPrograms: issuer.celPrograms,
+			staticKeys:  issuer.staticKeys,
+		}
+		discoveredIssuer.metadata.Store(metadata)
+
+		// New caches are started on the swap, so dropped ones leave nothing running
+		cacheConfig := discoveredIssuer.getJWKSCacheConfig(cacheSettings)
+		if currentCache := issuer.jwksCache.Load(); currentCache != nil && currentCache.config == cacheConfig {
+			discoveredIssuer.jwksCache.Store(currentCache)
+		} else {
+			discoveredIssuer.jwksCache.Store(mw.loadJWKSCache(cacheConfig))
+		}
+
+		issuers = append(issuers, discoveredIssuer)
+	}
+
+	mw.trustedIssuersMutex.Lock()
+	defer mw.trustedIssuersMutex.Unlock()
+
+	if mw.trustedIssuers.Load() != currentIssuers {
+		mw.dependencies.AppCtx.Logger.Info("issuers replaced during discovery, discarding discovered metadata")
+		return errors.Join(errs...)
+	}
+	mw.swapTrustedIssuers(issuers)
+
+	return errors.Join(errs...)
+}
+
+// getDiscoveryInterval returns the time between two discoveries of the issuers metadata
+func (mw *JWTValidationMiddleware) getDiscoveryInterval() time.Duration {
+	discoveryInterval := mw.dependencies.AppCtx.Config().Middleware.JWT.Validation.Local.DiscoveryInterval
+	if discoveryInterval <= 0 {
+		discoveryInterval = defaultDiscoveryInterval
+	}
+	return discoveryInterval
+}
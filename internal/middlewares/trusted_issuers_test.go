@@ -0,0 +1,203 @@
+package middlewares
+
+import (
+	"crypto/rsa"
+	"net/http"
+	"testing"
+
+	//
+	"mcp-go/api"
+)
+
+func TestJWTValidationMiddlewareTrustedIssuers(t *testing.T) {
+	firstKey, firstJWK := newTestRSAKey(t, "shared-kid")
+	secondKey, secondJWK := newTestRSAKey(t, "shared-kid")
+	fallbackKey, fallbackJWK := newTestRSAKey(t, "fallback")
+
+	firstServer := newTestJWKSServer(t, firstJWK)
+	secondServer := newTestJWKSServer(t, secondJWK)
+	fallbackServer := newTestJWKSServer(t, fallbackJWK)
+
+	// Top-level settings accept any issuer with their own keys, as no 'issuers' are set
+	config := newTestJWTConfig(fallbackServer.URL)
+	config.Middleware.JWT.Validation.Local.Issuers = nil
+	config.Middleware.JWT.Validation.Local.TrustedIssuers = []api.JWTValidationTrustedIssuer{
+		{
+			Issuer:  "https://first.example.com",
+			JWKSUri: firstServer.URL,
+		},
+		{
+			Issuer:    "https://second.example.com",
+			JWKSUri:   secondServer.URL,
+			Audiences: []string{"second-audience"},
+			AllowConditions: []api.JWTValidationAllowCondition{
+				{Expression: `payload.sub == "bob"`},
+			},
+		},
+	}
+	mw, denialLog := newTestJWTValidationMiddleware(t, config)
+
+	tests := []struct {
+		name       string
+		issuer     string
+		audience   string
+		subject    string
+		key        *rsa.PrivateKey
+		kid        string
+		wantStatus int
+		wantReason string
+	}{
+		{
+			name:       "token is validated with the keys of its issuer",
+			issuer:     "https://first.example.com",
+			key:        firstKey,
+			kid:        "shared-kid",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "token claiming an issuer but signed by another one is rejected",
+			issuer:     "https://first.example.com",
+			key:        secondKey,
+			kid:        "shared-kid",
+			wantStatus: http.StatusUnauthorized,
+			wantReason: denialReasonInvalidToken,
+		},
+		{
+			name:       "audiences of the issuer replace the top-level ones",
+			issuer:     "https://second.example.com",
+			audience:   "second-audience",
+			subject:    "bob",
+			key:        secondKey,
+			kid:        "shared-kid",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "top-level audience is rejected for an issuer with its own ones",
+			issuer:     "https://second.example.com",
+			subject:    "bob",
+			key:        secondKey,
+			kid:        "shared-kid",
+			wantStatus: http.StatusUnauthorized,
+			wantReason: denialReasonInvalidAudience,
+		},
+		{
+			name:       "allow conditions of the issuer are enforced",
+			issuer:     "https://second.example.com",
+			audience:   "second-audience",
+			key:        secondKey,
+			kid:        "shared-kid",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "allow conditions of an issuer do not apply to others",
+			issuer:     "https://first.example.com",
+			key:        firstKey,
+			kid:        "shared-kid",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "unknown issuer falls back to the top-level keys",
+			issuer:     "https://other.example.com",
+			key:        fallbackKey,
+			kid:        "fallback",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "unknown issuer can not use the keys of a trusted issuer",
+			issuer:     "https://other.example.com",
+			key:        firstKey,
+			kid:        "shared-kid",
+			wantStatus: http.StatusUnauthorized,
+			wantReason: denialReasonUnknownKid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := newTestClaims()
+			claims["iss"] = tt.issuer
+			if tt.audience != "" {
+				claims["aud"] = tt.audience
+			}
+			if tt.subject != "" {
+				claims["sub"] = tt.subject
+			}
+
+			token := signTestToken(t, tt.key, tt.kid, claims)
+
+			recorder := serveTestRequest(mw, newTestRequest(token))
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body: %s)", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+
+			if tt.wantReason != "" {
+				if got := denialLog.last(); got != tt.wantReason {
+					t.Errorf("denial reason = %s, want %s", got, tt.wantReason)
+				}
+			}
+		})
+	}
+}
+
+func TestSelectTrustedIssuer(t *testing.T) {
+	_, jwk := newTestRSAKey(t, "first")
+	server := newTestJWKSServer(t, jwk)
+
+	config := newTestJWTConfig(server.URL)
+	config.Middleware.JWT.Validation.Local.TrustedIssuers = []api.JWTValidationTrustedIssuer{
+		{Issuer: "https://first.example.com", JWKSUri: server.URL},
+	}
+
+	tests := []struct {
+		name            string
+		topLevelIssuers []string
+		tokenIssuer     string
+		wantIssuer      string
+	}{
+		{
+			name:            "trusted issuer is chosen by its 'iss'",
+			topLevelIssuers: []string{testIssuer},
+			tokenIssuer:     "https://first.example.com",
+			wantIssuer:      "https://first.example.com",
+		},
+		{
+			name:            "top-level settings are chosen by their issuers",
+			topLevelIssuers: []string{testIssuer},
+			tokenIssuer:     testIssuer,
+			wantIssuer:      defaultTrustedIssuerName,
+		},
+		{
+			name:            "no issuer is chosen for an unknown 'iss'",
+			topLevelIssuers: []string{testIssuer},
+			tokenIssuer:     "https://other.example.com",
+		},
+		{
+			name:        "top-level settings accepting any issuer are the fallback",
+			tokenIssuer: "https://other.example.com",
+			wantIssuer:  defaultTrustedIssuerName,
+		},
+		{
+			name:        "trusted issuer wins over the fallback",
+			tokenIssuer: "https://first.example.com",
+			wantIssuer:  "https://first.example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testConfig := *config
+			testConfig.Middleware.JWT.Validation.Local.Issuers = tt.topLevelIssuers
+			mw, _ := newTestJWTValidationMiddleware(t, &testConfig)
+
+			issuer := mw.selectTrustedIssuer(tt.tokenIssuer)
+
+			var gotIssuer string
+			if issuer != nil {
+				gotIssuer = issuer.config.name
+			}
+			if gotIssuer != tt.wantIssuer {
+				t.Errorf("selected issuer = '%s', want '%s'", gotIssuer, tt.wantIssuer)
+			}
+		})
+	}
+}
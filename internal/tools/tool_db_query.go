@@ -2,9 +2,11 @@ package tools
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
@@ -52,6 +54,41 @@ func createGormConnection(driver, connectionString string) (*gorm.DB, error) {
 
 // dbConnections stores active database connections
 var dbConnections = make(map[string]*DatabaseConnection)
+var dbConnectionsMutex sync.RWMutex
+
+// getDatabaseConnection returns the stored connection with the given name
+func getDatabaseConnection(name string) (*DatabaseConnection, bool) {
+	dbConnectionsMutex.RLock()
+	defer dbConnectionsMutex.RUnlock()
+
+	conn, exists := dbConnections[name]
+	return conn, exists
+}
+
+// listDatabaseConnections returns a copy of the stored connections
+func listDatabaseConnections() map[string]*DatabaseConnection {
+	dbConnectionsMutex.RLock()
+	defer dbConnectionsMutex.RUnlock()
+
+	conns := make(map[string]*DatabaseConnection, len(dbConnections))
+	for name, conn := range dbConnections {
+		conns[name] = conn
+	}
+	return conns
+}
+
+// storeDatabaseConnection stores a connection under the given name, closing the previous one if exists
+func storeDatabaseConnection(name string, conn *DatabaseConnection) {
+	dbConnectionsMutex.Lock()
+	defer dbConnectionsMutex.Unlock()
+
+	if existingConn, exists := dbConnections[name]; exists {
+		if sqlDB, err := existingConn.Connection.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}
+	dbConnections[name] = conn
+}
 
 func (tm *ToolsManager) HandleToolDatabaseQuery(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	arguments := request.GetArguments()
@@ -98,7 +135,7 @@ func (tm *ToolsManager) HandleToolDatabaseQuery(ctx context.Context, request mcp
 	}
 
 	// Obtener la conexión a la base de datos
-	dbConn, exists := dbConnections[connectionName]
+	dbConn, exists := getDatabaseConnection(connectionName)
 	if !exists {
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
@@ -386,13 +423,6 @@ func (tm *ToolsManager) HandleToolDatabaseConnect(ctx context.Context, request m
 		}, nil
 	}
 
-	// Close existing connection if it exists
-	if existingConn, exists := dbConnections[connectionName]; exists {
-		if sqlDB, err := existingConn.Connection.DB(); err == nil {
-			sqlDB.Close()
-		}
-	}
-
 	// Create new GORM connection
 	db, err := createGormConnection(driver, connectionString)
 	if err != nil {
@@ -407,11 +437,11 @@ func (tm *ToolsManager) HandleToolDatabaseConnect(ctx context.Context, request m
 		}, nil
 	}
 
-	// Store the connection (the connection test is already done in createGormConnection)
-	dbConnections[connectionName] = &DatabaseConnection{
+	// Store the connection, closing the existing one if any (the connection test is already done in createGormConnection)
+	storeDatabaseConnection(connectionName, &DatabaseConnection{
 		Driver:     driver,
 		Connection: db,
-	}
+	})
 
 	// Prepare success message with connection examples
 	var examples strings.Builder
@@ -458,7 +488,8 @@ func (tm *ToolsManager) HandleToolDatabaseConnect(ctx context.Context, request m
 }
 
 func (tm *ToolsManager) HandleToolDatabaseList(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
-	if len(dbConnections) == 0 {
+	connections := listDatabaseConnections()
+	if len(connections) == 0 {
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
 				mcp.TextContent{
@@ -474,7 +505,7 @@ func (tm *ToolsManager) HandleToolDatabaseList(ctx context.Context, request mcp.
 	result.WriteString("| Connection Name | Driver | Status |\n")
 	result.WriteString("|---|---|---|\n")
 
-	for name, conn := range dbConnections {
+	for name, conn := range connections {
 		status := "Active"
 		// Test connection using GORM
 		if sqlDB, err := conn.Connection.DB(); err != nil {
@@ -535,13 +566,6 @@ func (tm *ToolsManager) HandleToolDatabaseConnectFromEnv(ctx context.Context, re
 		}, nil
 	}
 
-	// Close existing connection if it exists
-	if existingConn, exists := dbConnections[connectionName]; exists {
-		if sqlDB, err := existingConn.Connection.DB(); err == nil {
-			sqlDB.Close()
-		}
-	}
-
 	// Create new GORM connection
 	db, err := createGormConnection("postgres", databaseURL)
 	if err != nil {
@@ -556,11 +580,11 @@ func (tm *ToolsManager) HandleToolDatabaseConnectFromEnv(ctx context.Context, re
 		}, nil
 	}
 
-	// Store the connection (the connection test is already done in createGormConnection)
-	dbConnections[connectionName] = &DatabaseConnection{
+	// Store the connection, closing the existing one if any (the connection test is already done in createGormConnection)
+	storeDatabaseConnection(connectionName, &DatabaseConnection{
 		Driver:     "postgres",
 		Connection: db,
-	}
+	})
 
 	// Prepare success message
 	maskedURL := maskDatabaseURL(databaseURL)
@@ -575,6 +599,47 @@ func (tm *ToolsManager) HandleToolDatabaseConnectFromEnv(ctx context.Context, re
 	}, nil
 }
 
+// checkDatabaseConnections verifies all the stored database connections are alive
+func (tm *ToolsManager) checkDatabaseConnections(ctx context.Context) error {
+	var errs []error
+	for name, conn := range listDatabaseConnections() {
+		sqlDB, err := conn.Connection.DB()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("connection '%s': %v", name, err))
+			continue
+		}
+
+		if err := sqlDB.PingContext(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("connection '%s': %v", name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// closeDatabaseConnections closes all the stored database connections
+func (tm *ToolsManager) closeDatabaseConnections(ctx context.Context) error {
+	dbConnectionsMutex.Lock()
+	defer dbConnectionsMutex.Unlock()
+
+	var errs []error
+	for name, conn := range dbConnections {
+		sqlDB, err := conn.Connection.DB()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error getting SQL DB for connection '%s': %v", name, err))
+			continue
+		}
+
+		if err := sqlDB.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("error closing connection '%s': %v", name, err))
+			continue
+		}
+		delete(dbConnections, name)
+	}
+
+	return errors.Join(errs...)
+}
+
 // maskDatabaseURL oculta la contraseña en la URL para mostrarla de forma segura
 func maskDatabaseURL(url string) string {
 	// Buscar el patrón usuario:contraseña@
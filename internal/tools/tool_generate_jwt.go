@@ -2,6 +2,7 @@ package tools
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
 	"time"
 
@@ -30,12 +31,14 @@ func (tm *ToolsManager) HandleToolGenerateJWT(ctx context.Context, request mcp.C
 		}
 	}
 
-	// Create JWT claims
+	// Create JWT claims. The ID allows revoking the token before it expires
 	now := time.Now()
+	tokenID := rand.Text()
 	claims := jwt.MapClaims{
 		"iss":                "mcp-go-dev",
 		"sub":                "test-user-12345",
 		"aud":                "mcp-go",
+		"jti":                tokenID,
 		"iat":                now.Unix(),
 		"exp":                now.Add(24 * time.Hour).Unix(), // Expires in 24 hours
 		"name":               name,
@@ -73,6 +76,7 @@ func (tm *ToolsManager) HandleToolGenerateJWT(ctx context.Context, request mcp.C
 - **Email:** %s  
 - **Username:** %s
 - **Subject:** test-user-12345
+- **Token ID:** %s
 - **Issuer:** mcp-go-dev
 - **Audience:** mcp-go
 - **Expires:** %s (24 hours from now)
@@ -109,6 +113,7 @@ Set this as the JWT environment variable in your Claude Desktop config:
 		name,
 		email,
 		username,
+		tokenID,
 		now.Add(24*time.Hour).Format("2006-01-02 15:04:05 MST"),
 		tokenString)
 
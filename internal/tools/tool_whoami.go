@@ -7,74 +7,45 @@ import (
 	"strings"
 	"time"
 
-	"github.com/golang-jwt/jwt/v5"
+	"mcp-go/internal/middlewares"
+
 	"github.com/mark3labs/mcp-go/mcp"
 )
 
 func (tm *ToolsManager) HandleToolWhoami(ctx context.Context, request mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 
-	validatedJwt := request.Header.Get(tm.dependencies.AppCtx.Config.Middleware.JWT.Validation.ForwardedHeader)
+	// The principal was authenticated before reaching the tool, whatever the transport
+	principal, ok := middlewares.PrincipalFromContext(ctx)
 
 	// Debug information
-	debugInfo := fmt.Sprintf(`🔍 **Debug Information**
-
-**JWT Header Name:** %s
-**JWT Present:** %t
-**JWT Length:** %d
-**Full JWT:** %s
-
----
-
-`, tm.dependencies.AppCtx.Config.Middleware.JWT.Validation.ForwardedHeader,
-		validatedJwt != "",
-		len(validatedJwt),
-		validatedJwt)
-
-	if validatedJwt == "" {
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				mcp.TextContent{
-					Type: "text",
-					Text: debugInfo + "❌ **Error:** JWT is empty. User information is not available\n\n**Possible causes:**\n- JWT validation is disabled in config\n- JWT header not being forwarded\n- Server not receiving JWT from client",
-				},
-			},
-		}, nil
-	}
-
-	// Try to parse JWT
-	token, _, err := new(jwt.Parser).ParseUnverified(validatedJwt, jwt.MapClaims{})
-	if err != nil {
-		return &mcp.CallToolResult{
-			Content: []mcp.Content{
-				mcp.TextContent{
-					Type: "text",
-					Text: debugInfo + fmt.Sprintf("❌ **Error parsing JWT:** %v\n\nThe JWT might be malformed or use an unsupported format.", err),
-				},
-			},
-		}, nil
+	debugInfo := "🔍 **Debug Information**\n\n"
+	if ok {
+		debugInfo += fmt.Sprintf("**Authentication Method:** %s\n", principal.AuthMethod)
 	}
+	debugInfo += fmt.Sprintf("**Principal Present:** %t\n\n---\n\n", ok)
 
-	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
 		return &mcp.CallToolResult{
 			Content: []mcp.Content{
 				mcp.TextContent{
 					Type: "text",
-					Text: debugInfo + "❌ **Error:** Unable to parse JWT claims\n\nThe JWT structure might be invalid.",
+					Text: debugInfo + "❌ **Error:** User information is not available\n\n**Possible causes:**\n- JWT validation is disabled in config\n- JWT header not being forwarded\n- Server not receiving JWT from client",
 				},
 			},
 		}, nil
 	}
 
+	claims := principal.Claims
+
 	// Build user information
 	var userInfo strings.Builder
 	userInfo.WriteString(debugInfo)
-	userInfo.WriteString("✅ **JWT Successfully Decoded!**\n\n")
+	userInfo.WriteString("✅ **Caller Successfully Authenticated!**\n\n")
 	userInfo.WriteString("👤 **User Information**\n\n")
 
 	// Standard JWT claims
-	if sub, ok := claims["sub"].(string); ok && sub != "" {
-		userInfo.WriteString(fmt.Sprintf("**Subject (User ID):** %s\n", sub))
+	if principal.Subject != "" {
+		userInfo.WriteString(fmt.Sprintf("**Subject (User ID):** %s\n", principal.Subject))
 	}
 
 	if name, ok := claims["name"].(string); ok && name != "" {
@@ -90,8 +61,16 @@ func (tm *ToolsManager) HandleToolWhoami(ctx context.Context, request mcp.CallTo
 	}
 
 	// Issuer information
-	if iss, ok := claims["iss"].(string); ok && iss != "" {
-		userInfo.WriteString(fmt.Sprintf("**Issuer:** %s\n", iss))
+	if principal.Issuer != "" {
+		userInfo.WriteString(fmt.Sprintf("**Issuer:** %s\n", principal.Issuer))
+	}
+
+	if len(principal.Groups) > 0 {
+		userInfo.WriteString(fmt.Sprintf("**Groups:** %s\n", strings.Join(principal.Groups, ", ")))
+	}
+
+	if len(principal.Scopes) > 0 {
+		userInfo.WriteString(fmt.Sprintf("**Scopes:** %s\n", strings.Join(principal.Scopes, " ")))
 	}
 
 	// Audience
@@ -135,6 +114,7 @@ func (tm *ToolsManager) HandleToolWhoami(ctx context.Context, request mcp.CallTo
 	standardClaims := map[string]bool{
 		"sub": true, "name": true, "email": true, "preferred_username": true,
 		"iss": true, "aud": true, "iat": true, "exp": true, "nbf": true, "jti": true,
+		"groups": true, "scope": true, "scp": true,
 	}
 
 	for key, value := range claims {